@@ -1,43 +1,125 @@
 package blockchain
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
 	"time"
-
-	"github.com/nthskyradiated/blockchain-in-golang/utils"
 )
 
+const BlockVersion = 1
+
 type Block struct {
 	Timestamp    int64
 	Hash         []byte
 	Transactions []*Transaction
 	PrevHash     []byte
-	Nonce        int
+	Nonce        uint32
 	Height       int
+	Bits         uint32
+	MerkleRoot   []byte
+	Version      int32
 }
 
-func (b *Block) HashTransactions() []byte {
+// BlockHeader holds every field the proof-of-work commits to. The block hash
+// is the SHA-256 of its serialized form.
+type BlockHeader struct {
+	Version    int32
+	PrevHash   []byte
+	MerkleRoot []byte
+	Timestamp  int64
+	Height     int
+	Bits       uint32
+	Nonce      uint32
+}
+
+// HeaderSize is the length of a serialized header: version, previous hash,
+// Merkle root, timestamp, height, target bits and nonce, in that order.
+const HeaderSize = 4 + 32 + 32 + 8 + 8 + 4 + 4
+
+func (b *Block) Header() BlockHeader {
+	return BlockHeader{b.Version, b.PrevHash, b.MerkleRoot, b.Timestamp, b.Height, b.Bits, b.Nonce}
+}
+
+func (b *Block) setHeader(h BlockHeader) {
+	b.Version = h.Version
+	b.PrevHash = h.PrevHash
+	b.MerkleRoot = h.MerkleRoot
+	b.Timestamp = h.Timestamp
+	b.Height = h.Height
+	b.Bits = h.Bits
+	b.Nonce = h.Nonce
+}
+
+// Serialize encodes the header big-endian with fixed-width fields. Hashes are
+// written as 32 bytes, so the genesis block's empty PrevHash becomes zeros.
+func (h BlockHeader) Serialize() []byte {
+	data := make([]byte, 0, HeaderSize)
+	data = binary.BigEndian.AppendUint32(data, uint32(h.Version))
+	data = append(data, fixedHash(h.PrevHash)...)
+	data = append(data, fixedHash(h.MerkleRoot)...)
+	data = binary.BigEndian.AppendUint64(data, uint64(h.Timestamp))
+	data = binary.BigEndian.AppendUint64(data, uint64(h.Height))
+	data = binary.BigEndian.AppendUint32(data, h.Bits)
+	data = binary.BigEndian.AppendUint32(data, h.Nonce)
+	return data
+}
+
+func (h BlockHeader) Hash() []byte {
+	hash := sha256.Sum256(h.Serialize())
+	return hash[:]
+}
+
+func fixedHash(hash []byte) []byte {
+	fixed := make([]byte, 32)
+	copy(fixed, hash)
+	return fixed
+}
+
+// MerkleTree builds the tree the block header commits to. Its leaves are the
+// transaction hashes, recomputed rather than taken from the stored IDs.
+func (b *Block) MerkleTree() *MerkleTree {
 	var txHashes [][]byte
 
 	for _, tx := range b.Transactions {
-		txHashes = append(txHashes, tx.Serialize())
+		txHashes = append(txHashes, tx.Hash())
 	}
-	tree := NewMerkleTree(txHashes)
-	return tree.Root.Data
+	return NewMerkleTree(txHashes)
 }
 
-func CreateBlock(txs []*Transaction, prevHash []byte, height int) *Block {
-	block := &Block{time.Now().Unix(), []byte{}, txs, prevHash, 0, height}
+func (b *Block) HashTransactions() []byte {
+	return b.MerkleTree().Root.Data
+}
+
+// CreateBlock assembles a block on top of prevHash and mines it, giving up
+// with ctx's error if ctx is cancelled first. The block is timestamped no
+// earlier than minTime, which has to be after the parent's median time past.
+func CreateBlock(ctx context.Context, txs []*Transaction, prevHash []byte, height int, bits uint32, minTime int64) (*Block, error) {
+	block := &Block{
+		Timestamp:    max(time.Now().Unix(), minTime),
+		Hash:         []byte{},
+		Transactions: txs,
+		PrevHash:     prevHash,
+		Height:       height,
+		Bits:         bits,
+		Version:      BlockVersion,
+	}
+	block.MerkleRoot = block.HashTransactions()
 	pow := NewProofOfWork(block)
-	nonce, hash := pow.Run()
-	block.Hash = hash[:]
-	block.Nonce = nonce
-	return block
+	stats, err := pow.Mine(ctx, MiningWorkers)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("%x\n", block.Hash)
+	fmt.Printf("Mined with %s\n", stats)
+	return block, nil
 }
 
-func GenesisBlock(coinbase *Transaction) *Block {
-	return CreateBlock([]*Transaction{coinbase}, []byte{}, 0)
+func GenesisBlock(coinbase *Transaction) (*Block, error) {
+	return CreateBlock(context.Background(), []*Transaction{coinbase}, []byte{}, 0, InitialBits, 0)
 }
 
 func (b *Block) Serialize() []byte {
-	return utils.Serialize(b)
+	return b.appendTo(nil)
 }
@@ -2,6 +2,7 @@ package blockchain
 
 import (
 	"bytes"
+	"context"
 	"crypto/ecdsa"
 	"encoding/hex"
 	"errors"
@@ -9,16 +10,21 @@ import (
 	"log"
 	"os"
 	"path/filepath"
-	"runtime"
 	"strings"
 	"github.com/dgraph-io/badger"
-	"github.com/nthskyradiated/blockchain-in-golang/utils"
 )
 
 const (
 	dbPath = "./tmp/blocks_%s"
 	genesisData = "Genesis Block"
 )
+
+var (
+	ErrNoChain         = errors.New("no existing blockchain found")
+	ErrChainExists     = errors.New("blockchain already exists")
+	ErrBlockNotFound   = errors.New("block is not found")
+	ErrStaleBlock      = errors.New("another block took the tip while mining")
+)
 type BlockChain struct {
 	LastHash []byte
 	Database *badger.DB
@@ -31,111 +37,158 @@ func DBExists(path string) bool {
 	return true
 }
 
-func (bc *BlockChain) AddBlock(block *Block) {
+func getBlock(txn *badger.Txn, blockHash []byte) (*Block, error) {
+	item, err := txn.Get(blockHash)
+	if err != nil {
+		return nil, err
+	}
+	blockData, err := item.ValueCopy(nil)
+	if err != nil {
+		return nil, err
+	}
+	return DeserializeBlock(blockData)
+}
+
+func getLastHash(txn *badger.Txn) ([]byte, error) {
+	item, err := txn.Get([]byte("lh"))
+	if err != nil {
+		return nil, err
+	}
+	return item.ValueCopy(nil)
+}
+
+// AddBlock stores a block received from a peer and makes it the new tip if
+// its chain has more cumulative work than the current one. A block extending
+// the tip is validated and connected directly; one on a competing branch only
+// gets its header checked until that branch overtakes the tip, at which point
+// the chain is reorganized onto it. Transactions from blocks disconnected by
+// a reorganization are returned.
+func (bc *BlockChain) AddBlock(block *Block) ([]*Transaction, error) {
+	orphaned, _, err := bc.addBlock(block)
+	return orphaned, err
+}
+
+// addBlock is AddBlock, also reporting whether block became the tip.
+func (bc *BlockChain) addBlock(block *Block) ([]*Transaction, bool, error) {
+	var orphaned []*Transaction
+	var newTip []byte
+
 	err := bc.Database.Update(func(txn *badger.Txn) error {
 		if _, err := txn.Get(block.Hash); err == nil {
 			return nil
 		}
 
-		blockData := block.Serialize()
-		err := txn.Set(block.Hash, blockData)
-		utils.HandleError(err)
+		parent, err := getBlock(txn, block.PrevHash)
+		if err != nil {
+			return &BlockError{block.Hash, ErrUnknownParent}
+		}
+		if err := checkBlockHeader(txn, block, parent); err != nil {
+			return err
+		}
+		if err := checkBlockBody(block); err != nil {
+			return err
+		}
 
-		item, err := txn.Get([]byte("lh"))
-		utils.HandleError(err)
-		var lastHash []byte
-		err = item.Value(func(val []byte) error {
-			lastHash = append([]byte{}, val...)
-			return nil
-		})
-		utils.HandleError(err)
+		work, err := chainWork(txn, block.PrevHash)
+		if err != nil {
+			return err
+		}
+		work.Add(work, NewProofOfWork(block).Work())
+		if err := txn.Set(block.Hash, block.Serialize()); err != nil {
+			return err
+		}
+		if err := txn.Set(workKey(block.Hash), work.Bytes()); err != nil {
+			return err
+		}
 
-		item, err = txn.Get(lastHash)
-		utils.HandleError(err)
-		var lastBlockData []byte
-		err = item.Value(func(val []byte) error {
-			lastBlockData = append([]byte{}, val...)
+		lastHash, err := getLastHash(txn)
+		if err != nil {
+			return err
+		}
+		tipWork, err := chainWork(txn, lastHash)
+		if err != nil {
+			return err
+		}
+		if work.Cmp(tipWork) <= 0 {
 			return nil
-		})
-		utils.HandleError(err)
-
-		lastBlock := utils.Deserialize[*Block](lastBlockData)
+		}
 
-		if block.Height > lastBlock.Height {
-			err = txn.Set([]byte("lh"), block.Hash)
-			utils.HandleError(err)
-			bc.LastHash = block.Hash
+		if bytes.Equal(block.PrevHash, lastHash) {
+			if err := checkBlockTransactions(txn, block); err != nil {
+				return err
+			}
+			if err := connectBlock(txn, block); err != nil {
+				return err
+			}
+		} else {
+			tip, err := getBlock(txn, lastHash)
+			if err != nil {
+				return err
+			}
+			if orphaned, err = reorganize(txn, tip, block); err != nil {
+				return err
+			}
 		}
 
-		return nil
+		newTip = block.Hash
+		return txn.Set([]byte("lh"), block.Hash)
 	})
-	utils.HandleError(err)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if newTip != nil {
+		bc.LastHash = newTip
+	}
+	return orphaned, newTip != nil, nil
 }
 
 func (bc *BlockChain) GetBlock(blockHash []byte) (Block, error) {
 	var block Block
 
 	err := bc.Database.View(func(txn *badger.Txn) error {
-		if item, err := txn.Get(blockHash); err != nil {
-			return errors.New("Block is not found")
-		} else {
-			var blockData []byte
-			err = item.Value(func(val []byte) error {
-				blockData = append([]byte{}, val...)
-				return nil
-			})
-			utils.HandleError(err)
-			block = *utils.Deserialize[*Block](blockData)
+		decoded, err := getBlock(txn, blockHash)
+		if err == badger.ErrKeyNotFound {
+			return ErrBlockNotFound
+		}
+		if err != nil {
+			return err
 		}
+		block = *decoded
 		return nil
 	})
-	if err != nil {
-		return block, err
-	}
-
-	return block, nil
+	return block, err
 }
 
 
-func (bc *BlockChain) GetBestHeight() int {
-	var lastBlock Block
+func (bc *BlockChain) GetBestHeight() (int, error) {
+	var height int
 
 	err := bc.Database.View(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte("lh"))
-		utils.HandleError(err)
-		var lastHash []byte
-		err = item.Value(func(val []byte) error {
-			lastHash = append([]byte{}, val...)
-			return nil
-		})
+		lastHash, err := getLastHash(txn)
 		if err != nil {
 			return err
 		}
-
-		item, err = txn.Get(lastHash)
-		utils.HandleError(err)
-		var lastBlockData []byte
-		err = item.Value(func(val []byte) error {
-			lastBlockData = append([]byte{}, val...)
-			return nil
-		})
-		utils.HandleError(err)
-		lastBlock = *utils.Deserialize[*Block](lastBlockData)
-
+		lastBlock, err := getBlock(txn, lastHash)
+		if err != nil {
+			return err
+		}
+		height = lastBlock.Height
 		return nil
 	})
-	utils.HandleError(err)
-
-	return lastBlock.Height
+	return height, err
 }
 
-func (bc *BlockChain) GetBlockHashes() [][]byte {
+func (bc *BlockChain) GetBlockHashes() ([][]byte, error) {
 	var blocks [][]byte
 
 	iter := bc.Iterator()
 
 	for {
-		block := iter.Next()
+		block, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
 
 		blocks = append(blocks, block.Hash)
 
@@ -144,114 +197,146 @@ func (bc *BlockChain) GetBlockHashes() [][]byte {
 		}
 	}
 
-	return blocks
+	return blocks, nil
 }
 
-func (bc *BlockChain) MineBlock(transactions []*Transaction) *Block {
+// MineBlock mines transactions into a block on the tip and adds it. It fails
+// with ErrStaleBlock if another block reached the tip first, leaving the mined
+// one on a side branch.
+func (bc *BlockChain) MineBlock(ctx context.Context, transactions []*Transaction) (*Block, error) {
 	var lastHash []byte
 	var lastHeight int
+	var bits uint32
+	var mtp int64
 
-		for _, tx := range transactions {
-		if !bc.VerifyTransaction(tx) {
-			log.Panic("Invalid Transaction")
+	for _, tx := range transactions {
+		if err := bc.VerifyTransaction(tx); err != nil {
+			return nil, fmt.Errorf("transaction %x: %w", tx.ID, err)
 		}
 	}
 	err := bc.Database.View(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte("lh"))
-		utils.HandleError(err)
-if err = item.Value(func (val []byte) error {
-			lastHash = append([]byte{}, val...)
-			return nil
-		}); err != nil {
+		var err error
+		if lastHash, err = getLastHash(txn); err != nil {
 			return err
 		}
-		item, err = txn.Get(lastHash)
-		utils.HandleError(err)
-			err = item.Value(func(val []byte) error {
-			block := utils.Deserialize[*Block](val)
-			lastHeight = block.Height
+		lastBlock, err := getBlock(txn, lastHash)
+		if err != nil {
 			return err
-		})
-		utils.HandleError(err)
-		return nil
+		}
+		lastHeight = lastBlock.Height
+		if mtp, err = medianTimePast(txn, lastBlock); err != nil {
+			return err
+		}
+		bits, err = nextBits(txn, lastBlock)
+		return err
 	})
-	utils.HandleError(err)
+	if err != nil {
+		return nil, err
+	}
 
-	newBlock := CreateBlock(transactions, lastHash, lastHeight + 1)
+	newBlock, err := CreateBlock(ctx, transactions, lastHash, lastHeight + 1, bits, mtp+1)
+	if err != nil {
+		return nil, err
+	}
 
-	err = bc.Database.Update(func(txn *badger.Txn) error {
-		err := txn.Set(newBlock.Hash, newBlock.Serialize())
-		utils.HandleError(err)
-		err = txn.Set([]byte("lh"), newBlock.Hash)
-		bc.LastHash = newBlock.Hash
-		return err
-	})
-	utils.HandleError(err)
-	return newBlock
+	_, tip, err := bc.addBlock(newBlock)
+	if err != nil {
+		return nil, err
+	}
+	if !tip {
+		return nil, fmt.Errorf("%w: block %x", ErrStaleBlock, newBlock.Hash)
+	}
+	return newBlock, nil
 }
 
-func NewBlockChain(address, nodeId string) *BlockChain {
-	var lastHash []byte
+func NewBlockChain(address, nodeId string) (*BlockChain, error) {
 	path := fmt.Sprintf(dbPath, nodeId)
 	if DBExists(path) {
-		fmt.Println("Blockchain already exists, loading from disk")
-		runtime.Goexit()
+		return nil, ErrChainExists
+	}
+	cbtx, err := CoinbaseTx(address, genesisData, 0, 0)
+	if err != nil {
+		return nil, err
 	}
+	genesis, err := GenesisBlock(cbtx)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("Genesis Block Created")
+
 	opts := badger.DefaultOptions(path)
 	db, err := openDB(path, opts)
-	utils.HandleError(err)
+	if err != nil {
+		return nil, err
+	}
 
 	err = db.Update(func(txn *badger.Txn) error {
-		cbtx := CoinbaseTx(address, genesisData)
-		genesis := GenesisBlock(cbtx)
-		fmt.Println("Genesis Block Created")
-		err := txn.Set(genesis.Hash, genesis.Serialize())
-		utils.HandleError(err)
-		err = txn.Set([]byte("lh"), genesis.Hash)
-		lastHash = genesis.Hash
-		return err
+		if err := txn.Set(genesis.Hash, genesis.Serialize()); err != nil {
+			return err
+		}
+		if err := txn.Set(workKey(genesis.Hash), NewProofOfWork(genesis).Work().Bytes()); err != nil {
+			return err
+		}
+		if err := indexBlock(txn, genesis); err != nil {
+			return err
+		}
+		if err := setDBVersion(txn); err != nil {
+			return err
+		}
+		return txn.Set([]byte("lh"), genesis.Hash)
 	})
-	utils.HandleError(err)
-	bc := BlockChain{lastHash, db}
-	return &bc
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	bc := BlockChain{genesis.Hash, db}
+	return &bc, nil
 }
 
-func ContinueBlockChain(nodeId string) *BlockChain {
+func ContinueBlockChain(nodeId string) (*BlockChain, error) {
 path := fmt.Sprintf(dbPath, nodeId)
 	if !DBExists(path) {
-		fmt.Println("No existing blockchain found, create a new one")
-		runtime.Goexit()
+		return nil, ErrNoChain
 	}
 
 	var lastHash []byte
 	opts := badger.DefaultOptions(path)
 	db, err := openDB(path, opts)
-	utils.HandleError(err)
-	err = db.Update(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte("lh"))
-		utils.HandleError(err)
-		err = item.Value(func(val []byte) error {
-			lastHash = append([]byte{}, val...)
-			return nil
+	if err != nil {
+		return nil, err
+	}
+	err = migrateDB(db)
+	if err == nil {
+		err = db.View(func(txn *badger.Txn) error {
+			lastHash, err = getLastHash(txn)
+			return err
 		})
-		return err
-	})
-	utils.HandleError(err)
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
 	bc := BlockChain{lastHash, db}
-	return &bc
+	return &bc, nil
 }
 
-func (bc *BlockChain) FindUTXOutputs() map[string]TxOutputs {
+func (bc *BlockChain) FindUTXOutputs() (map[string]TxOutputs, error) {
 	UTXOs := make(map[string]TxOutputs)
 	spentTxos := make(map[string][]int)
 	iter := bc.Iterator()
 	for {
-		block := iter.Next()
+		block, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
 		for _, tx := range block.Transactions {
 			txID := hex.EncodeToString(tx.ID)
 
 		Outputs:
 			for outIdx, out := range tx.Outputs {
+				if IsUnspendable(out.ScriptPubKey) {
+					continue
+				}
 				if spentTxos[txID] != nil {
 					for _, spentOutIdx := range spentTxos[txID] {
 						if spentOutIdx == outIdx {
@@ -260,7 +345,10 @@ func (bc *BlockChain) FindUTXOutputs() map[string]TxOutputs {
 					}
 				}
 				outs := UTXOs[txID]
-				outs.Outputs = append(outs.Outputs, out)
+				if outs.Outputs == nil {
+					outs = TxOutputs{make(map[int]TxOutput), block.Height, tx.IsCoinbase()}
+				}
+				outs.Outputs[outIdx] = out
 				UTXOs[txID] = outs
 			}
 			if !tx.IsCoinbase() {
@@ -274,48 +362,56 @@ func (bc *BlockChain) FindUTXOutputs() map[string]TxOutputs {
 			break
 		}
 	}
-	return UTXOs
+	return UTXOs, nil
 }
 
 func (bc *BlockChain) FindTransaction(ID []byte) (Transaction, error) {
-	iter := bc.Iterator()
-	for {
-		block := iter.Next()
-		for _, tx := range block.Transactions {
-			if bytes.Equal(tx.ID, ID) {
-				return *tx, nil
-			}
+	var tx Transaction
+	err := bc.Database.View(func(txn *badger.Txn) error {
+		loc, err := getTxLocation(txn, ID)
+		if err != nil {
+			return err
 		}
-		if len(block.PrevHash) == 0 {
-			break
+		block, err := getBlock(txn, loc.BlockHash)
+		if err != nil {
+			return err
 		}
+		tx = *block.Transactions[loc.Position]
+		return nil
+	})
+	if err == badger.ErrKeyNotFound {
+		return Transaction{}, fmt.Errorf("%w: %x", ErrTxNotFound, ID)
 	}
-	return Transaction{}, errors.New("transaction not found")
-
+	return tx, err
 }
 
-func (bc *BlockChain) SignTransaction(tx *Transaction, privateKey ecdsa.PrivateKey) {
+func (bc *BlockChain) prevTransactions(tx *Transaction) (map[string]Transaction, error) {
 	prevTXs := make(map[string]Transaction)
 	for _, in := range tx.Inputs {
 		prevTX, err := bc.FindTransaction(in.ID)
-		utils.HandleError(err)
+		if err != nil {
+			return nil, err
+		}
 		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
 	}
-	tx.Sign(privateKey, prevTXs)
+	return prevTXs, nil
 }
 
-func (bc *BlockChain) VerifyTransaction(tx *Transaction) bool {
-	if tx.IsCoinbase() {
-		return true
-	}
-	prevTXs := make(map[string]Transaction)
-	for _, in := range tx.Inputs {
-		prevTX, err := bc.FindTransaction(in.ID)
-		utils.HandleError(err)
-		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
+func (bc *BlockChain) SignTransaction(tx *Transaction, privateKey ecdsa.PrivateKey) error {
+	prevTXs, err := bc.prevTransactions(tx)
+	if err != nil {
+		return err
 	}
+	return tx.Sign(privateKey, prevTXs)
+}
 
-	return tx.Verify(prevTXs)
+// VerifyTransaction checks that tx could go into the next block.
+func (bc *BlockChain) VerifyTransaction(tx *Transaction) error {
+	if tx.IsCoinbase() {
+		return nil
+	}
+	_, err := bc.CheckTransaction(tx)
+	return err
 }
 
 
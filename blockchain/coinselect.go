@@ -0,0 +1,128 @@
+package blockchain
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Coin is an unspent output a wallet can spend.
+type Coin struct {
+	TxID   []byte
+	Index  int
+	Value  int
+	Height int
+}
+
+// CoinSelector picks coins worth at least target out of coins, or returns nil
+// when they are not worth enough.
+type CoinSelector func(coins []Coin, target int) []Coin
+
+// DefaultStrategy is the coin selection strategy send uses unless told
+// otherwise.
+const DefaultStrategy = "largest"
+
+// maxBnBTries bounds the branch and bound search so large wallets still
+// select coins quickly.
+const maxBnBTries = 100000
+
+var ErrUnknownStrategy = errors.New("unknown coin selection strategy")
+
+var coinSelectors = map[string]CoinSelector{
+	"largest": LargestFirst,
+	"oldest":  OldestFirst,
+	"bnb":     BranchAndBound,
+}
+
+// CoinSelectorByName returns the coin selection strategy called name.
+func CoinSelectorByName(name string) (CoinSelector, error) {
+	selector, ok := coinSelectors[name]
+	if !ok {
+		return nil, fmt.Errorf("%w %q, use one of %s", ErrUnknownStrategy, name, StrategyNames())
+	}
+	return selector, nil
+}
+
+// StrategyNames lists the coin selection strategies for usage messages.
+func StrategyNames() string {
+	return strings.Join(slices.Sorted(maps.Keys(coinSelectors)), ", ")
+}
+
+// LargestFirst spends the biggest coins first, which keeps the number of
+// inputs and so the fee down.
+func LargestFirst(coins []Coin, target int) []Coin {
+	return accumulate(byValue(coins), target)
+}
+
+// OldestFirst spends the coins from the lowest blocks first.
+func OldestFirst(coins []Coin, target int) []Coin {
+	sorted := slices.Clone(coins)
+	slices.SortStableFunc(sorted, func(a, b Coin) int {
+		return cmp.Compare(a.Height, b.Height)
+	})
+	return accumulate(sorted, target)
+}
+
+// BranchAndBound searches for coins adding up to exactly target, so the
+// transaction needs no change output, and falls back to LargestFirst when
+// there are none.
+func BranchAndBound(coins []Coin, target int) []Coin {
+	sorted := byValue(coins)
+
+	// ? remaining[i] is what the coins from i on are worth, to prune branches that cannot reach target
+	remaining := make([]int, len(sorted)+1)
+	for i := len(sorted) - 1; i >= 0; i-- {
+		remaining[i] = remaining[i+1] + sorted[i].Value
+	}
+
+	tries := 0
+	var picked []Coin
+	var search func(i, sum int) bool
+	search = func(i, sum int) bool {
+		tries++
+		if sum == target {
+			return true
+		}
+		if i == len(sorted) || sum > target || sum+remaining[i] < target || tries > maxBnBTries {
+			return false
+		}
+		picked = append(picked, sorted[i])
+		if search(i+1, sum+sorted[i].Value) {
+			return true
+		}
+		picked = picked[:len(picked)-1]
+		return search(i+1, sum)
+	}
+
+	if target > 0 && search(0, 0) {
+		return picked
+	}
+	return LargestFirst(coins, target)
+}
+
+func byValue(coins []Coin) []Coin {
+	sorted := slices.Clone(coins)
+	slices.SortStableFunc(sorted, func(a, b Coin) int {
+		return cmp.Compare(b.Value, a.Value)
+	})
+	return sorted
+}
+
+func accumulate(coins []Coin, target int) []Coin {
+	var selected []Coin
+	total := 0
+	for _, coin := range coins {
+		if total >= target {
+			break
+		}
+		selected = append(selected, coin)
+		total += coin.Value
+	}
+	if total < target {
+		return nil
+	}
+	return selected
+}
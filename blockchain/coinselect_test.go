@@ -0,0 +1,82 @@
+package blockchain
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func testCoins(values ...int) []Coin {
+	var coins []Coin
+	for i, value := range values {
+		coins = append(coins, Coin{TxID: []byte{byte(i)}, Index: i, Value: value, Height: len(values) - i})
+	}
+	return coins
+}
+
+func coinValues(coins []Coin) []int {
+	var values []int
+	for _, coin := range coins {
+		values = append(values, coin.Value)
+	}
+	return values
+}
+
+func totalValue(coins []Coin) int {
+	total := 0
+	for _, coin := range coins {
+		total += coin.Value
+	}
+	return total
+}
+
+func TestBranchAndBound(t *testing.T) {
+	tests := []struct {
+		name   string
+		coins  []Coin
+		target int
+		want   int
+	}{
+		{"single coin", testCoins(3, 50, 7, 20), 7, 7},
+		{"exact pair", testCoins(50, 20, 10, 7, 5, 3), 15, 15},
+		{"skipping the largest coins", testCoins(8, 7, 6, 5), 11, 11},
+		{"all coins", testCoins(1, 2, 4), 7, 7},
+		// ? Without an exact match the largest coins are spent, leaving change
+		{"no exact match", testCoins(10, 10, 4), 15, 20},
+		{"not enough", testCoins(5, 5), 11, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selected := BranchAndBound(tt.coins, tt.target)
+			if got := totalValue(selected); got != tt.want {
+				t.Errorf("selected %v worth %d, want %d", coinValues(selected), got, tt.want)
+			}
+		})
+	}
+
+	coins := testCoins(10, 10, 4)
+	if got, want := coinValues(BranchAndBound(coins, 15)), coinValues(LargestFirst(coins, 15)); !slices.Equal(got, want) {
+		t.Errorf("fallback selected %v, want what LargestFirst picks, %v", got, want)
+	}
+}
+
+func TestAccumulatingSelectors(t *testing.T) {
+	// ? testCoins gives the first coin the highest block
+	coins := testCoins(1, 5, 3, 8)
+	if got := coinValues(LargestFirst(coins, 10)); !slices.Equal(got, []int{8, 5}) {
+		t.Errorf("LargestFirst selected %v, want [8 5]", got)
+	}
+	if got := coinValues(OldestFirst(coins, 10)); !slices.Equal(got, []int{8, 3}) {
+		t.Errorf("OldestFirst selected %v, want [8 3]", got)
+	}
+	if got := LargestFirst(coins, 18); got != nil {
+		t.Errorf("selected %v worth more than the coins, want nil", coinValues(got))
+	}
+
+	if _, err := CoinSelectorByName("smallest"); !errors.Is(err, ErrUnknownStrategy) {
+		t.Errorf("unknown strategy: err = %v, want %v", err, ErrUnknownStrategy)
+	}
+	if _, err := CoinSelectorByName(DefaultStrategy); err != nil {
+		t.Errorf("default strategy: %v", err)
+	}
+}
@@ -0,0 +1,114 @@
+package blockchain
+
+import (
+	"math/big"
+	"slices"
+	"time"
+
+	"github.com/dgraph-io/badger"
+)
+
+const (
+	// InitialDifficulty is the number of leading zero bits the genesis block
+	// target asks for; MinimumDifficulty bounds how easy retargeting can get.
+	InitialDifficulty = 12
+	MinimumDifficulty = 8
+
+	// RetargetInterval is how many blocks share a target, TargetSpacing the
+	// number of seconds a block should take on average.
+	RetargetInterval = 10
+	TargetSpacing    = 10
+
+	maxRetargetFactor = 4
+	medianTimeSpan    = 11
+	maxFutureDrift    = 2 * time.Hour
+)
+
+var (
+	powLimit    = new(big.Int).Lsh(big.NewInt(1), 256-MinimumDifficulty)
+	InitialBits = bigToCompact(new(big.Int).Lsh(big.NewInt(1), 256-InitialDifficulty))
+)
+
+// compactToBig expands the compact target representation stored in a block's
+// Bits: the high byte is a base-256 exponent and the low 23 bits the mantissa.
+func compactToBig(compact uint32) *big.Int {
+	mantissa := int64(compact & 0x007fffff)
+	exponent := uint(compact >> 24)
+
+	if compact&0x00800000 != 0 {
+		return new(big.Int)
+	}
+	if exponent <= 3 {
+		return big.NewInt(mantissa >> (8 * (3 - exponent)))
+	}
+	return new(big.Int).Lsh(big.NewInt(mantissa), 8*(exponent-3))
+}
+
+func bigToCompact(n *big.Int) uint32 {
+	if n.Sign() <= 0 {
+		return 0
+	}
+
+	exponent := uint(len(n.Bytes()))
+	var mantissa uint32
+	if exponent <= 3 {
+		mantissa = uint32(n.Uint64()) << (8 * (3 - exponent))
+	} else {
+		mantissa = uint32(new(big.Int).Rsh(n, 8*(exponent-3)).Uint64())
+	}
+
+	// ? The top mantissa bit is a sign bit, so move it into the exponent
+	if mantissa&0x00800000 != 0 {
+		mantissa >>= 8
+		exponent++
+	}
+	return uint32(exponent<<24) | mantissa
+}
+
+// nextBits returns the target a block built on parent has to meet. It stays
+// the same within a retarget interval and is then scaled by how long the
+// previous interval actually took compared to TargetSpacing per block.
+func nextBits(txn *badger.Txn, parent *Block) (uint32, error) {
+	height := parent.Height + 1
+	if height%RetargetInterval != 0 {
+		return parent.Bits, nil
+	}
+
+	first := parent
+	for first.Height > height-RetargetInterval {
+		var err error
+		if first, err = getBlock(txn, first.PrevHash); err != nil {
+			return 0, err
+		}
+	}
+
+	expected := int64(parent.Height-first.Height) * TargetSpacing
+	actual := max(parent.Timestamp-first.Timestamp, expected/maxRetargetFactor)
+	actual = min(actual, expected*maxRetargetFactor)
+
+	target := compactToBig(parent.Bits)
+	target.Mul(target, big.NewInt(actual))
+	target.Div(target, big.NewInt(expected))
+	if target.Cmp(powLimit) > 0 {
+		target.Set(powLimit)
+	}
+	return bigToCompact(target), nil
+}
+
+// medianTimePast is the median timestamp of the last blocks up to and
+// including block; a new block has to be newer than it.
+func medianTimePast(txn *badger.Txn, block *Block) (int64, error) {
+	var timestamps []int64
+	for len(timestamps) < medianTimeSpan {
+		timestamps = append(timestamps, block.Timestamp)
+		if len(block.PrevHash) == 0 {
+			break
+		}
+		var err error
+		if block, err = getBlock(txn, block.PrevHash); err != nil {
+			return 0, err
+		}
+	}
+	slices.Sort(timestamps)
+	return timestamps[len(timestamps)/2], nil
+}
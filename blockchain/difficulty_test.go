@@ -0,0 +1,172 @@
+package blockchain
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger"
+)
+
+func TestCompactEncoding(t *testing.T) {
+	tests := []struct {
+		compact uint32
+		target  int64
+	}{
+		{0x01120000, 0x12},
+		{0x02123400, 0x1234},
+		{0x03123456, 0x123456},
+		{0x04123456, 0x12345600},
+		{0x05009234, 0x92340000},
+		// ? A target with the top mantissa bit set moves a byte into the exponent to stay positive
+		{0x02008000, 0x80},
+	}
+	for _, tt := range tests {
+		if got := compactToBig(tt.compact); got.Int64() != tt.target {
+			t.Errorf("compactToBig(%08x) = %x, want %x", tt.compact, got, tt.target)
+		}
+		if got := bigToCompact(big.NewInt(tt.target)); got != tt.compact {
+			t.Errorf("bigToCompact(%x) = %08x, want %08x", tt.target, got, tt.compact)
+		}
+	}
+
+	for _, target := range []*big.Int{powLimit, compactToBig(InitialBits), new(big.Int).Lsh(big.NewInt(0xffff), 208)} {
+		if got := compactToBig(bigToCompact(target)); got.Cmp(target) != 0 {
+			t.Errorf("target %x comes back as %x", target, got)
+		}
+	}
+	if got := compactToBig(0x04923456); got.Sign() != 0 {
+		t.Errorf("negative compact target = %x, want 0", got)
+	}
+}
+
+// storeHeaders stores a chain of bodiless blocks with the given timestamps
+// and bits and returns them.
+func storeHeaders(t *testing.T, txn *badger.Txn, timestamps []int64, bits uint32) []*Block {
+	t.Helper()
+	var blocks []*Block
+	prevHash := []byte{}
+	for height, timestamp := range timestamps {
+		block := &Block{Timestamp: timestamp, PrevHash: prevHash, Height: height, Bits: bits, Version: BlockVersion}
+		block.Hash = block.Header().Hash()
+		if err := txn.Set(block.Hash, block.Serialize()); err != nil {
+			t.Fatal(err)
+		}
+		blocks = append(blocks, block)
+		prevHash = block.Hash
+	}
+	return blocks
+}
+
+func openTestDB(t *testing.T) *badger.DB {
+	t.Helper()
+	db, err := badger.Open(badger.DefaultOptions(t.TempDir()).WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func spacedTimestamps(n int, spacing int64) []int64 {
+	timestamps := make([]int64, n)
+	for i := range timestamps {
+		timestamps[i] = 1700000000 + int64(i)*spacing
+	}
+	return timestamps
+}
+
+func TestNextBits(t *testing.T) {
+	initial := compactToBig(InitialBits)
+	expected := int64((RetargetInterval - 1) * TargetSpacing)
+	scaled := func(target *big.Int, num, den int64) uint32 {
+		n := new(big.Int).Mul(target, big.NewInt(num))
+		return bigToCompact(n.Div(n, big.NewInt(den)))
+	}
+
+	tests := []struct {
+		name    string
+		spacing int64
+		bits    uint32
+		want    uint32
+	}{
+		{"on target", TargetSpacing, InitialBits, InitialBits},
+		{"twice as slow", 2 * TargetSpacing, InitialBits, scaled(initial, 2, 1)},
+		{"clamped when too fast", 0, InitialBits, scaled(initial, expected/maxRetargetFactor, expected)},
+		{"clamped when too slow", 100 * TargetSpacing, InitialBits, scaled(initial, maxRetargetFactor, 1)},
+		{"capped at the minimum difficulty", 100 * TargetSpacing, bigToCompact(powLimit), bigToCompact(powLimit)},
+	}
+	db := openTestDB(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := db.Update(func(txn *badger.Txn) error {
+				blocks := storeHeaders(t, txn, spacedTimestamps(RetargetInterval, tt.spacing), tt.bits)
+
+				// ? Within an interval the target carries over whatever the timestamps say
+				if got, err := nextBits(txn, blocks[RetargetInterval-2]); err != nil || got != tt.bits {
+					t.Errorf("bits inside the interval = %08x, %v, want %08x", got, err, tt.bits)
+				}
+				got, err := nextBits(txn, blocks[RetargetInterval-1])
+				if err != nil {
+					return err
+				}
+				if got != tt.want {
+					t.Errorf("bits = %08x, want %08x", got, tt.want)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestMedianTimePast(t *testing.T) {
+	db := openTestDB(t)
+	now := time.Now().Unix()
+	err := db.Update(func(txn *badger.Txn) error {
+		timestamps := []int64{now - 30, now - 90, now - 95, now - 80, now - 85, now - 70, now - 75, now - 60, now - 65, now - 50, now - 55, now - 40}
+		blocks := storeHeaders(t, txn, timestamps, InitialBits)
+
+		mtp, err := medianTimePast(txn, blocks[2])
+		if err != nil {
+			return err
+		}
+		if mtp != now-90 {
+			t.Errorf("median of the first 3 blocks = %d, want %d", mtp, now-90)
+		}
+		parent := blocks[len(blocks)-1]
+		if mtp, err = medianTimePast(txn, parent); err != nil {
+			return err
+		}
+		// ? Only the last 11 blocks count, which leaves out the late genesis timestamp
+		if mtp != now-70 {
+			t.Errorf("median of the last 11 blocks = %d, want %d", mtp, now-70)
+		}
+
+		for _, c := range []struct {
+			timestamp int64
+			want      error
+		}{
+			{mtp - 1, ErrBadTimestamp},
+			{mtp, ErrBadTimestamp},
+			{mtp + 1, nil},
+			{now + int64(maxFutureDrift/time.Second) + 60, ErrBadTimestamp},
+		} {
+			block := &Block{Timestamp: c.timestamp, PrevHash: parent.Hash, Height: parent.Height + 1, Bits: InitialBits, Version: BlockVersion}
+			if _, err := NewProofOfWork(block).Mine(context.Background(), 1); err != nil {
+				return err
+			}
+			if err := checkBlockHeader(txn, block, parent); !errors.Is(err, c.want) {
+				t.Errorf("timestamp %+d from the median: err = %v, want %v", c.timestamp-mtp, err, c.want)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,203 @@
+package blockchain
+
+// Blocks and transactions are stored, sent and hashed in the following
+// encoding. Integers are big-endian, and every byte string and list is
+// prefixed with its length or item count as a uint32.
+//
+//	Block       = bytes(Hash) Header uint32(count) bytes(Transaction)...
+//	Header      = the HeaderSize bytes of BlockHeader.Serialize
+//	Transaction = bytes(ID) uint32(count) TxInput... uint32(count) TxOutput... uint32(LockTime)
+//	TxInput     = bytes(ID) int32(OutIndex) bytes(ScriptSig) uint32(Sequence)
+//	TxOutput    = int64(Value) bytes(ScriptPubKey)
+//
+// A transaction ID is the SHA-256 of the transaction encoded with an empty ID.
+// Signatures sign the SHA-256 of the transaction as SigHash prepares it,
+// followed by the TxOutput each of its inputs spends.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+var ErrMalformedData = errors.New("malformed block or transaction data")
+
+func appendBytes(data, b []byte) []byte {
+	data = binary.BigEndian.AppendUint32(data, uint32(len(b)))
+	return append(data, b...)
+}
+
+func (in TxInput) appendTo(data []byte) []byte {
+	data = appendBytes(data, in.ID)
+	data = binary.BigEndian.AppendUint32(data, uint32(int32(in.OutIndex)))
+	data = appendBytes(data, in.ScriptSig)
+	return binary.BigEndian.AppendUint32(data, in.Sequence)
+}
+
+func (out TxOutput) appendTo(data []byte) []byte {
+	data = binary.BigEndian.AppendUint64(data, uint64(int64(out.Value)))
+	return appendBytes(data, out.ScriptPubKey)
+}
+
+func (tx Transaction) appendTo(data []byte) []byte {
+	data = appendBytes(data, tx.ID)
+	data = binary.BigEndian.AppendUint32(data, uint32(len(tx.Inputs)))
+	for _, in := range tx.Inputs {
+		data = in.appendTo(data)
+	}
+	data = binary.BigEndian.AppendUint32(data, uint32(len(tx.Outputs)))
+	for _, out := range tx.Outputs {
+		data = out.appendTo(data)
+	}
+	return binary.BigEndian.AppendUint32(data, tx.LockTime)
+}
+
+func (b *Block) appendTo(data []byte) []byte {
+	data = appendBytes(data, b.Hash)
+	data = append(data, b.Header().Serialize()...)
+	data = binary.BigEndian.AppendUint32(data, uint32(len(b.Transactions)))
+	for _, tx := range b.Transactions {
+		data = appendBytes(data, tx.Serialize())
+	}
+	return data
+}
+
+// decoder reads the encoding above, remembering the first error so callers
+// only need to check once at the end.
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil || n < 0 || n > len(d.data) {
+		d.err = ErrMalformedData
+		return nil
+	}
+	b := d.data[:n:n]
+	d.data = d.data[n:]
+	return b
+}
+
+func (d *decoder) uint32() uint32 {
+	b := d.next(4)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b)
+}
+
+func (d *decoder) uint64() uint64 {
+	b := d.next(8)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
+
+func (d *decoder) bytes() []byte {
+	return append([]byte{}, d.next(int(d.uint32()))...)
+}
+
+// count reads a list length, rejecting ones that could not fit in what is
+// left of the data given each item takes at least minSize bytes.
+func (d *decoder) count(minSize int) int {
+	n := int(d.uint32())
+	if d.err == nil && n > len(d.data)/minSize {
+		d.err = ErrMalformedData
+		return 0
+	}
+	return n
+}
+
+func (d *decoder) finish() error {
+	if d.err == nil && len(d.data) > 0 {
+		d.err = ErrMalformedData
+	}
+	return d.err
+}
+
+func (d *decoder) transaction() *Transaction {
+	tx := &Transaction{ID: d.bytes()}
+
+	tx.Inputs = make([]TxInput, d.count(16))
+	for i := range tx.Inputs {
+		tx.Inputs[i] = d.input()
+	}
+
+	tx.Outputs = make([]TxOutput, d.count(12))
+	for i := range tx.Outputs {
+		tx.Outputs[i] = d.output()
+	}
+	tx.LockTime = d.uint32()
+	return tx
+}
+
+func (d *decoder) input() TxInput {
+	var in TxInput
+	in.ID = d.bytes()
+	in.OutIndex = int(int32(d.uint32()))
+	in.ScriptSig = d.bytes()
+	in.Sequence = d.uint32()
+	return in
+}
+
+func (d *decoder) output() TxOutput {
+	var out TxOutput
+	out.Value = int(int64(d.uint64()))
+	out.ScriptPubKey = d.bytes()
+	return out
+}
+
+func (d *decoder) header() BlockHeader {
+	var h BlockHeader
+	h.Version = int32(d.uint32())
+	h.PrevHash = append([]byte{}, d.next(32)...)
+	h.MerkleRoot = append([]byte{}, d.next(32)...)
+	h.Timestamp = int64(d.uint64())
+	h.Height = int(int64(d.uint64()))
+	h.Bits = d.uint32()
+	h.Nonce = d.uint32()
+
+	// ? Only the genesis block has no parent, and it is written as zeros
+	if bytes.Equal(h.PrevHash, make([]byte, 32)) {
+		h.PrevHash = []byte{}
+	}
+	return h
+}
+
+func DeserializeHeader(data []byte) (BlockHeader, error) {
+	d := decoder{data: data}
+	h := d.header()
+	return h, d.finish()
+}
+
+func DeserializeTransaction(data []byte) (*Transaction, error) {
+	d := decoder{data: data}
+	tx := d.transaction()
+	if err := d.finish(); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+func DeserializeBlock(data []byte) (*Block, error) {
+	d := decoder{data: data}
+	block := &Block{Hash: d.bytes()}
+
+	block.setHeader(d.header())
+
+	block.Transactions = make([]*Transaction, d.count(4))
+	for i := range block.Transactions {
+		tx, err := DeserializeTransaction(d.bytes())
+		if err != nil {
+			return nil, err
+		}
+		block.Transactions[i] = tx
+	}
+
+	if err := d.finish(); err != nil {
+		return nil, err
+	}
+	return block, nil
+}
@@ -0,0 +1,158 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func testInput() TxInput {
+	return TxInput{bytes.Repeat([]byte{0x11}, 32), 1, []byte{0xaa, 0xbb}, 5}
+}
+
+func testOutput() TxOutput {
+	return TxOutput{42, []byte{OpReturn, 0x01, 0xcc}}
+}
+
+func testTransaction() *Transaction {
+	tx := &Transaction{nil, []TxInput{testInput()}, []TxOutput{testOutput()}, 7}
+	tx.ID = tx.Hash()
+	return tx
+}
+
+func testBlock() *Block {
+	block := &Block{
+		Timestamp:    1700000000,
+		Transactions: []*Transaction{testTransaction()},
+		PrevHash:     bytes.Repeat([]byte{0x22}, 32),
+		Nonce:        9,
+		Height:       3,
+		Bits:         0x1f00ffff,
+		Version:      BlockVersion,
+	}
+	block.MerkleRoot = block.HashTransactions()
+	block.Hash = block.Header().Hash()
+	return block
+}
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestTxInputEncoding(t *testing.T) {
+	in := testInput()
+	want := "00000020" + "1111111111111111111111111111111111111111111111111111111111111111" +
+		"00000001" + "00000002aabb" + "00000005"
+	data := in.appendTo(nil)
+	if got := hex.EncodeToString(data); got != want {
+		t.Fatalf("encoding = %s, want %s", got, want)
+	}
+
+	d := decoder{data: data}
+	got := d.input()
+	if err := d.finish(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, in) {
+		t.Fatalf("round trip = %+v, want %+v", got, in)
+	}
+}
+
+func TestTxOutputEncoding(t *testing.T) {
+	out := testOutput()
+	want := "000000000000002a" + "00000003" + "6a01cc"
+	data := out.appendTo(nil)
+	if got := hex.EncodeToString(data); got != want {
+		t.Fatalf("encoding = %s, want %s", got, want)
+	}
+
+	d := decoder{data: data}
+	got := d.output()
+	if err := d.finish(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, out) {
+		t.Fatalf("round trip = %+v, want %+v", got, out)
+	}
+}
+
+const (
+	goldenTxID = "5b9f2566a9e00f32bd74cefc24f5e2c95feb3962a7d9066ea98d3725ff197df0"
+	goldenTx   = "000000205b9f2566a9e00f32bd74cefc24f5e2c95feb3962a7d9066ea98d3725ff197df0000000010000002011111111111111111111111111111111111111111111111111111111111111110000000100000002aabb0000000500000001000000000000002a000000036a01cc00000007"
+)
+
+func TestTransactionEncoding(t *testing.T) {
+	tx := testTransaction()
+	if got := hex.EncodeToString(tx.ID); got != goldenTxID {
+		t.Fatalf("ID = %s, want %s", got, goldenTxID)
+	}
+	data := tx.Serialize()
+	if got := hex.EncodeToString(data); got != goldenTx {
+		t.Fatalf("encoding = %s, want %s", got, goldenTx)
+	}
+
+	got, err := DeserializeTransaction(mustHex(t, goldenTx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, tx) {
+		t.Fatalf("round trip = %+v, want %+v", got, tx)
+	}
+}
+
+const (
+	goldenBlockHash = "e92e71511604dd497fa2a0a54d426f1b0b57c814d7bc8c84ddd8e24eeb32c47f"
+	goldenBlock     = "00000020e92e71511604dd497fa2a0a54d426f1b0b57c814d7bc8c84ddd8e24eeb32c47f00000001222222222222222222222222222222222222222222222222222222222222222262439c7dab9c3f46d61d15d1cea31a72897ae4914fb3bcccdfcdb2dba14f071d000000006553f10000000000000000031f00ffff000000090000000100000071000000205b9f2566a9e00f32bd74cefc24f5e2c95feb3962a7d9066ea98d3725ff197df0000000010000002011111111111111111111111111111111111111111111111111111111111111110000000100000002aabb0000000500000001000000000000002a000000036a01cc00000007"
+)
+
+func TestBlockEncoding(t *testing.T) {
+	block := testBlock()
+	if got := hex.EncodeToString(block.Hash); got != goldenBlockHash {
+		t.Fatalf("hash = %s, want %s", got, goldenBlockHash)
+	}
+	data := block.Serialize()
+	if got := hex.EncodeToString(data); got != goldenBlock {
+		t.Fatalf("encoding = %s, want %s", got, goldenBlock)
+	}
+
+	got, err := DeserializeBlock(mustHex(t, goldenBlock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, block) {
+		t.Fatalf("round trip = %+v, want %+v", got, block)
+	}
+}
+
+func TestMalformedEncoding(t *testing.T) {
+	tx := testTransaction().Serialize()
+	block := testBlock().Serialize()
+
+	// ? Claims 0xffffffff inputs, far more than the bytes left could hold
+	oversized := append([]byte{}, tx...)
+	copy(oversized[4+32:], []byte{0xff, 0xff, 0xff, 0xff})
+
+	tests := []struct {
+		name   string
+		decode func() error
+	}{
+		{"truncated transaction", func() error { _, err := DeserializeTransaction(tx[:len(tx)-1]); return err }},
+		{"trailing transaction bytes", func() error { _, err := DeserializeTransaction(append(tx, 0)); return err }},
+		{"oversized input count", func() error { _, err := DeserializeTransaction(oversized); return err }},
+		{"truncated block", func() error { _, err := DeserializeBlock(block[:len(block)-1]); return err }},
+		{"truncated header", func() error { _, err := DeserializeHeader(block[36 : 36+HeaderSize-1]); return err }},
+		{"empty", func() error { _, err := DeserializeTransaction(nil); return err }},
+	}
+	for _, tt := range tests {
+		if err := tt.decode(); !errors.Is(err, ErrMalformedData) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, ErrMalformedData)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/dgraph-io/badger"
+)
+
+const headersPath = "./tmp/headers_%s"
+
+var (
+	ErrUnknownBlock    = errors.New("block is not in the header chain")
+	ErrNotInMainChain  = errors.New("block is not on the best header chain")
+	ErrBadMerkleProof  = errors.New("Merkle proof does not lead to the block's Merkle root")
+	ErrGenesisMismatch = errors.New("header chain already has a different genesis block")
+)
+
+// HeaderChain is what a light node keeps instead of a BlockChain: the block
+// headers of every branch it has seen, stored as blocks without transactions
+// under the same keys a full node uses, so the header checks, chain work and
+// retargeting rules are shared with BlockChain.
+type HeaderChain struct {
+	LastHash []byte
+	Database *badger.DB
+}
+
+// TxProof shows that a transaction was included in the block with BlockHash.
+type TxProof struct {
+	BlockHash   []byte
+	Transaction *Transaction
+	Proof       MerkleProof
+}
+
+// OpenHeaderChain opens the light node's header database, creating an empty
+// one if needed. An empty chain adopts the first genesis header it is given.
+func OpenHeaderChain(nodeId string) (*HeaderChain, error) {
+	path := fmt.Sprintf(headersPath, nodeId)
+	db, err := openDB(path, badger.DefaultOptions(path))
+	if err != nil {
+		return nil, err
+	}
+
+	var lastHash []byte
+	err = db.View(func(txn *badger.Txn) error {
+		lastHash, err = getLastHash(txn)
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &HeaderChain{lastHash, db}, nil
+}
+
+func (hc *HeaderChain) BestHeight() (int, error) {
+	if hc.LastHash == nil {
+		return -1, nil
+	}
+	var height int
+	err := hc.Database.View(func(txn *badger.Txn) error {
+		tip, err := getBlock(txn, hc.LastHash)
+		if err != nil {
+			return err
+		}
+		height = tip.Height
+		return nil
+	})
+	return height, err
+}
+
+// AddHeader checks a header against its parent the way a full node checks a
+// block header and moves the tip if its branch has the most work.
+func (hc *HeaderChain) AddHeader(header BlockHeader) error {
+	block := &Block{Hash: header.Hash()}
+	block.setHeader(header)
+
+	var newTip []byte
+	err := hc.Database.Update(func(txn *badger.Txn) error {
+		if _, err := txn.Get(block.Hash); err == nil {
+			return nil
+		}
+
+		work := NewProofOfWork(block).Work()
+		if len(block.PrevHash) == 0 {
+			if hc.LastHash != nil {
+				return &BlockError{block.Hash, ErrGenesisMismatch}
+			}
+			if block.Height != 0 || block.Bits != InitialBits || block.Version != BlockVersion {
+				return &BlockError{block.Hash, ErrBadDifficulty}
+			}
+			if !NewProofOfWork(block).Validate() {
+				return &BlockError{block.Hash, ErrBadProofOfWork}
+			}
+		} else {
+			parent, err := getBlock(txn, block.PrevHash)
+			if err != nil {
+				return &BlockError{block.Hash, ErrUnknownParent}
+			}
+			if err := checkBlockHeader(txn, block, parent); err != nil {
+				return err
+			}
+			parentWork, err := chainWork(txn, block.PrevHash)
+			if err != nil {
+				return err
+			}
+			work.Add(work, parentWork)
+		}
+
+		if err := txn.Set(block.Hash, block.Serialize()); err != nil {
+			return err
+		}
+		if err := txn.Set(workKey(block.Hash), work.Bytes()); err != nil {
+			return err
+		}
+
+		if hc.LastHash != nil {
+			tipWork, err := chainWork(txn, hc.LastHash)
+			if err != nil {
+				return err
+			}
+			if work.Cmp(tipWork) <= 0 {
+				return nil
+			}
+		}
+		newTip = block.Hash
+		return txn.Set([]byte("lh"), block.Hash)
+	})
+	if err != nil {
+		return err
+	}
+
+	if newTip != nil {
+		hc.LastHash = newTip
+	}
+	return nil
+}
+
+// VerifyTxProof checks a proof against the stored headers and returns how
+// many confirmations the transaction has on the best header chain.
+func (hc *HeaderChain) VerifyTxProof(p TxProof) (int, error) {
+	var confirmations int
+	err := hc.Database.View(func(txn *badger.Txn) error {
+		header, err := getBlock(txn, p.BlockHash)
+		if err != nil {
+			return ErrUnknownBlock
+		}
+		if !VerifyMerkleProof(header.MerkleRoot, p.Transaction.Hash(), p.Proof) {
+			return ErrBadMerkleProof
+		}
+
+		tip, err := getBlock(txn, hc.LastHash)
+		if err != nil {
+			return err
+		}
+		block := tip
+		for block.Height > header.Height {
+			if block, err = getBlock(txn, block.PrevHash); err != nil {
+				return err
+			}
+		}
+		if !bytes.Equal(block.Hash, header.Hash) {
+			return ErrNotInMainChain
+		}
+		confirmations = tip.Height - header.Height + 1
+		return nil
+	})
+	return confirmations, err
+}
+
+// GetHeaders returns up to max headers of the main chain that follow the
+// block with hash from, oldest first. If from is not on the main chain the
+// headers start at the genesis block.
+func (bc *BlockChain) GetHeaders(from []byte, max int) ([]BlockHeader, error) {
+	var headers []BlockHeader
+
+	iter := bc.Iterator()
+	for {
+		block, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
+		if bytes.Equal(block.Hash, from) {
+			break
+		}
+		headers = append(headers, block.Header())
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+
+	slices.Reverse(headers)
+	if len(headers) > max {
+		headers = headers[:max]
+	}
+	return headers, nil
+}
+
+// FindPaymentProofs returns a proof for every main chain transaction with an
+// output locked to one of pubKeyHashes.
+func (bc *BlockChain) FindPaymentProofs(pubKeyHashes [][]byte) ([]TxProof, error) {
+	var proofs []TxProof
+
+	iter := bc.Iterator()
+	for {
+		block, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
+		var tree *MerkleTree
+		for _, tx := range block.Transactions {
+			if !paysAny(tx, pubKeyHashes) {
+				continue
+			}
+			if tree == nil {
+				tree = block.MerkleTree()
+			}
+			proof, err := tree.Proof(tx.Hash())
+			if err != nil {
+				return nil, err
+			}
+			proofs = append(proofs, TxProof{block.Hash, tx, proof})
+		}
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+	return proofs, nil
+}
+
+func paysAny(tx *Transaction, pubKeyHashes [][]byte) bool {
+	for _, out := range tx.Outputs {
+		for _, pubKeyHash := range pubKeyHashes {
+			if out.IsLockedWithKey(pubKeyHash) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,188 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"github.com/nthskyradiated/blockchain-in-golang/wallet"
+)
+
+// SecretSize is the length of the secrets whose hashes lock HTLC outputs.
+const SecretSize = 32
+
+var (
+	ErrNotHTLC     = errors.New("script is not a hash-time-locked contract")
+	ErrWrongSecret = errors.New("secret does not match the contract's secret hash")
+	ErrNotInTx     = errors.New("transaction does not pay to the contract")
+)
+
+// HTLC is a hash-time-locked contract. The recipient can spend it by
+// revealing the secret hashing to SecretHash, and the refund key can take it
+// back once LockTime has passed. Contracts are paid to by script hash, with
+// the contract itself as the redeem script.
+type HTLC struct {
+	SecretHash []byte
+	Recipient  []byte
+	Refund     []byte
+	LockTime   uint32
+}
+
+// Script returns the contract's redeem script:
+//
+//	OP_IF
+//	    OP_SIZE 32 OP_EQUALVERIFY OP_SHA256 <secretHash> OP_EQUALVERIFY
+//	    OP_DUP OP_HASH160 <recipient>
+//	OP_ELSE
+//	    <lockTime> OP_CHECKLOCKTIMEVERIFY OP_DROP
+//	    OP_DUP OP_HASH160 <refund>
+//	OP_ENDIF
+//	OP_EQUALVERIFY OP_CHECKSIG
+func (h HTLC) Script() []byte {
+	return ScriptBuilder{}.
+		Op(OpIf, OpSize).Int(SecretSize).Op(OpEqualVerify, OpSHA256).Data(h.SecretHash).Op(OpEqualVerify).
+		Op(OpDup, OpHash160).Data(h.Recipient).
+		Op(OpElse).Int(int64(h.LockTime)).Op(OpCheckLockTimeVerify, OpDrop).
+		Op(OpDup, OpHash160).Data(h.Refund).
+		Op(OpEndIf, OpEqualVerify, OpCheckSig)
+}
+
+// Address returns the address paying to the contract.
+func (h HTLC) Address() string {
+	return wallet.ScriptHashAddress(wallet.PublicKeyHash(h.Script()))
+}
+
+// ParseHTLC reads a contract back from its redeem script.
+func ParseHTLC(script []byte) (HTLC, error) {
+	instrs, err := parseScript(script)
+	if err != nil || len(instrs) != 20 {
+		return HTLC{}, ErrNotHTLC
+	}
+	// ? Small lock times are pushed with OP_1 to OP_16, and the comparison below catches anything else
+	lockTime, err := decodeNum(instrs[11].data)
+	if instrs[11].op >= Op1 && instrs[11].op <= Op16 {
+		lockTime, err = int64(instrs[11].op-Op1)+1, nil
+	}
+	if err != nil || lockTime <= 0 || lockTime > int64(^uint32(0)) {
+		return HTLC{}, ErrNotHTLC
+	}
+	h := HTLC{
+		SecretHash: instrs[5].data,
+		Recipient:  instrs[9].data,
+		Refund:     instrs[16].data,
+		LockTime:   uint32(lockTime),
+	}
+	if len(h.SecretHash) != sha256.Size || len(h.Recipient) != 20 || len(h.Refund) != 20 || !bytes.Equal(h.Script(), script) {
+		return HTLC{}, ErrNotHTLC
+	}
+	return h, nil
+}
+
+// HTLCRedeemScript spends a contract with the recipient's signature and the
+// secret.
+func HTLCRedeemScript(sig, pubKey, secret, contract []byte) []byte {
+	return ScriptBuilder{}.Data(sig).Data(pubKey).Data(secret).Int(1).Data(contract)
+}
+
+// HTLCRefundScript spends a contract with the refund key's signature once its
+// lock time has passed.
+func HTLCRefundScript(sig, pubKey, contract []byte) []byte {
+	return ScriptBuilder{}.Data(sig).Data(pubKey).Int(0).Data(contract)
+}
+
+// ExtractSecret returns the secret an input redeeming an HTLC reveals, or nil.
+func ExtractSecret(in TxInput) []byte {
+	pushes := ScriptPushes(in.ScriptSig)
+	if len(pushes) != 5 || !bytes.Equal(pushes[3], []byte{1}) {
+		return nil
+	}
+	if _, err := ParseHTLC(pushes[4]); err != nil {
+		return nil
+	}
+	return pushes[2]
+}
+
+// ContractOutput returns the index of the output of tx paying to contract.
+func ContractOutput(tx *Transaction, contract []byte) (int, error) {
+	lock := P2SHScript(wallet.PublicKeyHash(contract))
+	for i, out := range tx.Outputs {
+		if bytes.Equal(out.ScriptPubKey, lock) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %x", ErrNotInTx, tx.ID)
+}
+
+// NewHTLCSpend spends the output of contractTx paying to contract to w,
+// leaving fee for the miner. With a secret it redeems the contract as the
+// recipient, and without one it refunds it, which only becomes valid once the
+// contract's lock time has passed.
+func NewHTLCSpend(contractTx *Transaction, contract []byte, w *wallet.Wallet, secret []byte, fee int) (*Transaction, error) {
+	h, err := ParseHTLC(contract)
+	if err != nil {
+		return nil, err
+	}
+	index, err := ContractOutput(contractTx, contract)
+	if err != nil {
+		return nil, err
+	}
+	value := contractTx.Outputs[index].Value - fee
+	if fee < 0 || value <= 0 {
+		return nil, fmt.Errorf("%w: fee %d of %d", ErrBadOutputValue, fee, contractTx.Outputs[index].Value)
+	}
+
+	key := h.Refund
+	if secret != nil {
+		key = h.Recipient
+		if hash := sha256.Sum256(secret); !bytes.Equal(hash[:], h.SecretHash) {
+			return nil, ErrWrongSecret
+		}
+	}
+	if !bytes.Equal(wallet.PublicKeyHash(w.PublicKey), key) {
+		return nil, fmt.Errorf("%w: the contract pays to %s", wallet.ErrWalletNotFound, wallet.PubKeyHashAddress(key))
+	}
+
+	out, err := NewTXOutput(value, string(w.Address()))
+	if err != nil {
+		return nil, err
+	}
+	tx := Transaction{nil, []TxInput{{contractTx.ID, index, nil, 0}}, []TxOutput{*out}, 0}
+	if secret == nil {
+		tx.LockTime = h.LockTime
+	}
+	sig, err := SignHash(w.PrivateKey, tx.SigHash(0, contract, []TxOutput{contractTx.Outputs[index]}))
+	if err != nil {
+		return nil, err
+	}
+	pubKey := elliptic.Marshal(w.PrivateKey.Curve, w.PrivateKey.PublicKey.X, w.PrivateKey.PublicKey.Y)
+	if secret != nil {
+		tx.Inputs[0].ScriptSig = HTLCRedeemScript(sig, pubKey, secret, contract)
+	} else {
+		tx.Inputs[0].ScriptSig = HTLCRefundScript(sig, pubKey, contract)
+	}
+	tx.ID = tx.Hash()
+	return &tx, nil
+}
+
+// FindContractSpend returns the main chain transaction spending output index
+// of contractTx, or nil while it is unspent.
+func (bc *BlockChain) FindContractSpend(contractTx *Transaction, index int) (*Transaction, error) {
+	history, err := bc.AddressHistory(ExtractScriptHash(contractTx.Outputs[index].ScriptPubKey))
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range history {
+		tx, err := bc.FindTransaction(entry.TxID)
+		if err != nil {
+			return nil, err
+		}
+		for _, in := range tx.Inputs {
+			if bytes.Equal(in.ID, contractTx.ID) && in.OutIndex == index {
+				return &tx, nil
+			}
+		}
+	}
+	return nil, nil
+}
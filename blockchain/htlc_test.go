@@ -0,0 +1,86 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"errors"
+	"testing"
+
+	"github.com/nthskyradiated/blockchain-in-golang/wallet"
+)
+
+func TestHTLCSpends(t *testing.T) {
+	alice, bob := newTestWallet(t), newTestWallet(t)
+	secret := bytes.Repeat([]byte{0x5e}, SecretSize)
+	secretHash := sha256.Sum256(secret)
+	h := HTLC{secretHash[:], wallet.PublicKeyHash(alice.PublicKey), wallet.PublicKeyHash(bob.PublicKey), 100}
+	contract := h.Script()
+	if parsed, err := ParseHTLC(contract); err != nil || !bytes.Equal(parsed.Script(), contract) {
+		t.Fatalf("ParseHTLC = %+v, %v, want the contract back", parsed, err)
+	}
+
+	out, err := NewTXOutput(10, h.Address())
+	if err != nil {
+		t.Fatal(err)
+	}
+	contractTx := &Transaction{Inputs: []TxInput{{bytes.Repeat([]byte{0x11}, 32), 0, nil, 0}}, Outputs: []TxOutput{*out}}
+	contractTx.ID = contractTx.Hash()
+	prevOuts := contractTx.Outputs
+
+	redeem, err := NewHTLCSpend(contractTx, contract, alice, secret, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ExtractSecret(redeem.Inputs[0]); !bytes.Equal(got, secret) {
+		t.Errorf("ExtractSecret = %x, want %x", got, secret)
+	}
+	refund, err := NewHTLCSpend(contractTx, contract, bob, nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refund.LockTime != h.LockTime || ExtractSecret(refund.Inputs[0]) != nil {
+		t.Errorf("refund has lock time %d and reveals a secret, want lock time %d and none", refund.LockTime, h.LockTime)
+	}
+	if _, err := NewHTLCSpend(contractTx, contract, alice, bytes.Repeat([]byte{0x00}, SecretSize), 1); !errors.Is(err, ErrWrongSecret) {
+		t.Errorf("redeeming with a wrong secret: err = %v, want %v", err, ErrWrongSecret)
+	}
+
+	// ? The scripts below are signed properly, so only the engine's checks of the contract stop them
+	sign := func(w *wallet.Wallet) ([]byte, []byte) {
+		t.Helper()
+		sig, err := SignHash(w.PrivateKey, redeem.SigHash(0, contract, prevOuts))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sig, elliptic.Marshal(w.PrivateKey.Curve, w.PrivateKey.PublicKey.X, w.PrivateKey.PublicKey.Y)
+	}
+	aliceSig, alicePubKey := sign(alice)
+	bobSig, bobPubKey := sign(bob)
+	wrongSecret := bytes.Repeat([]byte{0x00}, SecretSize)
+
+	before, after := BlockContext{Height: 99}, BlockContext{Height: 100}
+	tests := []struct {
+		name   string
+		tx     *Transaction
+		unlock []byte
+		ctx    BlockContext
+		want   error
+	}{
+		{"redeem with the secret", redeem, redeem.Inputs[0].ScriptSig, before, nil},
+		{"redeem after the timeout", redeem, redeem.Inputs[0].ScriptSig, after, nil},
+		{"refund after the timeout", refund, refund.Inputs[0].ScriptSig, after, nil},
+		{"refund before the timeout", refund, refund.Inputs[0].ScriptSig, before, ErrTimelocked},
+		{"redeem with a wrong secret", redeem, HTLCRedeemScript(aliceSig, alicePubKey, wrongSecret, contract), before, ErrScriptFailed},
+		{"redeem with a short secret", redeem, HTLCRedeemScript(aliceSig, alicePubKey, secret[:SecretSize-1], contract), before, ErrScriptFailed},
+		{"redeem with the refund key", redeem, HTLCRedeemScript(bobSig, bobPubKey, secret, contract), before, ErrScriptFailed},
+		{"refund with the recipient key", redeem, HTLCRefundScript(aliceSig, alicePubKey, contract), after, ErrScriptFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := executeScripts(tt.tx, 0, tt.unlock, prevOuts, 0, tt.ctx); !errors.Is(err, tt.want) {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
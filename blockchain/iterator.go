@@ -2,7 +2,6 @@ package blockchain
 
 import (
 	"github.com/dgraph-io/badger"
-	"github.com/nthskyradiated/blockchain-in-golang/utils"
 )
 
 type BlockChainIterator struct {
@@ -15,20 +14,16 @@ func (bc *BlockChain) Iterator() *BlockChainIterator {
 	return iter
 }
 
-func (iter *BlockChainIterator) Next() *Block {
+func (iter *BlockChainIterator) Next() (*Block, error) {
 	var block *Block
 	err := iter.Database.View(func(txn *badger.Txn) error {
-		item, err := txn.Get(iter.CurrentHash)
-		utils.HandleError(err)
-		var encodedBlock []byte
-		err = item.Value(func(val []byte) error {
-			encodedBlock = append([]byte{}, val...)
-			return nil
-		})
-		block = utils.Deserialize[*Block](encodedBlock)
+		var err error
+		block, err = getBlock(txn, iter.CurrentHash)
 		return err
 	})
-	utils.HandleError(err)
+	if err != nil {
+		return nil, err
+	}
 	iter.CurrentHash = block.PrevHash
-	return block
-}
\ No newline at end of file
+	return block, nil
+}
@@ -1,11 +1,17 @@
 package blockchain
 
 import (
+	"bytes"
 	"crypto/sha256"
+	"errors"
+	"slices"
 )
 
+var ErrNotInTree = errors.New("transaction is not in the Merkle tree")
+
 type MerkleTree struct {
-	Root *MerkleNode
+	Root   *MerkleNode
+	levels [][]*MerkleNode
 }
 
 type MerkleNode struct {
@@ -14,6 +20,16 @@ type MerkleNode struct {
 	Data  []byte
 }
 
+// MerkleStep is one sibling hash on the path from a leaf to the root. Left
+// tells whether the sibling is hashed in front of the running hash.
+type MerkleStep struct {
+	Hash []byte
+	Left bool
+}
+
+// MerkleProof lists the siblings of a leaf from the bottom of the tree up.
+type MerkleProof []MerkleStep
+
 func NewMerkleNode(left, right *MerkleNode, data []byte) *MerkleNode {
 	node := MerkleNode{}
 
@@ -21,7 +37,7 @@ func NewMerkleNode(left, right *MerkleNode, data []byte) *MerkleNode {
 		hash := sha256.Sum256(data)
 		node.Data = hash[:]
 	} else {
-		prevHashes := append(left.Data, right.Data...)
+		prevHashes := append(append([]byte{}, left.Data...), right.Data...)
 		hash := sha256.Sum256(prevHashes)
 		node.Data = hash[:]
 	}
@@ -31,27 +47,69 @@ func NewMerkleNode(left, right *MerkleNode, data []byte) *MerkleNode {
 	return &node
 }
 
+// NewMerkleTree hashes every item of data into a leaf and pairs nodes level by
+// level. A node left without a partner is carried up to the next level as is,
+// so no leaf is ever duplicated.
 func NewMerkleTree(data [][]byte) *MerkleTree {
-
-	var nodes []MerkleNode
-	if len(data)%2 != 0 {
-		data = append(data, data[len(data)-1])
-	}
+	var nodes []*MerkleNode
 	for _, d := range data {
-		nodes = append(nodes, *NewMerkleNode(nil, nil, d))
+		nodes = append(nodes, NewMerkleNode(nil, nil, d))
+	}
+	if len(nodes) == 0 {
+		return &MerkleTree{Root: &MerkleNode{}}
 	}
 
-	for i := 0; i < len(data)/2; i ++ {
-		var newLevel []MerkleNode
+	levels := [][]*MerkleNode{nodes}
+	for len(nodes) > 1 {
+		var newLevel []*MerkleNode
 		for j := 0; j < len(nodes); j += 2 {
-			if i+1 < len(nodes) {
-				newLevel = append(newLevel, *NewMerkleNode(&nodes[i], &nodes[i+1], nil))
+			if j+1 < len(nodes) {
+				newLevel = append(newLevel, NewMerkleNode(nodes[j], nodes[j+1], nil))
 			} else {
-				newLevel = append(newLevel, nodes[i]) // Odd node, carry it over
+				newLevel = append(newLevel, nodes[j]) // Odd node, carry it over
 			}
 		}
 		nodes = newLevel
+		levels = append(levels, nodes)
+	}
+
+	return &MerkleTree{Root: nodes[0], levels: levels}
+}
+
+// Proof returns the path proving that the leaf built from txID is part of the
+// tree.
+func (t *MerkleTree) Proof(txID []byte) (MerkleProof, error) {
+	if len(t.levels) == 0 {
+		return nil, ErrNotInTree
+	}
+
+	leaf := sha256.Sum256(txID)
+	index := slices.IndexFunc(t.levels[0], func(node *MerkleNode) bool {
+		return bytes.Equal(node.Data, leaf[:])
+	})
+	if index < 0 {
+		return nil, ErrNotInTree
+	}
+
+	proof := MerkleProof{}
+	for _, level := range t.levels[:len(t.levels)-1] {
+		if sibling := index ^ 1; sibling < len(level) {
+			proof = append(proof, MerkleStep{level[sibling].Data, sibling < index})
+		}
+		index /= 2
 	}
+	return proof, nil
+}
 
-	return &MerkleTree{Root: &nodes[0]}
-}
\ No newline at end of file
+// VerifyMerkleProof checks that proof leads from the leaf of txHash to root.
+func VerifyMerkleProof(root, txHash []byte, proof MerkleProof) bool {
+	hash := sha256.Sum256(txHash)
+	for _, step := range proof {
+		if step.Left {
+			hash = sha256.Sum256(append(append([]byte{}, step.Hash...), hash[:]...))
+		} else {
+			hash = sha256.Sum256(append(hash[:], step.Hash...))
+		}
+	}
+	return bytes.Equal(hash[:], root)
+}
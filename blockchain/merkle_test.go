@@ -0,0 +1,72 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func sha(data ...[]byte) []byte {
+	hash := sha256.Sum256(bytes.Join(data, nil))
+	return hash[:]
+}
+
+func testLeaves(n int) [][]byte {
+	var leaves [][]byte
+	for i := 0; i < n; i++ {
+		leaves = append(leaves, []byte(fmt.Sprintf("tx %d", i)))
+	}
+	return leaves
+}
+
+func TestMerkleRoot(t *testing.T) {
+	a, b, c := []byte("a"), []byte("b"), []byte("c")
+
+	// ? The odd leaf is carried up rather than hashed with itself
+	want := sha(sha(sha(a), sha(b)), sha(c))
+	if got := NewMerkleTree([][]byte{a, b, c}).Root.Data; !bytes.Equal(got, want) {
+		t.Fatalf("root = %x, want %x", got, want)
+	}
+}
+
+func TestMerkleProofs(t *testing.T) {
+	for n := 1; n <= 9; n++ {
+		leaves := testLeaves(n)
+		tree := NewMerkleTree(leaves)
+		root := tree.Root.Data
+
+		for i, leaf := range leaves {
+			proof, err := tree.Proof(leaf)
+			if err != nil {
+				t.Fatalf("%d leaves: proof of leaf %d: %v", n, i, err)
+			}
+			if !VerifyMerkleProof(root, leaf, proof) {
+				t.Errorf("%d leaves: proof of leaf %d does not verify", n, i)
+			}
+			if VerifyMerkleProof(root, []byte("not a leaf"), proof) {
+				t.Errorf("%d leaves: proof of leaf %d verifies a wrong leaf", n, i)
+			}
+
+			for j := range proof {
+				tampered := append(MerkleProof{}, proof...)
+				tampered[j].Hash = append([]byte{}, proof[j].Hash...)
+				tampered[j].Hash[0] ^= 1
+				if VerifyMerkleProof(root, leaf, tampered) {
+					t.Errorf("%d leaves: proof of leaf %d verifies with step %d tampered", n, i, j)
+				}
+
+				flipped := append(MerkleProof{}, proof...)
+				flipped[j].Left = !flipped[j].Left
+				if VerifyMerkleProof(root, leaf, flipped) {
+					t.Errorf("%d leaves: proof of leaf %d verifies with step %d on the wrong side", n, i, j)
+				}
+			}
+		}
+
+		if _, err := tree.Proof([]byte("not a leaf")); !errors.Is(err, ErrNotInTree) {
+			t.Errorf("%d leaves: proof of a missing leaf: err = %v, want %v", n, err, ErrNotInTree)
+		}
+	}
+}
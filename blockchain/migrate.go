@@ -0,0 +1,327 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/dgraph-io/badger"
+)
+
+// dbVersion 2 stores blocks in the encoding of encoding.go, version 3 adds
+// the transaction and address indexes, version 4 records where unspent
+// outputs came from, version 5 stores transactions whose inputs and outputs
+// carry scripts and version 6 adds lock times and input sequences. Databases
+// without a version key still hold gob encoded blocks.
+//
+// Migrated blocks keep the hashes and transaction IDs they were created
+// with, which commit to the layout they were written in. Their outputs are
+// given the P2PKH scripts of the pubkey hashes they were locked to, so they
+// can be spent as before, and the UTXO set is rebuilt from them.
+const dbVersion = 6
+
+var dbVersionKey = []byte("dbversion")
+
+var ErrNewerDatabase = errors.New("database was written by a newer version")
+
+func setDBVersion(txn *badger.Txn) error {
+	return txn.Set(dbVersionKey, []byte{dbVersion})
+}
+
+func getDBVersion(txn *badger.Txn) (byte, error) {
+	item, err := txn.Get(dbVersionKey)
+	if err == badger.ErrKeyNotFound {
+		return 1, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	v, err := item.ValueCopy(nil)
+	if err != nil {
+		return 0, err
+	}
+	return v[0], nil
+}
+
+// migrateDB brings an old database up to dbVersion.
+func migrateDB(db *badger.DB) error {
+	return db.Update(func(txn *badger.Txn) error {
+		version, err := getDBVersion(txn)
+		if err != nil || version == dbVersion {
+			return err
+		}
+		if version > dbVersion {
+			return fmt.Errorf("%w (version %d)", ErrNewerDatabase, version)
+		}
+
+		if err := migrateBlocks(txn, version); err != nil {
+			return err
+		}
+		if version < 5 {
+			if err := rebuildChainState(txn); err != nil {
+				return err
+			}
+		}
+		log.Printf("migrated database from version %d to %d", version, dbVersion)
+		return setDBVersion(txn)
+	})
+}
+
+// legacyTxInput is an input from before scripts, signed with a bare key. In
+// a coinbase PubKey holds the arbitrary data.
+type legacyTxInput struct {
+	ID       []byte
+	OutIndex int
+	Sig      []byte
+	PubKey   []byte
+}
+
+func (in legacyTxInput) upgrade() TxInput {
+	if len(in.ID) == 0 && in.OutIndex == -1 {
+		return TxInput{in.ID, in.OutIndex, in.PubKey, 0}
+	}
+	return TxInput{in.ID, in.OutIndex, P2PKHUnlockScript(in.Sig, in.PubKey), 0}
+}
+
+// upgradeOutput locks an output from before scripts, whose ScriptPubKey is a
+// bare pubkey hash, with the equivalent P2PKH script.
+func upgradeOutput(out TxOutput) TxOutput {
+	return TxOutput{out.Value, P2PKHScript(out.ScriptPubKey)}
+}
+
+type legacyTransaction struct {
+	ID      []byte
+	Inputs  []legacyTxInput
+	Outputs []TxOutput
+}
+
+func (tx legacyTransaction) upgrade() *Transaction {
+	upgraded := &Transaction{ID: tx.ID}
+	for _, in := range tx.Inputs {
+		upgraded.Inputs = append(upgraded.Inputs, in.upgrade())
+	}
+	for _, out := range tx.Outputs {
+		upgraded.Outputs = append(upgraded.Outputs, upgradeOutput(out))
+	}
+	return upgraded
+}
+
+// legacyBlock is a block as version 1 databases stored it with encoding/gob.
+// Bits is only present in blocks mined with a retargeted difficulty.
+type legacyBlock struct {
+	Timestamp    int64
+	Hash         []byte
+	Transactions []*legacyTransaction
+	PrevHash     []byte
+	Nonce        int
+	Height       int
+	Bits         uint32
+}
+
+// legacyHeaderBlock is a version 1 block that already carried a full header.
+type legacyHeaderBlock struct {
+	Timestamp    int64
+	Hash         []byte
+	Transactions []*legacyTransaction
+	PrevHash     []byte
+	Nonce        uint32
+	Height       int
+	Bits         uint32
+	MerkleRoot   []byte
+	Version      int32
+}
+
+// deserializeGobBlock reads a block of a version 1 database. Header fields
+// the block was stored without are filled in, so difficulty retargeting
+// starts from InitialBits at the migrated chain.
+func deserializeGobBlock(data []byte) (*Block, error) {
+	var block *Block
+	var txs []*legacyTransaction
+
+	var legacy legacyBlock
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&legacy); err == nil {
+		block = &Block{
+			Timestamp: legacy.Timestamp,
+			Hash:      legacy.Hash,
+			PrevHash:  legacy.PrevHash,
+			Nonce:     uint32(legacy.Nonce),
+			Height:    legacy.Height,
+			Bits:      legacy.Bits,
+		}
+		txs = legacy.Transactions
+	} else {
+		var header legacyHeaderBlock
+		if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&header); err != nil {
+			return nil, err
+		}
+		block = &Block{
+			Timestamp:  header.Timestamp,
+			Hash:       header.Hash,
+			PrevHash:   header.PrevHash,
+			Nonce:      header.Nonce,
+			Height:     header.Height,
+			Bits:       header.Bits,
+			MerkleRoot: header.MerkleRoot,
+			Version:    header.Version,
+		}
+		txs = header.Transactions
+	}
+
+	for _, tx := range txs {
+		block.Transactions = append(block.Transactions, tx.upgrade())
+	}
+	if block.Bits == 0 {
+		block.Bits = InitialBits
+	}
+	if block.Version == 0 {
+		block.Version = BlockVersion
+	}
+	if len(block.MerkleRoot) == 0 {
+		block.MerkleRoot = block.HashTransactions()
+	}
+	return block, nil
+}
+
+// legacyTransaction reads a transaction in the encoding of version 2 to 4
+// databases, or of version 5 ones with scripts but no lock times.
+func (d *decoder) legacyTransaction(version byte) *Transaction {
+	if version < 5 {
+		tx := legacyTransaction{ID: d.bytes()}
+		tx.Inputs = make([]legacyTxInput, d.count(16))
+		for i := range tx.Inputs {
+			tx.Inputs[i].ID = d.bytes()
+			tx.Inputs[i].OutIndex = int(int32(d.uint32()))
+			tx.Inputs[i].Sig = d.bytes()
+			tx.Inputs[i].PubKey = d.bytes()
+		}
+		tx.Outputs = make([]TxOutput, d.count(12))
+		for i := range tx.Outputs {
+			tx.Outputs[i] = d.output()
+		}
+		return tx.upgrade()
+	}
+
+	tx := &Transaction{ID: d.bytes()}
+	tx.Inputs = make([]TxInput, d.count(12))
+	for i := range tx.Inputs {
+		tx.Inputs[i].ID = d.bytes()
+		tx.Inputs[i].OutIndex = int(int32(d.uint32()))
+		tx.Inputs[i].ScriptSig = d.bytes()
+	}
+	tx.Outputs = make([]TxOutput, d.count(12))
+	for i := range tx.Outputs {
+		tx.Outputs[i] = d.output()
+	}
+	return tx
+}
+
+// deserializeLegacyBlock reads a block written by a database at version.
+func deserializeLegacyBlock(data []byte, version byte) (*Block, error) {
+	if version < 2 {
+		return deserializeGobBlock(data)
+	}
+
+	d := decoder{data: data}
+	block := &Block{Hash: d.bytes()}
+	block.setHeader(d.header())
+	block.Transactions = make([]*Transaction, d.count(4))
+	for i := range block.Transactions {
+		td := decoder{data: d.bytes()}
+		block.Transactions[i] = td.legacyTransaction(version)
+		if err := td.finish(); err != nil {
+			return nil, err
+		}
+	}
+	if err := d.finish(); err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+// migrateBlocks rewrites every block stored by a database at version in the
+// current encoding.
+func migrateBlocks(txn *badger.Txn, version byte) error {
+	var keys, blocks [][]byte
+	it := txn.NewIterator(badger.DefaultIteratorOptions)
+	for it.Rewind(); it.Valid(); it.Next() {
+		item := it.Item()
+		// ? Blocks are the only entries keyed by a bare 32 byte hash
+		if len(item.Key()) != 32 {
+			continue
+		}
+		v, err := item.ValueCopy(nil)
+		if err == nil {
+			var block *Block
+			if block, err = deserializeLegacyBlock(v, version); err == nil {
+				keys = append(keys, item.KeyCopy(nil))
+				blocks = append(blocks, block.Serialize())
+			}
+		}
+		if err != nil {
+			it.Close()
+			return fmt.Errorf("migrating block %x: %w", item.Key(), err)
+		}
+	}
+	it.Close()
+
+	for i, key := range keys {
+		if err := txn.Set(key, blocks[i]); err != nil {
+			return err
+		}
+	}
+	log.Printf("migrated %d blocks to the current encoding", len(keys))
+	return nil
+}
+
+// rebuildChainState replaces the UTXO set, undo records and indexes of a
+// database from before scripts by connecting its main chain again from the
+// genesis block. Version 1 databases kept the UTXO set without output indexes
+// and no undo records, so their blocks could not be disconnected otherwise.
+func rebuildChainState(txn *badger.Txn) error {
+	for _, prefix := range [][]byte{utxoPrefix, undoPrefix, txIndexPrefix, addrIndexPrefix} {
+		if err := deletePrefix(txn, prefix); err != nil {
+			return err
+		}
+	}
+
+	var chain []*Block
+	hash, err := getLastHash(txn)
+	for err == nil && len(hash) > 0 {
+		var block *Block
+		if block, err = getBlock(txn, hash); err == nil {
+			chain = append(chain, block)
+			hash = block.PrevHash
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	for i := len(chain) - 1; i >= 0; i-- {
+		if err := connectBlock(txn, chain[i]); err != nil {
+			return fmt.Errorf("connecting block %x: %w", chain[i].Hash, err)
+		}
+	}
+	log.Printf("rebuilt the UTXO set and indexes from %d blocks", len(chain))
+	return nil
+}
+
+func deletePrefix(txn *badger.Txn, prefix []byte) error {
+	var keys [][]byte
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	it := txn.NewIterator(opts)
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		keys = append(keys, it.Item().KeyCopy(nil))
+	}
+	it.Close()
+
+	for _, key := range keys {
+		if err := txn.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
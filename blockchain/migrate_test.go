@@ -0,0 +1,246 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/nthskyradiated/blockchain-in-golang/utils"
+	"github.com/nthskyradiated/blockchain-in-golang/wallet"
+)
+
+// The baseline types are the ones version 1 databases were written with,
+// before block headers, scripts and the binary encoding.
+type baselineTxInput struct {
+	ID       []byte
+	OutIndex int
+	Sig      []byte
+	PubKey   []byte
+}
+
+type baselineTxOutput struct {
+	Value        int
+	ScriptPubKey []byte
+}
+
+type baselineTransaction struct {
+	ID      []byte
+	Inputs  []baselineTxInput
+	Outputs []baselineTxOutput
+}
+
+type baselineTxOutputs struct {
+	Outputs []baselineTxOutput
+}
+
+type baselineBlock struct {
+	Timestamp    int64
+	Hash         []byte
+	Transactions []*baselineTransaction
+	PrevHash     []byte
+	Nonce        int
+	Height       int
+}
+
+const migrateNodeID = "migrate"
+
+var (
+	aliceHash = bytes.Repeat([]byte{0xa1}, 20)
+	bobHash   = bytes.Repeat([]byte{0xb0}, 20)
+)
+
+func baselineCoinbase(id string, to []byte) *baselineTransaction {
+	return &baselineTransaction{
+		ID:      sha([]byte(id)),
+		Inputs:  []baselineTxInput{{OutIndex: -1, PubKey: []byte(id)}},
+		Outputs: []baselineTxOutput{{20, to}},
+	}
+}
+
+// baselineChain returns a genesis block paying alice and a block in which
+// alice pays bob 5 of those coins.
+func baselineChain() []*baselineBlock {
+	now := time.Now().Unix()
+	genesisCoinbase := baselineCoinbase("genesis", aliceHash)
+	genesis := &baselineBlock{now - 3600, sha([]byte("block 0")), []*baselineTransaction{genesisCoinbase}, []byte{}, 4242, 0}
+
+	payment := &baselineTransaction{
+		ID:      sha([]byte("payment")),
+		Inputs:  []baselineTxInput{{genesisCoinbase.ID, 0, []byte("sig"), []byte("alice")}},
+		Outputs: []baselineTxOutput{{5, bobHash}, {15, aliceHash}},
+	}
+	block := &baselineBlock{now - 1800, sha([]byte("block 1")), []*baselineTransaction{baselineCoinbase("coinbase 1", aliceHash), payment}, genesis.Hash, 77, 1}
+	return []*baselineBlock{genesis, block}
+}
+
+// writeBaselineDB stores blocks the way a version 1 node did, with records
+// set for any other keys it kept.
+func writeBaselineDB(t *testing.T, blocks []*baselineBlock, records map[string][]byte) {
+	t.Helper()
+	db, err := badger.Open(badger.DefaultOptions(fmt.Sprintf(dbPath, migrateNodeID)).WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	err = db.Update(func(txn *badger.Txn) error {
+		for _, block := range blocks {
+			if err := txn.Set(block.Hash, utils.Serialize(*block)); err != nil {
+				return err
+			}
+		}
+		for key, value := range records {
+			if err := txn.Set([]byte(key), value); err != nil {
+				return err
+			}
+		}
+		return txn.Set([]byte("lh"), blocks[len(blocks)-1].Hash)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// chdirTemp runs the test in an empty directory with the ./tmp directory
+// chains are stored under.
+func chdirTemp(t *testing.T) {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	if err := os.Mkdir("tmp", 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigrateBaselineBlocks(t *testing.T) {
+	chdirTemp(t)
+	legacy := baselineChain()
+	writeBaselineDB(t, legacy, nil)
+
+	chain, err := ContinueBlockChain(migrateNodeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Database.Close()
+
+	for _, want := range legacy {
+		block, err := chain.GetBlock(want.Hash)
+		if err != nil {
+			t.Fatalf("block %x: %v", want.Hash, err)
+		}
+		if block.Nonce != uint32(want.Nonce) || block.Height != want.Height || block.Timestamp != want.Timestamp {
+			t.Errorf("block %d: header = %d/%d/%d, want %d/%d/%d", want.Height,
+				block.Nonce, block.Height, block.Timestamp, want.Nonce, want.Height, want.Timestamp)
+		}
+		if block.Bits != InitialBits || block.Version != BlockVersion {
+			t.Errorf("block %d: bits %08x version %d, want %08x %d", want.Height, block.Bits, block.Version, InitialBits, BlockVersion)
+		}
+		if !bytes.Equal(block.MerkleRoot, block.HashTransactions()) {
+			t.Errorf("block %d: Merkle root does not match the transactions", want.Height)
+		}
+		for i, tx := range block.Transactions {
+			if !bytes.Equal(tx.ID, want.Transactions[i].ID) {
+				t.Errorf("block %d tx %d: ID %x, want %x", want.Height, i, tx.ID, want.Transactions[i].ID)
+			}
+			for j, out := range tx.Outputs {
+				legacyOut := want.Transactions[i].Outputs[j]
+				if out.Value != legacyOut.Value || !bytes.Equal(out.ScriptPubKey, P2PKHScript(legacyOut.ScriptPubKey)) {
+					t.Errorf("block %d tx %d output %d = %d %x, want %d locked to %x", want.Height, i, j,
+						out.Value, out.ScriptPubKey, legacyOut.Value, legacyOut.ScriptPubKey)
+				}
+			}
+		}
+	}
+
+	payment := legacy[1].Transactions[1]
+	tx, err := chain.FindTransaction(payment.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := P2PKHUnlockScript([]byte("sig"), []byte("alice")); !bytes.Equal(tx.Inputs[0].ScriptSig, want) {
+		t.Errorf("payment unlock script = %x, want %x", tx.Inputs[0].ScriptSig, want)
+	}
+
+	// ? A zero difficulty would make mining on top of the migrated tip spin forever
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	coinbase, err := CoinbaseTx(wallet.PubKeyHashAddress(aliceHash), "", 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := chain.MineBlock(ctx, []*Transaction{coinbase})
+	if err != nil {
+		t.Fatalf("mining on the migrated chain: %v", err)
+	}
+	if block.Height != 2 || block.Bits != InitialBits || !bytes.Equal(chain.LastHash, block.Hash) {
+		t.Errorf("mined block %d with bits %08x, tip %x, want block 2 with bits %08x as the tip", block.Height, block.Bits, chain.LastHash, InitialBits)
+	}
+}
+
+func TestMigrateBaselineUTXOSet(t *testing.T) {
+	chdirTemp(t)
+	legacy := baselineChain()
+	coinbase, payment := legacy[1].Transactions[0], legacy[1].Transactions[1]
+	// ? Version 1 nodes stored what was left of each transaction's outputs as a slice
+	writeBaselineDB(t, legacy, map[string][]byte{
+		string(utxoKey(coinbase.ID)): utils.Serialize(baselineTxOutputs{coinbase.Outputs}),
+		string(utxoKey(payment.ID)):  utils.Serialize(baselineTxOutputs{payment.Outputs}),
+	})
+
+	chain, err := ContinueBlockChain(migrateNodeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Database.Close()
+
+	UTXO := UTXOSet{chain}
+	for _, c := range []struct {
+		name       string
+		pubKeyHash []byte
+		want       int
+	}{{"alice", aliceHash, 35}, {"bob", bobHash, 5}} {
+		mature, immature, err := UTXO.Balance(c.pubKeyHash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mature+immature != c.want {
+			t.Errorf("balance of %s = %d, want %d", c.name, mature+immature, c.want)
+		}
+	}
+
+	err = chain.Database.Update(func(txn *badger.Txn) error {
+		outs, err := getUTXO(txn, payment.ID)
+		if err != nil {
+			return err
+		}
+		if len(outs.Outputs) != 2 || outs.Outputs[1].Value != 15 || outs.Height != 1 || outs.Coinbase {
+			t.Errorf("payment outputs = %+v, want both outputs from block 1", outs)
+		}
+
+		block, err := getBlock(txn, legacy[1].Hash)
+		if err != nil {
+			return err
+		}
+		// ? Baseline databases had no undo records, so this needs the ones the migration built
+		if err := disconnectBlock(txn, block); err != nil {
+			return err
+		}
+		outs, err = getUTXO(txn, legacy[0].Transactions[0].ID)
+		if err != nil {
+			return err
+		}
+		if out := outs.Outputs[0]; out.Value != 20 || !bytes.Equal(out.ScriptPubKey, P2PKHScript(aliceHash)) || !outs.Coinbase {
+			t.Errorf("restored genesis outputs = %+v, want the coinbase paying alice", outs)
+		}
+		if _, err := getUTXO(txn, payment.ID); err != badger.ErrKeyNotFound {
+			t.Errorf("payment outputs after disconnecting its block: err = %v, want %v", err, badger.ErrKeyNotFound)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
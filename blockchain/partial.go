@@ -0,0 +1,264 @@
+package blockchain
+
+// Partially signed transactions (PST) carry an unsigned transaction together
+// with the outputs it spends, so a machine without the chain can sign it. They
+// are written in the encoding of encoding.go:
+//
+//	PST   = "PST" uint8(PSTVersion) bytes(Transaction) uint32(count) Input...
+//	Input = TxOutput bytes(RedeemScript) uint32(count) Sig...
+//	Sig   = bytes(PubKey) bytes(Signature)
+//
+// Signatures are sorted by public key so the same PST always encodes the same.
+// They commit to the outputs the PST says are spent, so a PST lying about
+// their values or scripts only gets signatures the chain rejects.
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/nthskyradiated/blockchain-in-golang/wallet"
+)
+
+const PSTVersion = 1
+
+var pstMagic = []byte("PST")
+
+var (
+	ErrIncompleteTx    = errors.New("transaction is missing signatures")
+	ErrPartialMismatch = errors.New("partially signed transactions are not the same transaction")
+	ErrNothingToSign   = errors.New("no input can be signed with these keys")
+	ErrNotPST          = errors.New("not a partially signed transaction")
+)
+
+// PartialTx is a transaction collecting the signatures of its inputs, such
+// as the M of N needed to spend from a multisig address, from wallets that
+// may live on different nodes.
+type PartialTx struct {
+	Tx     Transaction
+	Inputs []PartialInput
+}
+
+// PartialInput is what a signer needs to know about one input: the output it
+// spends, the redeem script if that output pays to a script hash, and the
+// signatures gathered so far keyed by hex public key.
+type PartialInput struct {
+	Prevout      TxOutput
+	RedeemScript []byte
+	Sigs         map[string][]byte
+}
+
+// NewPartialTx wraps the unsigned tx for signing. redeemScripts maps the hash
+// of each redeem script to the script, for the inputs spending P2SH outputs.
+func (bc *BlockChain) NewPartialTx(tx *Transaction, redeemScripts map[string][]byte) (*PartialTx, error) {
+	prevTXs, err := bc.prevTransactions(tx)
+	if err != nil {
+		return nil, err
+	}
+	p := &PartialTx{Tx: *tx}
+	for _, in := range tx.Inputs {
+		prevTX := prevTXs[hex.EncodeToString(in.ID)]
+		if in.OutIndex < 0 || in.OutIndex >= len(prevTX.Outputs) {
+			return nil, fmt.Errorf("previous transaction %x output %d: %w", in.ID, in.OutIndex, ErrMissingInput)
+		}
+		input := PartialInput{Prevout: prevTX.Outputs[in.OutIndex], Sigs: make(map[string][]byte)}
+		if scriptHash := ExtractScriptHash(input.Prevout.ScriptPubKey); scriptHash != nil {
+			input.RedeemScript = redeemScripts[hex.EncodeToString(scriptHash)]
+			if input.RedeemScript == nil {
+				return nil, fmt.Errorf("no redeem script for script hash %x", scriptHash)
+			}
+		}
+		p.Inputs = append(p.Inputs, input)
+	}
+	return p, nil
+}
+
+// Sign adds privKey's signature to every input it can sign that does not have
+// it yet, and returns how many it added.
+func (p *PartialTx) Sign(privKey ecdsa.PrivateKey) (int, error) {
+	pubKey := elliptic.Marshal(privKey.Curve, privKey.PublicKey.X, privKey.PublicKey.Y)
+	key := hex.EncodeToString(pubKey)
+
+	var prevOuts []TxOutput
+	for _, input := range p.Inputs {
+		prevOuts = append(prevOuts, input.Prevout)
+	}
+
+	added := 0
+	for i := range p.Inputs {
+		if p.Inputs[i].Sigs == nil {
+			p.Inputs[i].Sigs = make(map[string][]byte)
+		}
+		input := p.Inputs[i]
+		if _, ok := input.Sigs[key]; ok || !input.canSign(pubKey) {
+			continue
+		}
+		lock := input.Prevout.ScriptPubKey
+		if input.RedeemScript != nil {
+			lock = input.RedeemScript
+		}
+		sig, err := SignHash(privKey, p.Tx.SigHash(i, lock, prevOuts))
+		if err != nil {
+			return added, err
+		}
+		input.Sigs[key] = sig
+		added++
+	}
+	return added, nil
+}
+
+func (input PartialInput) canSign(pubKey []byte) bool {
+	if input.RedeemScript == nil {
+		pubKeyHash := ExtractPubKeyHash(input.Prevout.ScriptPubKey)
+		return pubKeyHash != nil && bytes.Equal(pubKeyHash, wallet.PublicKeyHash(pubKey))
+	}
+	_, pubKeys, _ := ParseMultisigScript(input.RedeemScript)
+	for _, k := range pubKeys {
+		if bytes.Equal(k, pubKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// Combine adds the signatures other has collected for the same transaction.
+func (p *PartialTx) Combine(other *PartialTx) error {
+	if !bytes.Equal(p.Tx.Hash(), other.Tx.Hash()) || len(p.Inputs) != len(other.Inputs) {
+		return ErrPartialMismatch
+	}
+	for i, input := range other.Inputs {
+		if p.Inputs[i].Sigs == nil {
+			p.Inputs[i].Sigs = make(map[string][]byte)
+		}
+		for key, sig := range input.Sigs {
+			if _, ok := p.Inputs[i].Sigs[key]; !ok {
+				p.Inputs[i].Sigs[key] = sig
+			}
+		}
+	}
+	return nil
+}
+
+// Missing returns how many more signatures the inputs need in total.
+func (p *PartialTx) Missing() int {
+	missing := 0
+	for _, input := range p.Inputs {
+		missing += max(input.required()-len(input.Sigs), 0)
+	}
+	return missing
+}
+
+func (input PartialInput) required() int {
+	if input.RedeemScript == nil {
+		return 1
+	}
+	required, _, _ := ParseMultisigScript(input.RedeemScript)
+	return required
+}
+
+// Finalize writes the unlocking scripts from the collected signatures and
+// returns the finished transaction.
+func (p *PartialTx) Finalize() (*Transaction, error) {
+	if missing := p.Missing(); missing > 0 {
+		return nil, fmt.Errorf("%w: %d more needed", ErrIncompleteTx, missing)
+	}
+	tx := p.Tx
+	tx.Inputs = append([]TxInput{}, p.Tx.Inputs...)
+	for i, input := range p.Inputs {
+		if input.RedeemScript == nil {
+			for key, sig := range input.Sigs {
+				pubKey, err := hex.DecodeString(key)
+				if err != nil {
+					return nil, err
+				}
+				tx.Inputs[i].ScriptSig = P2PKHUnlockScript(sig, pubKey)
+			}
+			continue
+		}
+
+		// ? OP_CHECKMULTISIG wants exactly the required signatures, in the order of their keys
+		required, pubKeys, _ := ParseMultisigScript(input.RedeemScript)
+		script := ScriptBuilder{}
+		for _, pubKey := range pubKeys {
+			if sig, ok := input.Sigs[hex.EncodeToString(pubKey)]; ok && required > 0 {
+				script = script.Data(sig)
+				required--
+			}
+		}
+		tx.Inputs[i].ScriptSig = script.Data(input.RedeemScript)
+	}
+	tx.ID = tx.Hash()
+	return &tx, nil
+}
+
+// Fee returns what the inputs hold beyond the outputs.
+func (p *PartialTx) Fee() int {
+	fee := 0
+	for _, input := range p.Inputs {
+		fee += input.Prevout.Value
+	}
+	for _, out := range p.Tx.Outputs {
+		fee -= out.Value
+	}
+	return fee
+}
+
+func (p *PartialTx) Serialize() []byte {
+	data := append(append([]byte{}, pstMagic...), PSTVersion)
+	data = appendBytes(data, p.Tx.Serialize())
+	data = binary.BigEndian.AppendUint32(data, uint32(len(p.Inputs)))
+	for _, input := range p.Inputs {
+		data = input.Prevout.appendTo(data)
+		data = appendBytes(data, input.RedeemScript)
+		data = binary.BigEndian.AppendUint32(data, uint32(len(input.Sigs)))
+		for _, key := range slices.Sorted(maps.Keys(input.Sigs)) {
+			pubKey, _ := hex.DecodeString(key)
+			data = appendBytes(data, pubKey)
+			data = appendBytes(data, input.Sigs[key])
+		}
+	}
+	return data
+}
+
+func DeserializePartialTx(data []byte) (*PartialTx, error) {
+	if !bytes.HasPrefix(data, pstMagic) || len(data) < len(pstMagic)+1 {
+		return nil, ErrNotPST
+	}
+	if v := data[len(pstMagic)]; v != PSTVersion {
+		return nil, fmt.Errorf("%w: unknown version %d", ErrNotPST, v)
+	}
+	d := decoder{data: data[len(pstMagic)+1:]}
+
+	tx, err := DeserializeTransaction(d.bytes())
+	if err != nil {
+		return nil, err
+	}
+	p := &PartialTx{Tx: *tx, Inputs: make([]PartialInput, d.count(16))}
+	for i := range p.Inputs {
+		input := &p.Inputs[i]
+		input.Prevout.Value = int(int64(d.uint64()))
+		input.Prevout.ScriptPubKey = d.bytes()
+		input.RedeemScript = d.bytes()
+		if len(input.RedeemScript) == 0 {
+			input.RedeemScript = nil
+		}
+		input.Sigs = make(map[string][]byte)
+		for range d.count(8) {
+			key := hex.EncodeToString(d.bytes())
+			input.Sigs[key] = d.bytes()
+		}
+	}
+	if err := d.finish(); err != nil {
+		return nil, err
+	}
+	if len(p.Inputs) != len(p.Tx.Inputs) {
+		return nil, ErrMalformedData
+	}
+	return p, nil
+}
@@ -0,0 +1,167 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/nthskyradiated/blockchain-in-golang/wallet"
+)
+
+// testPartialTx spends a 2-of-3 multisig output of alice, bob and carol and
+// an output paying alice.
+func testPartialTx(t *testing.T, alice, bob, carol testKey) *PartialTx {
+	t.Helper()
+	multisig, err := MultisigScript(2, [][]byte{alice.pubKey, bob.pubKey, carol.pubKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := Transaction{
+		Inputs: []TxInput{
+			{bytes.Repeat([]byte{0x11}, 32), 0, nil, 0},
+			{bytes.Repeat([]byte{0x22}, 32), 3, nil, 0},
+		},
+		Outputs: []TxOutput{{25, P2PKHScript(bytes.Repeat([]byte{0x44}, 20))}},
+	}
+	return &PartialTx{Tx: tx, Inputs: []PartialInput{
+		{TxOutput{20, P2SHScript(wallet.PublicKeyHash(multisig))}, multisig, make(map[string][]byte)},
+		{TxOutput{10, P2PKHScript(alice.hash())}, nil, make(map[string][]byte)},
+	}}
+}
+
+func roundTrip(t *testing.T, p *PartialTx) *PartialTx {
+	t.Helper()
+	decoded, err := DeserializePartialTx(p.Serialize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return decoded
+}
+
+func TestPartialTxRoundTrip(t *testing.T) {
+	alice, bob, carol := newTestKey(t), newTestKey(t), newTestKey(t)
+	p := testPartialTx(t, alice, bob, carol)
+	if p.Missing() != 3 || p.Fee() != 5 {
+		t.Fatalf("missing %d signatures with fee %d, want 3 and 5", p.Missing(), p.Fee())
+	}
+	decoded := roundTrip(t, p)
+	if !bytes.Equal(decoded.Tx.Hash(), p.Tx.Hash()) || !bytes.Equal(decoded.Serialize(), p.Serialize()) {
+		t.Errorf("unsigned PST does not encode the same after a round trip")
+	}
+	for i, input := range decoded.Inputs {
+		want := p.Inputs[i]
+		if input.Prevout.Value != want.Prevout.Value || !bytes.Equal(input.Prevout.ScriptPubKey, want.Prevout.ScriptPubKey) ||
+			!bytes.Equal(input.RedeemScript, want.RedeemScript) || (input.RedeemScript == nil) != (want.RedeemScript == nil) {
+			t.Errorf("input %d decodes as %+v, want %+v", i, input, want)
+		}
+	}
+
+	// ? Alice and carol sign their own copies, as they would on separate machines
+	aliceCopy, carolCopy := roundTrip(t, p), roundTrip(t, p)
+	if added, err := aliceCopy.Sign(alice.priv); err != nil || added != 2 {
+		t.Fatalf("alice signed %d inputs, %v, want both", added, err)
+	}
+	if added, err := aliceCopy.Sign(alice.priv); err != nil || added != 0 {
+		t.Errorf("alice signing again added %d signatures, %v, want none", added, err)
+	}
+	if added, err := carolCopy.Sign(carol.priv); err != nil || added != 1 {
+		t.Fatalf("carol signed %d inputs, %v, want the multisig one", added, err)
+	}
+	if added, _ := carolCopy.Sign(newTestKey(t).priv); added != 0 {
+		t.Errorf("a stranger signed %d inputs", added)
+	}
+	if _, err := aliceCopy.Finalize(); !errors.Is(err, ErrIncompleteTx) {
+		t.Errorf("finalizing with one multisig signature: err = %v, want %v", err, ErrIncompleteTx)
+	}
+
+	signed := roundTrip(t, aliceCopy)
+	if !bytes.Equal(signed.Serialize(), aliceCopy.Serialize()) {
+		t.Errorf("signed PST does not encode the same after a round trip")
+	}
+	if err := signed.Combine(roundTrip(t, carolCopy)); err != nil {
+		t.Fatal(err)
+	}
+	if signed.Missing() != 0 {
+		t.Fatalf("combined PST is missing %d signatures", signed.Missing())
+	}
+
+	tx, err := signed.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsigned := tx.TrimmedCopy()
+	if !bytes.Equal(tx.ID, tx.Hash()) || !bytes.Equal(unsigned.Hash(), p.Tx.Hash()) {
+		t.Errorf("finalized transaction %x is not the PST's transaction", tx.ID)
+	}
+	prevOuts := []TxOutput{p.Inputs[0].Prevout, p.Inputs[1].Prevout}
+	for i, in := range tx.Inputs {
+		if err := executeScripts(tx, i, in.ScriptSig, prevOuts, 0, BlockContext{Height: 1}); err != nil {
+			t.Errorf("input %d: %v", i, err)
+		}
+	}
+}
+
+func TestPartialTxErrors(t *testing.T) {
+	alice, bob, carol := newTestKey(t), newTestKey(t), newTestKey(t)
+	p := testPartialTx(t, alice, bob, carol)
+
+	other := roundTrip(t, p)
+	other.Tx.Outputs[0].Value--
+	if err := p.Combine(other); !errors.Is(err, ErrPartialMismatch) {
+		t.Errorf("combining another transaction: err = %v, want %v", err, ErrPartialMismatch)
+	}
+
+	data := p.Serialize()
+	for _, c := range []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"empty", nil, ErrNotPST},
+		{"transaction", p.Tx.Serialize(), ErrNotPST},
+		{"unknown version", append([]byte("PST\x02"), data[4:]...), ErrNotPST},
+		{"truncated", data[:len(data)-1], ErrMalformedData},
+		{"trailing bytes", append(append([]byte{}, data...), 0), ErrMalformedData},
+	} {
+		if _, err := DeserializePartialTx(c.data); !errors.Is(err, c.want) {
+			t.Errorf("%s: err = %v, want %v", c.name, err, c.want)
+		}
+	}
+}
+
+func TestSigHashCommitsToPrevouts(t *testing.T) {
+	alice := newTestKey(t)
+	lock := P2PKHScript(alice.hash())
+	tx := &Transaction{
+		Inputs:  []TxInput{{bytes.Repeat([]byte{0x11}, 32), 0, nil, 0}, {bytes.Repeat([]byte{0x22}, 32), 1, nil, 0}},
+		Outputs: []TxOutput{{15, P2PKHScript(bytes.Repeat([]byte{0x44}, 20))}},
+	}
+	prevOuts := []TxOutput{{10, lock}, {10, lock}}
+	hash := tx.SigHash(0, lock, prevOuts)
+
+	// ? Changing the output spent by the other input changes what input 0 signs too
+	for _, changed := range [][]TxOutput{
+		{{11, lock}, {10, lock}},
+		{{10, lock}, {9, lock}},
+		{{10, lock}, {10, P2PKHScript(bytes.Repeat([]byte{0x55}, 20))}},
+		{{10, lock}},
+	} {
+		if bytes.Equal(tx.SigHash(0, lock, changed), hash) {
+			t.Errorf("prevouts %+v give the same signature hash", changed)
+		}
+	}
+
+	sig, err := SignHash(alice.priv, hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock := P2PKHUnlockScript(sig, alice.pubKey)
+	if err := executeScripts(tx, 0, unlock, prevOuts, 0, BlockContext{Height: 1}); err != nil {
+		t.Fatal(err)
+	}
+	// ? A signer told the wrong amount signs a transaction the chain rejects
+	lied := []TxOutput{{10, lock}, {1000, lock}}
+	if err := executeScripts(tx, 0, unlock, lied, 0, BlockContext{Height: 1}); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("spending prevouts other than the signed ones: err = %v, want %v", err, ErrInvalidSignature)
+	}
+}
@@ -0,0 +1,132 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/nthskyradiated/blockchain-in-golang/utils"
+)
+
+// Transactions sent from this node reserve the outputs they spend until they
+// are mined, so sending again before the next block picks other coins:
+//
+//	pending-<txid> -> PendingTx
+var pendingPrefix = []byte("pending-")
+
+// PendingExpiry is how long a sent transaction keeps its outputs reserved if
+// it never makes it into a block.
+const PendingExpiry = 24 * time.Hour
+
+var ErrNotPending = errors.New("no outputs are reserved for that transaction")
+
+type Outpoint struct {
+	TxID  []byte
+	Index int
+}
+
+func (o Outpoint) String() string {
+	return fmt.Sprintf("%x:%d", o.TxID, o.Index)
+}
+
+type PendingTx struct {
+	Spends []Outpoint
+	Sent   int64
+}
+
+func pendingKey(txID []byte) []byte {
+	return append(append([]byte{}, pendingPrefix...), txID...)
+}
+
+// Reserve marks the outputs tx spends as taken until tx is mined or
+// PendingExpiry passes. Reservations whose outputs have been spent since are
+// dropped along the way.
+func (u UTXOSet) Reserve(tx *Transaction) error {
+	return u.Blockchain.Database.Update(func(txn *badger.Txn) error {
+		var stale [][]byte
+		err := forEachPending(txn, func(key []byte, pending PendingTx) error {
+			if time.Since(time.Unix(pending.Sent, 0)) > PendingExpiry || !unspent(txn, pending.Spends) {
+				stale = append(stale, key)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, key := range stale {
+			if err := txn.Delete(key); err != nil {
+				return err
+			}
+		}
+
+		pending := PendingTx{Sent: time.Now().Unix()}
+		for _, in := range tx.Inputs {
+			pending.Spends = append(pending.Spends, Outpoint{in.ID, in.OutIndex})
+		}
+		return txn.Set(pendingKey(tx.ID), utils.Serialize(pending))
+	})
+}
+
+// Release drops the reservation of a sent transaction that will never be
+// mined, so the outputs it spends can be picked again before it expires.
+func (u UTXOSet) Release(txID []byte) error {
+	return u.Blockchain.Database.Update(func(txn *badger.Txn) error {
+		_, err := txn.Get(pendingKey(txID))
+		if err == badger.ErrKeyNotFound {
+			return fmt.Errorf("%w: %x", ErrNotPending, txID)
+		}
+		if err != nil {
+			return err
+		}
+		return txn.Delete(pendingKey(txID))
+	})
+}
+
+// reservedOutpoints returns the outputs reserved by transactions that have
+// not expired yet.
+func reservedOutpoints(txn *badger.Txn) (map[string]bool, error) {
+	reserved := make(map[string]bool)
+	err := forEachPending(txn, func(_ []byte, pending PendingTx) error {
+		if time.Since(time.Unix(pending.Sent, 0)) > PendingExpiry {
+			return nil
+		}
+		for _, o := range pending.Spends {
+			reserved[o.String()] = true
+		}
+		return nil
+	})
+	return reserved, err
+}
+
+func forEachPending(txn *badger.Txn, fn func(key []byte, pending PendingTx) error) error {
+	it := txn.NewIterator(badger.DefaultIteratorOptions)
+	defer it.Close()
+	for it.Seek(pendingPrefix); it.ValidForPrefix(pendingPrefix); it.Next() {
+		v, err := it.Item().ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		pending, err := utils.Deserialize[PendingTx](v)
+		if err != nil {
+			return err
+		}
+		if err := fn(it.Item().KeyCopy(nil), pending); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func unspent(txn *badger.Txn, outpoints []Outpoint) bool {
+	for _, o := range outpoints {
+		outs, err := getUTXO(txn, o.TxID)
+		if err != nil {
+			return false
+		}
+		if _, ok := outs.Outputs[o.Index]; !ok {
+			return false
+		}
+	}
+	return true
+}
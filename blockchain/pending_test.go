@@ -0,0 +1,117 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/nthskyradiated/blockchain-in-golang/utils"
+	"github.com/nthskyradiated/blockchain-in-golang/wallet"
+)
+
+// newCoinChain returns a chain whose block 1 splits the genesis reward into
+// the given coins for w.
+func newCoinChain(t *testing.T, w *wallet.Wallet, values ...int) (*BlockChain, *Transaction) {
+	t.Helper()
+	chdirTemp(t)
+	chain, err := NewBlockChain(string(w.Address()), "pending")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { chain.Database.Close() })
+	UTXO := UTXOSet{chain}
+	if err := UTXO.Reindex(); err != nil {
+		t.Fatal(err)
+	}
+
+	var payments []Payment
+	for _, value := range values {
+		payments = append(payments, Payment{string(w.Address()), value})
+	}
+	split, err := NewBatchTransaction(w, payments, 0, 0, 0, &UTXO, LargestFirst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coinbase, err := CoinbaseTx(string(w.Address()), "", 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := chain.MineBlock(context.Background(), []*Transaction{coinbase, split}); err != nil {
+		t.Fatal(err)
+	}
+	return chain, split
+}
+
+func TestReservedOutpoints(t *testing.T) {
+	alice, bob := newTestWallet(t), newTestWallet(t)
+	chain, split := newCoinChain(t, alice, 10, 10)
+	UTXO := UTXOSet{chain}
+	pubKeyHash := wallet.PublicKeyHash(alice.PublicKey)
+	spendable := func() map[string]bool {
+		t.Helper()
+		coins, err := UTXO.SpendableCoins(pubKeyHash, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		outpoints := make(map[string]bool)
+		for _, coin := range coins {
+			outpoints[Outpoint{coin.TxID, coin.Index}.String()] = true
+		}
+		return outpoints
+	}
+
+	// ? Branch and bound spends exactly one of the two coins of 10
+	first, err := NewTransaction(alice, string(bob.Address()), 10, 0, &UTXO, BranchAndBound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reservedCoin := Outpoint{first.Inputs[0].ID, first.Inputs[0].OutIndex}
+	if len(first.Inputs) != 1 || !spendable()[reservedCoin.String()] {
+		t.Fatalf("first payment spends %d inputs, want one of the unspent coins", len(first.Inputs))
+	}
+	if err := UTXO.Reserve(first); err != nil {
+		t.Fatal(err)
+	}
+	if spendable()[reservedCoin.String()] {
+		t.Errorf("coin %s is still spendable after being reserved", reservedCoin)
+	}
+
+	second, err := NewTransaction(alice, string(bob.Address()), 10, 0, &UTXO, BranchAndBound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if in := second.Inputs[0]; len(second.Inputs) != 1 || !bytes.Equal(in.ID, split.ID) || in.OutIndex != 1-reservedCoin.Index {
+		t.Errorf("second payment spends %x:%d, want the other split coin", in.ID, in.OutIndex)
+	}
+
+	if err := UTXO.Release(first.ID); err != nil {
+		t.Fatal(err)
+	}
+	if !spendable()[reservedCoin.String()] {
+		t.Errorf("coin %s is not spendable after its reservation was released", reservedCoin)
+	}
+	if err := UTXO.Release(first.ID); !errors.Is(err, ErrNotPending) {
+		t.Errorf("releasing twice: err = %v, want %v", err, ErrNotPending)
+	}
+
+	// ? A reservation older than PendingExpiry no longer holds the coin back
+	expired := PendingTx{[]Outpoint{reservedCoin}, time.Now().Add(-PendingExpiry - time.Minute).Unix()}
+	err = chain.Database.Update(func(txn *badger.Txn) error {
+		return txn.Set(pendingKey(first.ID), utils.Serialize(expired))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !spendable()[reservedCoin.String()] {
+		t.Errorf("coin %s is not spendable with an expired reservation", reservedCoin)
+	}
+	if err := UTXO.Reserve(second); err != nil {
+		t.Fatal(err)
+	}
+	if err := UTXO.Release(first.ID); !errors.Is(err, ErrNotPending) {
+		t.Errorf("releasing an expired reservation after Reserve: err = %v, want %v", err, ErrNotPending)
+	}
+}
@@ -2,55 +2,143 @@ package blockchain
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/binary"
 	"fmt"
-	"log"
 	"math"
 	"math/big"
+	"runtime"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
-const Difficulty = 12
+// cancelCheckInterval is how many hashes a mining worker tries between
+// checks for cancellation.
+const cancelCheckInterval = 1 << 14
+
+// MiningWorkers is the number of goroutines used to mine a block.
+var MiningWorkers = runtime.NumCPU()
+
 type ProofOfWork struct {
 	Block  *Block
 	Target *big.Int
 }
 
 func NewProofOfWork(b *Block) *ProofOfWork {
-	target := big.NewInt(1)
-	target.Lsh(target, uint(256-Difficulty))
+	target := compactToBig(b.Bits)
 	pow := &ProofOfWork{b, target}
 	return pow
 }
 
-func (pow *ProofOfWork) PrepareData(nonce int) []byte {
-	data := bytes.Join([][]byte{
-		pow.Block.PrevHash,
-		pow.Block.HashTransactions(),
-		ToHex(int64(nonce)),
-		ToHex(int64(Difficulty)),
+func (pow *ProofOfWork) PrepareData(nonce uint32) []byte {
+	header := pow.Block.Header()
+	header.Nonce = nonce
+	return header.Serialize()
+}
 
-	}, []byte{})
-	return data
+// MiningStats describes the work done to find a block.
+type MiningStats struct {
+	Workers  int
+	Hashes   uint64
+	Rolls    int
+	Duration time.Duration
 }
 
-func (pow *ProofOfWork) Run() (int, []byte) {
-	var initHash = new(big.Int)
-	nonce := 0
-	var hash [32]byte
-	for nonce < math.MaxInt64 {
-		data := pow.PrepareData(nonce)
-		hash = sha256.Sum256(data)
-		fmt.Printf("\r%x", hash)
-		initHash.SetBytes(hash[:])
-		if initHash.Cmp(pow.Target) == -1 {
-			break
-		} else {
-			nonce++
+func (stats MiningStats) HashRate() float64 {
+	if stats.Duration <= 0 {
+		return 0
+	}
+	return float64(stats.Hashes) / stats.Duration.Seconds()
+}
+
+func (stats MiningStats) String() string {
+	return fmt.Sprintf("%d hashes in %s on %d workers (%.0f H/s, %d timestamp rolls)",
+		stats.Hashes, stats.Duration.Round(time.Millisecond), stats.Workers, stats.HashRate(), stats.Rolls)
+}
+
+// Mine splits the nonce space between workers goroutines and sets the block's
+// Nonce and Hash once one of them meets the target. When every nonce has been
+// tried the timestamp is rolled forward and the search starts over. Mining
+// stops with ctx's error as soon as ctx is cancelled.
+func (pow *ProofOfWork) Mine(ctx context.Context, workers int) (MiningStats, error) {
+	workers = max(workers, 1)
+	stats := MiningStats{Workers: workers}
+	start := time.Now()
+
+	for {
+		nonce, hash, hashes := pow.search(ctx, workers)
+		stats.Hashes += hashes
+		stats.Duration = time.Since(start)
+
+		if hash != nil {
+			pow.Block.Nonce = nonce
+			pow.Block.Hash = hash
+			return stats, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return stats, err
 		}
+
+		pow.Block.Timestamp = max(time.Now().Unix(), pow.Block.Timestamp+1)
+		stats.Rolls++
 	}
-	fmt.Println()
-	return nonce, hash[:]
+}
+
+// search scans the whole nonce space once for the current header, returning
+// a nil hash if no nonce meets the target or ctx was cancelled.
+func (pow *ProofOfWork) search(ctx context.Context, workers int) (uint32, []byte, uint64) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	header := pow.PrepareData(0)
+	target := make([]byte, 32)
+	pow.Target.FillBytes(target)
+	span := (uint64(math.MaxUint32) + 1) / uint64(workers)
+
+	var (
+		wg         sync.WaitGroup
+		once       sync.Once
+		total      atomic.Uint64
+		foundNonce uint32
+		foundHash  []byte
+	)
+	for i := range workers {
+		from, to := uint64(i)*span, uint64(i+1)*span
+		if i == workers-1 {
+			to = math.MaxUint32 + 1
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// ? Only the nonce changes between attempts, so each worker copies the
+			// serialized header once and overwrites its last four bytes
+			data := slices.Clone(header)
+			var hashes uint64
+			for nonce := from; nonce < to; nonce++ {
+				if hashes%cancelCheckInterval == 0 && ctx.Err() != nil {
+					break
+				}
+				binary.BigEndian.PutUint32(data[HeaderSize-4:], uint32(nonce))
+				hash := sha256.Sum256(data)
+				hashes++
+				if bytes.Compare(hash[:], target) < 0 {
+					once.Do(func() {
+						foundNonce, foundHash = uint32(nonce), hash[:]
+						cancel()
+					})
+					break
+				}
+			}
+			total.Add(hashes)
+		}()
+	}
+	wg.Wait()
+
+	return foundNonce, foundHash, total.Load()
 }
 
 func (pow *ProofOfWork) Validate() bool {
@@ -61,11 +149,12 @@ func (pow *ProofOfWork) Validate() bool {
 	return initHash.Cmp(pow.Target) == -1
 }
 
-func ToHex(num int64)[]byte {
-	buff := new(bytes.Buffer)
-	err := binary.Write(buff, binary.BigEndian, num)
-	if err != nil {
-		log.Panic(err)
-	}
-	return buff.Bytes()
-}
\ No newline at end of file
+// Work is the expected number of hashes needed to find a block at this target.
+func (pow *ProofOfWork) Work() *big.Int {
+	space := new(big.Int).Lsh(big.NewInt(1), 256)
+	return space.Div(space, new(big.Int).Add(pow.Target, big.NewInt(1)))
+}
+
+func ToHex(num int64) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(num))
+}
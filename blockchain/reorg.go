@@ -0,0 +1,98 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math/big"
+
+	"github.com/dgraph-io/badger"
+)
+
+var workPrefix = []byte("work-")
+
+func workKey(blockHash []byte) []byte {
+	return append(append([]byte{}, workPrefix...), blockHash...)
+}
+
+// chainWork returns the total proof-of-work of the chain ending at blockHash.
+// Blocks stored before their chain work was recorded get it recomputed from
+// the nearest ancestor that has it.
+func chainWork(txn *badger.Txn, blockHash []byte) (*big.Int, error) {
+	var pending []*Block
+	work := new(big.Int)
+
+	for hash := blockHash; len(hash) > 0; {
+		if item, err := txn.Get(workKey(hash)); err == nil {
+			v, err := item.ValueCopy(nil)
+			if err != nil {
+				return nil, err
+			}
+			work.SetBytes(v)
+			break
+		}
+		block, err := getBlock(txn, hash)
+		if err != nil {
+			return nil, err
+		}
+		pending = append(pending, block)
+		hash = block.PrevHash
+	}
+
+	for _, block := range pending {
+		work.Add(work, NewProofOfWork(block).Work())
+	}
+	return work, nil
+}
+
+// reorganize switches the UTXO set from the branch ending at oldTip to the one
+// ending at newTip. Blocks of the old branch are disconnected back to the fork
+// point, then the new branch is validated and connected block by block. The
+// transactions of disconnected blocks that are not part of the new branch are
+// returned so they can go back to the memory pool.
+func reorganize(txn *badger.Txn, oldTip, newTip *Block) ([]*Transaction, error) {
+	var detach, attach []*Block
+
+	for !bytes.Equal(oldTip.Hash, newTip.Hash) {
+		var err error
+		if oldTip.Height >= newTip.Height {
+			detach = append(detach, oldTip)
+			oldTip, err = getBlock(txn, oldTip.PrevHash)
+		} else {
+			attach = append(attach, newTip)
+			newTip, err = getBlock(txn, newTip.PrevHash)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, block := range detach {
+		if err := disconnectBlock(txn, block); err != nil {
+			return nil, err
+		}
+	}
+
+	included := make(map[string]bool)
+	for i := len(attach) - 1; i >= 0; i-- {
+		block := attach[i]
+		if err := checkBlockTransactions(txn, block); err != nil {
+			return nil, err
+		}
+		if err := connectBlock(txn, block); err != nil {
+			return nil, err
+		}
+		for _, tx := range block.Transactions {
+			included[hex.EncodeToString(tx.ID)] = true
+		}
+	}
+
+	var orphaned []*Transaction
+	for _, block := range detach {
+		for _, tx := range block.Transactions {
+			if !tx.IsCoinbase() && !included[hex.EncodeToString(tx.ID)] {
+				orphaned = append(orphaned, tx)
+			}
+		}
+	}
+	return orphaned, nil
+}
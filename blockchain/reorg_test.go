@@ -0,0 +1,171 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"reflect"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+	"github.com/nthskyradiated/blockchain-in-golang/wallet"
+)
+
+// utxoSet reads the whole UTXO set, keyed by hex transaction ID like
+// FindUTXOutputs.
+func utxoSet(t *testing.T, chain *BlockChain) map[string]TxOutputs {
+	t.Helper()
+	set := make(map[string]TxOutputs)
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Seek(utxoPrefix); it.ValidForPrefix(utxoPrefix); it.Next() {
+			txID := bytes.TrimPrefix(it.Item().KeyCopy(nil), utxoPrefix)
+			outs, err := getUTXO(txn, txID)
+			if err != nil {
+				return err
+			}
+			set[hex.EncodeToString(txID)] = outs
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return set
+}
+
+func newTestWallet(t *testing.T) *wallet.Wallet {
+	t.Helper()
+	w, err := wallet.CreateWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+// sideBlock builds a block on parent as another miner would, outside the
+// chain's tip.
+func sideBlock(t *testing.T, parent *Block, to string, txs ...*Transaction) *Block {
+	t.Helper()
+	coinbase, err := CoinbaseTx(to, "", parent.Height+1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txs = append([]*Transaction{coinbase}, txs...)
+	block, err := CreateBlock(context.Background(), txs, parent.Hash, parent.Height+1, InitialBits, parent.Timestamp+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+func TestReorganize(t *testing.T) {
+	chdirTemp(t)
+	alice, bob := newTestWallet(t), newTestWallet(t)
+	chain, err := NewBlockChain(string(alice.Address()), "reorg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Database.Close()
+	UTXO := UTXOSet{chain}
+	if err := UTXO.Reindex(); err != nil {
+		t.Fatal(err)
+	}
+	genesisSet := utxoSet(t, chain)
+	genesisBlock, err := chain.GetBlock(chain.LastHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	genesis := &genesisBlock
+	selector, err := CoinSelectorByName(DefaultStrategy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mine := func(tx *Transaction) *Block {
+		t.Helper()
+		height, err := chain.GetBestHeight()
+		if err != nil {
+			t.Fatal(err)
+		}
+		coinbase, err := CoinbaseTx(string(alice.Address()), "", height+1, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block, err := chain.MineBlock(context.Background(), []*Transaction{coinbase, tx})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return block
+	}
+	pay := func(amount int) *Transaction {
+		t.Helper()
+		tx, err := NewTransaction(alice, string(bob.Address()), amount, 0, &UTXO, selector)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tx
+	}
+
+	// ? The main branch pays bob twice, the second time out of the first payment's change
+	first := pay(5)
+	mine(first)
+	second := pay(3)
+	tip := mine(second)
+
+	// ? The side branch confirms only the first payment and needs a third block to have more work
+	side := sideBlock(t, genesis, string(bob.Address()), first)
+	for i := 0; i < 3; i++ {
+		orphaned, err := chain.AddBlock(side)
+		if err != nil {
+			t.Fatalf("side block %d: %v", side.Height, err)
+		}
+		if i < 2 {
+			if !bytes.Equal(chain.LastHash, tip.Hash) || orphaned != nil {
+				t.Fatalf("side block %d took the tip from a branch with as much work", side.Height)
+			}
+			side = sideBlock(t, side, string(bob.Address()))
+			continue
+		}
+		if !bytes.Equal(chain.LastHash, side.Hash) {
+			t.Fatalf("tip = %x, want the side branch %x", chain.LastHash, side.Hash)
+		}
+		if len(orphaned) != 1 || !bytes.Equal(orphaned[0].ID, second.ID) {
+			t.Errorf("orphaned %d transactions, want only the second payment %x", len(orphaned), second.ID)
+		}
+	}
+
+	set := utxoSet(t, chain)
+	if _, ok := set[hex.EncodeToString(second.ID)]; ok {
+		t.Errorf("the second payment's outputs are still unspent after its block was disconnected")
+	}
+	if outs, ok := set[hex.EncodeToString(first.ID)]; !ok || outs.Height != 1 {
+		t.Errorf("first payment outputs = %+v, %t, want them confirmed in side block 1", outs, ok)
+	}
+	want, err := chain.FindUTXOutputs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(set, want) {
+		t.Errorf("UTXO set after the reorganization = %+v, want %+v", set, want)
+	}
+
+	// ? Disconnecting every block back to genesis has to leave the set as it started
+	err = chain.Database.Update(func(txn *badger.Txn) error {
+		orphaned, err := reorganize(txn, side, genesis)
+		if err != nil {
+			return err
+		}
+		if len(orphaned) != 1 || !bytes.Equal(orphaned[0].ID, first.ID) {
+			t.Errorf("orphaned %d transactions, want only the first payment %x", len(orphaned), first.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if set := utxoSet(t, chain); !reflect.DeepEqual(set, genesisSet) {
+		t.Errorf("UTXO set back at genesis = %+v, want %+v", set, genesisSet)
+	}
+}
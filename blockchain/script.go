@@ -0,0 +1,580 @@
+package blockchain
+
+// Scripts are a small stack language in the spirit of Bitcoin script. An
+// input's unlocking script and the locking script of the output it spends run
+// one after the other on the same stack, and the spend is valid when the top
+// of the stack ends up true. Unlocking scripts may only push data.
+//
+// Outputs paying to a script hash (see P2SHScript) carry the real script, the
+// redeem script, as the last push of the unlocking script. Once the hash
+// matches, the redeem script runs on what the unlocking script left below it.
+//
+// Numbers on the stack are little-endian with the sign in the top bit of the
+// last byte, and zero is the empty string.
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+
+	"github.com/nthskyradiated/blockchain-in-golang/wallet"
+)
+
+const (
+	Op0                   byte = 0x00
+	OpPushData1           byte = 0x4c
+	OpPushData2           byte = 0x4d
+	Op1                   byte = 0x51
+	Op16                  byte = 0x60
+	OpIf                  byte = 0x63
+	OpNotIf               byte = 0x64
+	OpElse                byte = 0x67
+	OpEndIf               byte = 0x68
+	OpVerify              byte = 0x69
+	OpReturn              byte = 0x6a
+	OpDrop                byte = 0x75
+	OpDup                 byte = 0x76
+	OpSize                byte = 0x82
+	OpEqual               byte = 0x87
+	OpEqualVerify         byte = 0x88
+	OpSHA256              byte = 0xa8
+	OpHash160             byte = 0xa9
+	OpCheckSig            byte = 0xac
+	OpCheckSigVerify      byte = 0xad
+	OpCheckMultiSig       byte = 0xae
+	OpCheckMultiSigVerify byte = 0xaf
+	OpCheckLockTimeVerify byte = 0xb1
+	OpCheckSequenceVerify byte = 0xb2
+)
+
+var opNames = map[byte]string{
+	Op0: "OP_0", OpPushData1: "OP_PUSHDATA1", OpPushData2: "OP_PUSHDATA2",
+	OpIf: "OP_IF", OpNotIf: "OP_NOTIF", OpElse: "OP_ELSE", OpEndIf: "OP_ENDIF",
+	OpVerify: "OP_VERIFY", OpReturn: "OP_RETURN", OpDrop: "OP_DROP", OpDup: "OP_DUP",
+	OpSize: "OP_SIZE", OpEqual: "OP_EQUAL", OpEqualVerify: "OP_EQUALVERIFY",
+	OpSHA256: "OP_SHA256", OpHash160: "OP_HASH160",
+	OpCheckSig: "OP_CHECKSIG", OpCheckSigVerify: "OP_CHECKSIGVERIFY",
+	OpCheckMultiSig: "OP_CHECKMULTISIG", OpCheckMultiSigVerify: "OP_CHECKMULTISIGVERIFY",
+	OpCheckLockTimeVerify: "OP_CHECKLOCKTIMEVERIFY", OpCheckSequenceVerify: "OP_CHECKSEQUENCEVERIFY",
+}
+
+const (
+	MaxScriptSize    = 10000
+	maxScriptElement = 520
+	maxStackSize     = 1000
+	MaxMultisigKeys  = 16
+
+	// LockTimeThreshold splits timelocks into block heights below it and
+	// Unix timestamps from it on.
+	LockTimeThreshold = 500000000
+)
+
+var (
+	ErrMalformedScript = errors.New("script is malformed")
+	ErrScriptFailed    = errors.New("unlocking script does not satisfy the locking script")
+	ErrTimelocked      = errors.New("output is still timelocked")
+)
+
+// BlockContext is the block a transaction is checked for. Timelocks compare
+// against its height and the median time past of its parent.
+type BlockContext struct {
+	Height int
+	Time   int64
+}
+
+// ScriptBuilder appends opcodes and data pushes to a script.
+type ScriptBuilder []byte
+
+func (b ScriptBuilder) Op(ops ...byte) ScriptBuilder {
+	return append(b, ops...)
+}
+
+// Data pushes data with the shortest push opcode that fits it.
+func (b ScriptBuilder) Data(data []byte) ScriptBuilder {
+	switch {
+	case len(data) < int(OpPushData1):
+		b = append(b, byte(len(data)))
+	case len(data) <= 0xff:
+		b = append(b, OpPushData1, byte(len(data)))
+	default:
+		b = append(b, OpPushData2)
+		b = binary.LittleEndian.AppendUint16(b, uint16(len(data)))
+	}
+	return append(b, data...)
+}
+
+// Int pushes n, using the small integer opcodes where they fit.
+func (b ScriptBuilder) Int(n int64) ScriptBuilder {
+	switch {
+	case n == 0:
+		return append(b, Op0)
+	case n >= 1 && n <= 16:
+		return append(b, Op1+byte(n-1))
+	}
+	return b.Data(encodeNum(n))
+}
+
+type instruction struct {
+	op   byte
+	data []byte
+}
+
+func parseScript(script []byte) ([]instruction, error) {
+	if len(script) > MaxScriptSize {
+		return nil, ErrMalformedScript
+	}
+	var instrs []instruction
+	for i := 0; i < len(script); {
+		op := script[i]
+		i++
+		n := -1
+		switch {
+		case op < OpPushData1:
+			n = int(op)
+		case op == OpPushData1 && i+1 <= len(script):
+			n = int(script[i])
+			i++
+		case op == OpPushData2 && i+2 <= len(script):
+			n = int(binary.LittleEndian.Uint16(script[i:]))
+			i += 2
+		case op == OpPushData1 || op == OpPushData2:
+			return nil, ErrMalformedScript
+		}
+		if n < 0 {
+			instrs = append(instrs, instruction{op, nil})
+			continue
+		}
+		if i+n > len(script) || n > maxScriptElement {
+			return nil, ErrMalformedScript
+		}
+		instrs = append(instrs, instruction{op, script[i : i+n]})
+		i += n
+	}
+	return instrs, nil
+}
+
+func isPush(op byte) bool {
+	return op <= OpPushData2 || (op >= Op1 && op <= Op16)
+}
+
+// ScriptPushes returns what a script made of pushes only puts on the stack,
+// or nil if it does anything else.
+func ScriptPushes(script []byte) [][]byte {
+	instrs, err := parseScript(script)
+	if err != nil {
+		return nil
+	}
+	var pushes [][]byte
+	for _, ins := range instrs {
+		switch {
+		case ins.op <= OpPushData2:
+			pushes = append(pushes, ins.data)
+		case ins.op >= Op1 && ins.op <= Op16:
+			pushes = append(pushes, encodeNum(int64(ins.op-Op1+1)))
+		default:
+			return nil
+		}
+	}
+	return pushes
+}
+
+// DisassembleScript writes a script out as opcode names and hex data.
+func DisassembleScript(script []byte) string {
+	instrs, err := parseScript(script)
+	if err != nil {
+		return fmt.Sprintf("[malformed %x]", script)
+	}
+	var words []string
+	for _, ins := range instrs {
+		switch {
+		case ins.op > Op0 && ins.op <= OpPushData2:
+			words = append(words, fmt.Sprintf("%x", ins.data))
+		case ins.op >= Op1 && ins.op <= Op16:
+			words = append(words, fmt.Sprintf("OP_%d", ins.op-Op1+1))
+		case opNames[ins.op] != "":
+			words = append(words, opNames[ins.op])
+		default:
+			words = append(words, fmt.Sprintf("OP_UNKNOWN_%#x", ins.op))
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+func encodeNum(n int64) []byte {
+	if n == 0 {
+		return nil
+	}
+	abs := uint64(n)
+	if n < 0 {
+		abs = uint64(-n)
+	}
+	var b []byte
+	for ; abs > 0; abs >>= 8 {
+		b = append(b, byte(abs))
+	}
+	switch {
+	case b[len(b)-1]&0x80 != 0 && n < 0:
+		b = append(b, 0x80)
+	case b[len(b)-1]&0x80 != 0:
+		b = append(b, 0)
+	case n < 0:
+		b[len(b)-1] |= 0x80
+	}
+	return b
+}
+
+// ? Five bytes is enough for every timestamp a timelock can hold
+func decodeNum(b []byte) (int64, error) {
+	if len(b) > 5 {
+		return 0, ErrMalformedScript
+	}
+	var n int64
+	for i, c := range b {
+		n |= int64(c) << (8 * i)
+	}
+	if len(b) > 0 && b[len(b)-1]&0x80 != 0 {
+		n &^= int64(0x80) << (8 * (len(b) - 1))
+		n = -n
+	}
+	return n, nil
+}
+
+func truthy(b []byte) bool {
+	for i, c := range b {
+		if c != 0 && !(i == len(b)-1 && c == 0x80) {
+			return true
+		}
+	}
+	return false
+}
+
+// engine runs the scripts of one input.
+type engine struct {
+	tx         *Transaction
+	index      int
+	lock       []byte
+	prevOuts   []TxOutput
+	ctx        BlockContext
+	prevHeight int
+
+	stack     [][]byte
+	sigHash   []byte
+	sigFailed bool
+}
+
+func (e *engine) push(b []byte) error {
+	if len(e.stack) >= maxStackSize {
+		return ErrMalformedScript
+	}
+	e.stack = append(e.stack, b)
+	return nil
+}
+
+func (e *engine) pop() ([]byte, error) {
+	if len(e.stack) == 0 {
+		return nil, ErrScriptFailed
+	}
+	top := e.stack[len(e.stack)-1]
+	e.stack = e.stack[:len(e.stack)-1]
+	return top, nil
+}
+
+func (e *engine) peek() ([]byte, error) {
+	if len(e.stack) == 0 {
+		return nil, ErrScriptFailed
+	}
+	return e.stack[len(e.stack)-1], nil
+}
+
+func (e *engine) popNum() (int64, error) {
+	b, err := e.pop()
+	if err != nil {
+		return 0, err
+	}
+	return decodeNum(b)
+}
+
+func pushBool(v bool) []byte {
+	if v {
+		return []byte{1}
+	}
+	return nil
+}
+
+// checkSig reports whether sig is pubKey's signature of the input.
+func (e *engine) checkSig(sig, pubKey []byte) bool {
+	if len(sig) != 64 {
+		return false
+	}
+	x, y := elliptic.Unmarshal(elliptic.P256(), pubKey)
+	if x == nil {
+		return false
+	}
+	if e.sigHash == nil {
+		e.sigHash = e.tx.SigHash(e.index, e.lock, e.prevOuts)
+	}
+	r := new(big.Int).SetBytes(sig[:32])
+	s := new(big.Int).SetBytes(sig[32:])
+	return ecdsa.Verify(&ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, e.sigHash, r, s)
+}
+
+// checkMultiSig pops n public keys and m signatures and reports whether the
+// signatures belong to m of the keys, in the same order as the keys.
+func (e *engine) checkMultiSig() (bool, error) {
+	n, err := e.popNum()
+	if err != nil || n < 1 || n > MaxMultisigKeys {
+		return false, ErrMalformedScript
+	}
+	keys := make([][]byte, n)
+	for i := n - 1; i >= 0; i-- {
+		if keys[i], err = e.pop(); err != nil {
+			return false, err
+		}
+	}
+	m, err := e.popNum()
+	if err != nil || m < 1 || m > n {
+		return false, ErrMalformedScript
+	}
+	sigs := make([][]byte, m)
+	for i := m - 1; i >= 0; i-- {
+		if sigs[i], err = e.pop(); err != nil {
+			return false, err
+		}
+	}
+
+	k := 0
+	for _, sig := range sigs {
+		for k < len(keys) && !e.checkSig(sig, keys[k]) {
+			k++
+		}
+		if k == len(keys) {
+			return false, nil
+		}
+		k++
+	}
+	return true, nil
+}
+
+func (e *engine) checkLockTime() error {
+	top, err := e.peek()
+	if err != nil {
+		return err
+	}
+	lockTime, err := decodeNum(top)
+	if err != nil || lockTime < 0 {
+		return ErrMalformedScript
+	}
+	if lockTime < LockTimeThreshold && int64(e.ctx.Height) >= lockTime {
+		return nil
+	}
+	if lockTime >= LockTimeThreshold && e.ctx.Time >= lockTime {
+		return nil
+	}
+	return ErrTimelocked
+}
+
+func (e *engine) checkSequence() error {
+	top, err := e.peek()
+	if err != nil {
+		return err
+	}
+	blocks, err := decodeNum(top)
+	if err != nil || blocks < 0 {
+		return ErrMalformedScript
+	}
+	if int64(e.ctx.Height-e.prevHeight) < blocks {
+		return ErrTimelocked
+	}
+	return nil
+}
+
+func (e *engine) run(script []byte) error {
+	instrs, err := parseScript(script)
+	if err != nil {
+		return err
+	}
+
+	// ? conds holds one entry per open OP_IF, and only a branch whose entries are all true runs
+	var conds []bool
+	for _, ins := range instrs {
+		executing := !slices.Contains(conds, false)
+		if !executing && (ins.op < OpIf || ins.op > OpEndIf) {
+			continue
+		}
+
+		switch {
+		case ins.op <= OpPushData2:
+			err = e.push(ins.data)
+		case ins.op >= Op1 && ins.op <= Op16:
+			err = e.push(encodeNum(int64(ins.op - Op1 + 1)))
+
+		case ins.op == OpIf || ins.op == OpNotIf:
+			cond := false
+			if executing {
+				var v []byte
+				if v, err = e.pop(); err != nil {
+					return err
+				}
+				cond = truthy(v) == (ins.op == OpIf)
+			}
+			conds = append(conds, cond)
+		case ins.op == OpElse:
+			if len(conds) == 0 {
+				return ErrMalformedScript
+			}
+			conds[len(conds)-1] = !conds[len(conds)-1]
+		case ins.op == OpEndIf:
+			if len(conds) == 0 {
+				return ErrMalformedScript
+			}
+			conds = conds[:len(conds)-1]
+
+		case ins.op == OpVerify:
+			var v []byte
+			if v, err = e.pop(); err == nil && !truthy(v) {
+				err = ErrScriptFailed
+			}
+		case ins.op == OpReturn:
+			err = ErrScriptFailed
+		case ins.op == OpDrop:
+			_, err = e.pop()
+		case ins.op == OpDup:
+			var v []byte
+			if v, err = e.peek(); err == nil {
+				err = e.push(v)
+			}
+		case ins.op == OpSize:
+			var v []byte
+			if v, err = e.peek(); err == nil {
+				err = e.push(encodeNum(int64(len(v))))
+			}
+
+		case ins.op == OpEqual || ins.op == OpEqualVerify:
+			var a, b []byte
+			if b, err = e.pop(); err != nil {
+				return err
+			}
+			if a, err = e.pop(); err != nil {
+				return err
+			}
+			if ins.op == OpEqual {
+				err = e.push(pushBool(bytes.Equal(a, b)))
+			} else if !bytes.Equal(a, b) {
+				err = ErrScriptFailed
+			}
+
+		case ins.op == OpSHA256:
+			var v []byte
+			if v, err = e.pop(); err == nil {
+				hash := sha256.Sum256(v)
+				err = e.push(hash[:])
+			}
+		case ins.op == OpHash160:
+			var v []byte
+			if v, err = e.pop(); err == nil {
+				err = e.push(wallet.PublicKeyHash(v))
+			}
+
+		case ins.op == OpCheckSig || ins.op == OpCheckSigVerify:
+			var sig, pubKey []byte
+			if pubKey, err = e.pop(); err != nil {
+				return err
+			}
+			if sig, err = e.pop(); err != nil {
+				return err
+			}
+			ok := e.checkSig(sig, pubKey)
+			e.sigFailed = e.sigFailed || !ok
+			if ins.op == OpCheckSig {
+				err = e.push(pushBool(ok))
+			} else if !ok {
+				err = ErrInvalidSignature
+			}
+		case ins.op == OpCheckMultiSig || ins.op == OpCheckMultiSigVerify:
+			var ok bool
+			if ok, err = e.checkMultiSig(); err != nil {
+				return err
+			}
+			e.sigFailed = e.sigFailed || !ok
+			if ins.op == OpCheckMultiSig {
+				err = e.push(pushBool(ok))
+			} else if !ok {
+				err = ErrInvalidSignature
+			}
+
+		case ins.op == OpCheckLockTimeVerify:
+			err = e.checkLockTime()
+		case ins.op == OpCheckSequenceVerify:
+			err = e.checkSequence()
+
+		default:
+			err = ErrMalformedScript
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if len(conds) > 0 {
+		return ErrMalformedScript
+	}
+	return nil
+}
+
+// executeScripts runs unlock and then the locking script of prevOuts[index],
+// the output input index of tx spends. prevOuts holds the outputs spent by
+// every input, as signatures commit to all of them.
+func executeScripts(tx *Transaction, index int, unlock []byte, prevOuts []TxOutput, prevHeight int, ctx BlockContext) error {
+	lock := prevOuts[index].ScriptPubKey
+	instrs, err := parseScript(unlock)
+	if err != nil {
+		return err
+	}
+	for _, ins := range instrs {
+		if !isPush(ins.op) {
+			return ErrMalformedScript
+		}
+	}
+
+	e := &engine{tx: tx, index: index, lock: lock, prevOuts: prevOuts, ctx: ctx, prevHeight: prevHeight}
+	if err := e.run(unlock); err != nil {
+		return err
+	}
+	saved := slices.Clone(e.stack)
+	if err := e.run(lock); err != nil {
+		return err
+	}
+	if err := e.result(); err != nil || ExtractScriptHash(lock) == nil {
+		return err
+	}
+
+	// ? The hash matched, so the last push is the redeem script and signatures commit to it
+	if len(saved) == 0 {
+		return ErrScriptFailed
+	}
+	redeem := saved[len(saved)-1]
+	e.stack = saved[:len(saved)-1]
+	e.lock = redeem
+	e.sigHash = nil
+	if err := e.run(redeem); err != nil {
+		return err
+	}
+	return e.result()
+}
+
+// result checks that the scripts left a true value on top of the stack.
+func (e *engine) result() error {
+	if len(e.stack) == 0 || !truthy(e.stack[len(e.stack)-1]) {
+		if e.sigFailed {
+			return ErrInvalidSignature
+		}
+		return ErrScriptFailed
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"github.com/nthskyradiated/blockchain-in-golang/wallet"
+)
+
+type testKey struct {
+	priv   ecdsa.PrivateKey
+	pubKey []byte
+}
+
+func newTestKey(t *testing.T) testKey {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testKey{*priv, elliptic.Marshal(priv.Curve, priv.X, priv.Y)}
+}
+
+func (k testKey) hash() []byte {
+	return wallet.PublicKeyHash(k.pubKey)
+}
+
+// spend is input 0 of a transaction spending an output locked with lock.
+type spend struct {
+	tx       *Transaction
+	prevOuts []TxOutput
+}
+
+func newSpend(lock []byte) spend {
+	tx := &Transaction{
+		Inputs:  []TxInput{{bytes.Repeat([]byte{0x33}, 32), 0, nil, 0}},
+		Outputs: []TxOutput{{9, P2PKHScript(bytes.Repeat([]byte{0x44}, 20))}},
+	}
+	return spend{tx, []TxOutput{{10, lock}}}
+}
+
+// sign signs input 0 against script, the redeem script when the output pays
+// to a script hash.
+func (s spend) sign(t *testing.T, k testKey, script []byte) []byte {
+	t.Helper()
+	sig, err := SignHash(k.priv, s.tx.SigHash(0, script, s.prevOuts))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sig
+}
+
+func (s spend) run(unlock []byte, prevHeight int, ctx BlockContext) error {
+	return executeScripts(s.tx, 0, unlock, s.prevOuts, prevHeight, ctx)
+}
+
+func TestExecuteScripts(t *testing.T) {
+	alice, bob, carol := newTestKey(t), newTestKey(t), newTestKey(t)
+	p2pkh := P2PKHScript(alice.hash())
+	multisig, err := MultisigScript(2, [][]byte{alice.pubKey, bob.pubKey, carol.pubKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p2sh := P2SHScript(wallet.PublicKeyHash(multisig))
+	cltv := ScriptBuilder{}.Int(100).Op(OpCheckLockTimeVerify, OpDrop).Op(p2pkh...)
+	cltvTime := ScriptBuilder{}.Int(LockTimeThreshold+100).Op(OpCheckLockTimeVerify, OpDrop).Op(p2pkh...)
+	csv := ScriptBuilder{}.Int(3).Op(OpCheckSequenceVerify, OpDrop).Op(p2pkh...)
+	// ? Bare multisig scripts are not standard, but the engine has to reject bad counts in them anyway
+	zeroOfOne := ScriptBuilder{}.Int(0).Data(alice.pubKey).Int(1).Op(OpCheckMultiSig)
+	twoOfOne := ScriptBuilder{}.Int(2).Data(alice.pubKey).Int(1).Op(OpCheckMultiSig)
+
+	ctx := BlockContext{Height: 100, Time: LockTimeThreshold + 100}
+	tests := []struct {
+		name       string
+		lock       []byte
+		unlock     func(t *testing.T, s spend) []byte
+		prevHeight int
+		ctx        BlockContext
+		want       error
+	}{
+		{"p2pkh", p2pkh, func(t *testing.T, s spend) []byte {
+			return P2PKHUnlockScript(s.sign(t, alice, p2pkh), alice.pubKey)
+		}, 0, ctx, nil},
+		{"p2pkh wrong key", p2pkh, func(t *testing.T, s spend) []byte {
+			return P2PKHUnlockScript(s.sign(t, bob, p2pkh), bob.pubKey)
+		}, 0, ctx, ErrScriptFailed},
+		{"p2pkh bad signature", p2pkh, func(t *testing.T, s spend) []byte {
+			return P2PKHUnlockScript(s.sign(t, bob, p2pkh), alice.pubKey)
+		}, 0, ctx, ErrInvalidSignature},
+		{"p2pkh signature over another script", p2pkh, func(t *testing.T, s spend) []byte {
+			return P2PKHUnlockScript(s.sign(t, alice, p2sh), alice.pubKey)
+		}, 0, ctx, ErrInvalidSignature},
+		{"p2pkh no signature", p2pkh, func(t *testing.T, s spend) []byte {
+			return ScriptBuilder{}.Data(alice.pubKey)
+		}, 0, ctx, ErrScriptFailed},
+
+		{"2-of-3", p2sh, func(t *testing.T, s spend) []byte {
+			return ScriptBuilder{}.Data(s.sign(t, alice, multisig)).Data(s.sign(t, carol, multisig)).Data(multisig)
+		}, 0, ctx, nil},
+		{"2-of-3 out of key order", p2sh, func(t *testing.T, s spend) []byte {
+			return ScriptBuilder{}.Data(s.sign(t, carol, multisig)).Data(s.sign(t, alice, multisig)).Data(multisig)
+		}, 0, ctx, ErrInvalidSignature},
+		{"2-of-3 same key twice", p2sh, func(t *testing.T, s spend) []byte {
+			sig := s.sign(t, bob, multisig)
+			return ScriptBuilder{}.Data(sig).Data(sig).Data(multisig)
+		}, 0, ctx, ErrInvalidSignature},
+		{"2-of-3 one signature", p2sh, func(t *testing.T, s spend) []byte {
+			return ScriptBuilder{}.Data(s.sign(t, bob, multisig)).Data(multisig)
+		}, 0, ctx, ErrScriptFailed},
+		{"2-of-3 wrong redeem script", p2sh, func(t *testing.T, s spend) []byte {
+			other, _ := MultisigScript(1, [][]byte{alice.pubKey})
+			return ScriptBuilder{}.Data(s.sign(t, alice, other)).Data(other)
+		}, 0, ctx, ErrScriptFailed},
+		{"0-of-1", zeroOfOne, func(t *testing.T, s spend) []byte {
+			return nil
+		}, 0, ctx, ErrMalformedScript},
+		{"2-of-1", twoOfOne, func(t *testing.T, s spend) []byte {
+			sig := s.sign(t, alice, twoOfOne)
+			return ScriptBuilder{}.Data(sig).Data(sig)
+		}, 0, ctx, ErrMalformedScript},
+
+		{"cltv height reached", cltv, func(t *testing.T, s spend) []byte {
+			return P2PKHUnlockScript(s.sign(t, alice, cltv), alice.pubKey)
+		}, 0, ctx, nil},
+		{"cltv height not reached", cltv, func(t *testing.T, s spend) []byte {
+			return P2PKHUnlockScript(s.sign(t, alice, cltv), alice.pubKey)
+		}, 0, BlockContext{Height: 99, Time: ctx.Time}, ErrTimelocked},
+		{"cltv time reached", cltvTime, func(t *testing.T, s spend) []byte {
+			return P2PKHUnlockScript(s.sign(t, alice, cltvTime), alice.pubKey)
+		}, 0, ctx, nil},
+		{"cltv time not reached", cltvTime, func(t *testing.T, s spend) []byte {
+			return P2PKHUnlockScript(s.sign(t, alice, cltvTime), alice.pubKey)
+		}, 0, BlockContext{Height: ctx.Height, Time: ctx.Time - 1}, ErrTimelocked},
+		{"csv deep enough", csv, func(t *testing.T, s spend) []byte {
+			return P2PKHUnlockScript(s.sign(t, alice, csv), alice.pubKey)
+		}, 97, ctx, nil},
+		{"csv too shallow", csv, func(t *testing.T, s spend) []byte {
+			return P2PKHUnlockScript(s.sign(t, alice, csv), alice.pubKey)
+		}, 98, ctx, ErrTimelocked},
+
+		{"truncated push in lock", []byte{0x05, 0x01, 0x02}, func(t *testing.T, s spend) []byte {
+			return nil
+		}, 0, ctx, ErrMalformedScript},
+		{"truncated OP_PUSHDATA1", p2pkh, func(t *testing.T, s spend) []byte {
+			return []byte{OpPushData1}
+		}, 0, ctx, ErrMalformedScript},
+		{"truncated OP_PUSHDATA2", p2pkh, func(t *testing.T, s spend) []byte {
+			return []byte{OpPushData2, 0x01}
+		}, 0, ctx, ErrMalformedScript},
+		{"truncated signature push", p2pkh, func(t *testing.T, s spend) []byte {
+			unlock := P2PKHUnlockScript(s.sign(t, alice, p2pkh), alice.pubKey)
+			return unlock[:len(unlock)-1]
+		}, 0, ctx, ErrMalformedScript},
+		{"opcode in unlock", p2pkh, func(t *testing.T, s spend) []byte {
+			return ScriptBuilder{}.Data(s.sign(t, alice, p2pkh)).Data(alice.pubKey).Op(OpDup)
+		}, 0, ctx, ErrMalformedScript},
+		{"unbalanced OP_IF", []byte{Op1, OpIf, Op1}, func(t *testing.T, s spend) []byte {
+			return nil
+		}, 0, ctx, ErrMalformedScript},
+		{"OP_ELSE without OP_IF", []byte{OpElse, Op1}, func(t *testing.T, s spend) []byte {
+			return nil
+		}, 0, ctx, ErrMalformedScript},
+		{"unknown opcode", []byte{0xff}, func(t *testing.T, s spend) []byte {
+			return []byte{Op1}
+		}, 0, ctx, ErrMalformedScript},
+		{"OP_RETURN", []byte{OpReturn}, func(t *testing.T, s spend) []byte {
+			return []byte{Op1}
+		}, 0, ctx, ErrScriptFailed},
+		{"empty scripts", nil, func(t *testing.T, s spend) []byte {
+			return nil
+		}, 0, ctx, ErrScriptFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newSpend(tt.lock)
+			err := s.run(tt.unlock(t, s), tt.prevHeight, tt.ctx)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestScriptNumbers(t *testing.T) {
+	for _, n := range []int64{0, 1, -1, 16, 127, 128, -128, 255, 256, 32767, -32768, LockTimeThreshold, 1<<32 - 1} {
+		got, err := decodeNum(encodeNum(n))
+		if err != nil || got != n {
+			t.Errorf("decodeNum(encodeNum(%d)) = %d, %v", n, got, err)
+		}
+	}
+	if _, err := decodeNum(make([]byte, 6)); !errors.Is(err, ErrMalformedScript) {
+		t.Errorf("decoding a 6 byte number: err = %v, want %v", err, ErrMalformedScript)
+	}
+}
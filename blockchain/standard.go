@@ -0,0 +1,172 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"errors"
+	"fmt"
+
+	"github.com/nthskyradiated/blockchain-in-golang/wallet"
+)
+
+// MaxDataSize is the most data a data output may carry.
+const MaxDataSize = 80
+
+// maxDataScriptSize is OP_RETURN OP_PUSHDATA1 <len> followed by MaxDataSize
+// bytes.
+const maxDataScriptSize = MaxDataSize + 3
+
+var (
+	ErrBadMultisig  = errors.New("multisig needs 1 <= required <= keys valid public keys")
+	ErrDataTooLarge = errors.New("data output carries too much data")
+)
+
+// P2PKHScript is the standard locking script paying to a pubkey hash:
+//
+//	OP_DUP OP_HASH160 <pubKeyHash> OP_EQUALVERIFY OP_CHECKSIG
+func P2PKHScript(pubKeyHash []byte) []byte {
+	return ScriptBuilder{}.Op(OpDup, OpHash160).Data(pubKeyHash).Op(OpEqualVerify, OpCheckSig)
+}
+
+// P2PKHUnlockScript spends a P2PKH output with a signature and the public key
+// hashing to the output's pubkey hash.
+func P2PKHUnlockScript(sig, pubKey []byte) []byte {
+	return ScriptBuilder{}.Data(sig).Data(pubKey)
+}
+
+// ExtractPubKeyHash returns the pubkey hash a P2PKH script pays to, or nil
+// for any other script.
+func ExtractPubKeyHash(script []byte) []byte {
+	if len(script) != 25 {
+		return nil
+	}
+	pubKeyHash := script[3:23]
+	if !bytes.Equal(script, P2PKHScript(pubKeyHash)) {
+		return nil
+	}
+	return pubKeyHash
+}
+
+// P2SHScript is the locking script paying to the hash of a redeem script:
+//
+//	OP_HASH160 <scriptHash> OP_EQUAL
+func P2SHScript(scriptHash []byte) []byte {
+	return ScriptBuilder{}.Op(OpHash160).Data(scriptHash).Op(OpEqual)
+}
+
+// ExtractScriptHash returns the script hash a P2SH script pays to, or nil for
+// any other script.
+func ExtractScriptHash(script []byte) []byte {
+	if len(script) != 23 {
+		return nil
+	}
+	scriptHash := script[2:22]
+	if !bytes.Equal(script, P2SHScript(scriptHash)) {
+		return nil
+	}
+	return scriptHash
+}
+
+// MultisigScript is the redeem script of an M-of-N multisig address:
+//
+//	<required> <pubKey>... <len(pubKeys)> OP_CHECKMULTISIG
+func MultisigScript(required int, pubKeys [][]byte) ([]byte, error) {
+	if required < 1 || required > len(pubKeys) || len(pubKeys) > MaxMultisigKeys {
+		return nil, fmt.Errorf("%w: %d of %d", ErrBadMultisig, required, len(pubKeys))
+	}
+	script := ScriptBuilder{}.Int(int64(required))
+	for _, pubKey := range pubKeys {
+		if x, _ := elliptic.Unmarshal(elliptic.P256(), pubKey); x == nil {
+			return nil, fmt.Errorf("%w: %x is not a public key", ErrBadMultisig, pubKey)
+		}
+		script = script.Data(pubKey)
+	}
+	script = script.Int(int64(len(pubKeys))).Op(OpCheckMultiSig)
+	// ? The redeem script is pushed when spending, so it has to fit in one stack element
+	if len(script) > maxScriptElement {
+		return nil, fmt.Errorf("%w: %d keys make the script too long", ErrBadMultisig, len(pubKeys))
+	}
+	return script, nil
+}
+
+// ParseMultisigScript returns how many signatures a multisig redeem script
+// requires and its public keys in order, or ok false for any other script.
+func ParseMultisigScript(script []byte) (required int, pubKeys [][]byte, ok bool) {
+	instrs, err := parseScript(script)
+	if err != nil || len(instrs) < 4 || instrs[len(instrs)-1].op != OpCheckMultiSig {
+		return 0, nil, false
+	}
+	smallInt := func(op byte) int {
+		if op < Op1 || op > Op16 {
+			return 0
+		}
+		return int(op-Op1) + 1
+	}
+	required = smallInt(instrs[0].op)
+	n := smallInt(instrs[len(instrs)-2].op)
+	if required == 0 || n != len(instrs)-3 || required > n {
+		return 0, nil, false
+	}
+	for _, ins := range instrs[1 : len(instrs)-2] {
+		if ins.op == Op0 || ins.op > OpPushData2 {
+			return 0, nil, false
+		}
+		pubKeys = append(pubKeys, ins.data)
+	}
+	return required, pubKeys, true
+}
+
+// LockingScript returns the script paying to address: P2PKH for a wallet
+// address, P2SH for a multisig address.
+func LockingScript(address string) ([]byte, error) {
+	version, hash, err := wallet.DecodeAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	if version == wallet.MultisigVersion {
+		return P2SHScript(hash), nil
+	}
+	return P2PKHScript(hash), nil
+}
+
+// ScriptAddress returns the address a P2PKH or P2SH script pays to, or "" for
+// any other script.
+func ScriptAddress(script []byte) string {
+	if pubKeyHash := ExtractPubKeyHash(script); pubKeyHash != nil {
+		return wallet.PubKeyHashAddress(pubKeyHash)
+	}
+	if scriptHash := ExtractScriptHash(script); scriptHash != nil {
+		return wallet.ScriptHashAddress(scriptHash)
+	}
+	return ""
+}
+
+// DataScript is the locking script of an output that carries data and can
+// never be spent:
+//
+//	OP_RETURN <data>
+func DataScript(data []byte) ([]byte, error) {
+	if len(data) > MaxDataSize {
+		return nil, fmt.Errorf("%w: %d bytes, at most %d", ErrDataTooLarge, len(data), MaxDataSize)
+	}
+	return ScriptBuilder{}.Op(OpReturn).Data(data), nil
+}
+
+// IsUnspendable reports whether no unlocking script can satisfy script, which
+// keeps its output out of the UTXO set.
+func IsUnspendable(script []byte) bool {
+	return len(script) > 0 && script[0] == OpReturn
+}
+
+// ExtractData returns the data a data output carries, or nil for any other
+// script.
+func ExtractData(script []byte) []byte {
+	if !IsUnspendable(script) {
+		return nil
+	}
+	pushes := ScriptPushes(script[1:])
+	if len(pushes) != 1 {
+		return nil
+	}
+	return pushes[0]
+}
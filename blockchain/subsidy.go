@@ -0,0 +1,44 @@
+package blockchain
+
+import "errors"
+
+const (
+	// InitialSubsidy is what a coinbase may mint before the first halving.
+	// The subsidy halves every HalvingInterval blocks, and no block mints
+	// anything once MaxSupply coins have been issued.
+	InitialSubsidy  = 100
+	HalvingInterval = 1000
+	MaxSupply       = 190000
+
+	// CoinbaseMaturity is how many blocks must be mined on top of a coinbase
+	// before its outputs can be spent, so a reorganization cannot undo the
+	// reward after it has been passed on.
+	CoinbaseMaturity = 10
+)
+
+// ErrSupplyExceeded means the UTXO set holds more than MaxSupply, which only
+// a database that skipped validation can.
+var ErrSupplyExceeded = errors.New("unspent outputs are worth more than the maximum supply")
+
+// scheduledIssuance returns how many coins the halving schedule alone issues
+// in the blocks below height.
+func scheduledIssuance(height int) int {
+	issued := 0
+	for epoch, reward := 0, InitialSubsidy; reward > 0 && height > 0; epoch, reward = epoch+1, reward>>1 {
+		blocks := min(height, HalvingInterval)
+		issued += blocks * reward
+		height -= blocks
+	}
+	return issued
+}
+
+// Issued returns the number of coins minted by the blocks below height.
+func Issued(height int) int {
+	return min(scheduledIssuance(height), MaxSupply)
+}
+
+// BlockSubsidy is the most the coinbase of the block at height may mint on
+// top of the block's fees.
+func BlockSubsidy(height int) int {
+	return Issued(height+1) - Issued(height)
+}
@@ -0,0 +1,78 @@
+package blockchain
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/dgraph-io/badger"
+)
+
+// MaxBlockSize is the largest a serialized block may be.
+const MaxBlockSize = 100000
+
+// blockOverhead is the room a block needs besides its non-coinbase
+// transactions: the hash, header, transaction count and a coinbase.
+const blockOverhead = 4 + 32 + HeaderSize + 4 + 512
+
+type candidate struct {
+	tx   *Transaction
+	fee  int
+	size int
+}
+
+// SelectTransactions picks the transactions for a new block from candidates,
+// highest fee rate first, until the block would grow past MaxBlockSize.
+// Candidates that do not spend unspent outputs with valid signatures are
+// left out, as are ones spending an output a better paying candidate already
+// spends. It returns the picked transactions and the fees they pay.
+func (bc *BlockChain) SelectTransactions(candidates []*Transaction) ([]*Transaction, int, error) {
+	var pool []candidate
+	err := bc.Database.View(func(txn *badger.Txn) error {
+		ctx, err := nextBlockContext(txn)
+		if err != nil {
+			return err
+		}
+		for _, tx := range candidates {
+			if tx.IsCoinbase() {
+				continue
+			}
+			fee, err := checkTxInputs(txn, tx, ctx, make(map[string]bool), make(map[string]TxOutputs))
+			if err != nil {
+				continue
+			}
+			pool = append(pool, candidate{tx, fee, len(tx.Serialize())})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// ? Compare fee/size rates by cross multiplying to stay in integers
+	slices.SortStableFunc(pool, func(a, b candidate) int {
+		return b.fee*a.size - a.fee*b.size
+	})
+
+	var selected []*Transaction
+	fees, size := 0, blockOverhead
+	spent := make(map[string]bool)
+
+Candidates:
+	for _, c := range pool {
+		if size+4+c.size > MaxBlockSize {
+			continue
+		}
+		for _, in := range c.tx.Inputs {
+			if spent[fmt.Sprintf("%x:%d", in.ID, in.OutIndex)] {
+				continue Candidates
+			}
+		}
+		for _, in := range c.tx.Inputs {
+			spent[fmt.Sprintf("%x:%d", in.ID, in.OutIndex)] = true
+		}
+		selected = append(selected, c.tx)
+		fees += c.fee
+		size += 4 + c.size
+	}
+	return selected, fees, nil
+}
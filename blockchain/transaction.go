@@ -6,22 +6,31 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
-	"github.com/nthskyradiated/blockchain-in-golang/utils"
 	"github.com/nthskyradiated/blockchain-in-golang/wallet"
-	"log"
-	"math/big"
 	"strings"
+	"time"
 )
 
+var (
+	ErrInsufficientFunds = errors.New("not enough funds")
+	ErrNoPayments        = errors.New("transaction has no recipients")
+)
+
+// Transaction moves the value of the outputs its inputs spend to new outputs.
+// A non-zero LockTime keeps it out of blocks until that block height, or
+// until that Unix time has passed the median time past if it is at least
+// LockTimeThreshold.
 type Transaction struct {
-	ID      []byte
-	Inputs  []TxInput
-	Outputs []TxOutput
+	ID       []byte
+	Inputs   []TxInput
+	Outputs  []TxOutput
+	LockTime uint32
 }
 
 func (tx Transaction) Serialize() []byte {
-	return utils.Serialize(tx)
+	return tx.appendTo(nil)
 }
 
 func (tx *Transaction) Hash() []byte {
@@ -36,166 +45,259 @@ func (tx *Transaction) IsCoinbase() bool {
 	return len(tx.Inputs) == 1 && len(tx.Inputs[0].ID) == 0 && tx.Inputs[0].OutIndex == -1
 }
 
-// func (tx *Transaction) Sign(privKey ecdsa.PrivateKey, prevTXs map[string]Transaction) {
-// 	if tx.IsCoinbase() {
-// 		return
-// 	}
-
-// 	for _, input := range tx.Inputs {
-// 		if prevTXs[hex.EncodeToString(input.ID)].ID == nil {
-// 			log.Panicf("Previous transaction not found: %x", input.ID)
-// 		}
-// 	}
-// 	txCopy := tx.TrimmedCopy()
-
-// 	for i, input := range txCopy.Inputs {
-// 		prevTX := prevTXs[hex.EncodeToString(input.ID)]
-// 		txCopy.Inputs[i].Sig = nil // Clear the signature for signing
-// 		txCopy.Inputs[i].PubKey = prevTX.Outputs[input.OutIndex].ScriptPubKey
-// 		txCopy.ID = txCopy.Hash()
-
-// 		dataToSign := fmt.Sprintf("%x\n", txCopy)
-
-// 		r, s, err := ecdsa.Sign(rand.Reader, &privKey, []byte(dataToSign))
+// IsFinal reports whether the lock time of tx allows it into the block
+// described by ctx.
+func (tx *Transaction) IsFinal(ctx BlockContext) bool {
+	switch {
+	case tx.LockTime == 0:
+		return true
+	case tx.LockTime < LockTimeThreshold:
+		return int64(ctx.Height) >= int64(tx.LockTime)
+	}
+	return ctx.Time >= int64(tx.LockTime)
+}
 
-// 		log.Printf("Signing Transaction: ID=%x", tx.ID)
-// 		log.Printf("Public Key: %x", privKey.PublicKey.X.Bytes())
-// 		log.Printf("Signature: R=%x, S=%x", r.Bytes(), s.Bytes())
-// 		utils.HandleError(err)
-// 		signature := append(r.Bytes(), s.Bytes()...)
-// 		tx.Inputs[i].Sig = signature
-// 		txCopy.Inputs[i].PubKey = nil
-// 	}
-// }
+// LockTimeString describes a lock time as the height or time it waits for.
+func LockTimeString(lockTime uint32) string {
+	if lockTime < LockTimeThreshold {
+		return fmt.Sprintf("height %d", lockTime)
+	}
+	return time.Unix(int64(lockTime), 0).UTC().Format(time.RFC3339)
+}
 
-func (tx *Transaction) Sign(privKey ecdsa.PrivateKey, prevTXs map[string]Transaction) {
+func (tx *Transaction) Sign(privKey ecdsa.PrivateKey, prevTXs map[string]Transaction) error {
 	if tx.IsCoinbase() {
-		return
+		return nil
 	}
 
 	for _, input := range tx.Inputs {
-		if prevTXs[hex.EncodeToString(input.ID)].ID == nil {
-			log.Panicf("Previous transaction not found: %x", input.ID)
+		prevTX := prevTXs[hex.EncodeToString(input.ID)]
+		if prevTX.ID == nil {
+			return fmt.Errorf("previous transaction %x: %w", input.ID, ErrTxNotFound)
+		}
+		if input.OutIndex < 0 || input.OutIndex >= len(prevTX.Outputs) {
+			return fmt.Errorf("previous transaction %x output %d: %w", input.ID, input.OutIndex, ErrMissingInput)
 		}
 	}
 
-	txCopy := tx.TrimmedCopy()
-
-	for i, input := range txCopy.Inputs {
-		prevTX := prevTXs[hex.EncodeToString(input.ID)]
-		txCopy.Inputs[i].Sig = nil // Clear the signature for signing
-		txCopy.Inputs[i].PubKey = prevTX.Outputs[input.OutIndex].ScriptPubKey
-		txCopy.ID = txCopy.Hash()
-		txCopy.Inputs[i].PubKey = nil
-
-		r, s, err := ecdsa.Sign(rand.Reader, &privKey, txCopy.ID)
-		utils.HandleError(err)
+	var prevOuts []TxOutput
+	for _, input := range tx.Inputs {
+		prevOuts = append(prevOuts, prevTXs[hex.EncodeToString(input.ID)].Outputs[input.OutIndex])
+	}
 
-		// Combine R and S into a single signature
-		signature := append(r.Bytes(), s.Bytes()...)
+	pubKey := elliptic.Marshal(privKey.Curve, privKey.PublicKey.X, privKey.PublicKey.Y)
+	for i := range tx.Inputs {
+		signature, err := SignHash(privKey, tx.SigHash(i, prevOuts[i].ScriptPubKey, prevOuts))
+		if err != nil {
+			return err
+		}
 
 		// Populate the transaction input fields
-		tx.Inputs[i].Sig = signature
-		tx.Inputs[i].PubKey = append(privKey.PublicKey.X.Bytes(), privKey.PublicKey.Y.Bytes()...)
+		tx.Inputs[i].ScriptSig = P2PKHUnlockScript(signature, pubKey)
+	}
+	return nil
+}
+
+// SigHash is what the signatures of input index sign: the transaction without
+// unlocking scripts, except for the locking script being spent in place of
+// the input's own, and then prevOuts, the outputs every input spends. As the
+// values and scripts of those outputs are signed too, a signer shown forged
+// ones, as a PST could do, signs a transaction the chain rejects.
+func (tx *Transaction) SigHash(index int, lockingScript []byte, prevOuts []TxOutput) []byte {
+	txCopy := tx.TrimmedCopy()
+	txCopy.ID = nil
+	txCopy.Inputs[index].ScriptSig = lockingScript
+	data := txCopy.Serialize()
+	for _, out := range prevOuts {
+		data = out.appendTo(data)
+	}
+	hash := sha256.Sum256(data)
+	return hash[:]
+}
 
-		log.Printf("Signing Transaction: ID=%x", tx.ID)
-		log.Printf("Public Key: %x", tx.Inputs[i].PubKey)
-		log.Printf("Signature: R=%x, S=%x", r.Bytes(), s.Bytes())
+// SignHash signs hash with privKey, writing R and S as a fixed-width
+// signature.
+func SignHash(privKey ecdsa.PrivateKey, hash []byte) ([]byte, error) {
+	r, s, err := ecdsa.Sign(rand.Reader, &privKey, hash)
+	if err != nil {
+		return nil, err
 	}
+	return append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...), nil
 }
 
 func (tx *Transaction) TrimmedCopy() Transaction {
 	var outputs []TxOutput
 	var inputs []TxInput
 	for _, in := range tx.Inputs {
-		inputs = append(inputs, TxInput{in.ID, in.OutIndex, nil, nil})
+		inputs = append(inputs, TxInput{in.ID, in.OutIndex, nil, in.Sequence})
 	}
 	for _, out := range tx.Outputs {
 		outputs = append(outputs, TxOutput{out.Value, out.ScriptPubKey})
 	}
-	txCopy := Transaction{tx.ID, inputs, outputs}
+	txCopy := Transaction{tx.ID, inputs, outputs, tx.LockTime}
 	return txCopy
 }
 
-func (tx *Transaction) Verify(prevTXs map[string]Transaction) bool {
+// Verify runs the scripts of every input against the output it spends,
+// prevOuts[i] being the output referenced by tx.Inputs[i], for a spend in the
+// block described by ctx.
+func (tx *Transaction) Verify(prevOuts []SpentOutput, ctx BlockContext) error {
 	if tx.IsCoinbase() {
-		return true
+		return nil
 	}
-
-	for _, input := range tx.Inputs {
-		if prevTXs[hex.EncodeToString(input.ID)].ID == nil {
-			log.Panicf("Previous transaction not found: %x", input.ID)
-		}
+	if len(prevOuts) != len(tx.Inputs) {
+		return ErrMissingInput
 	}
 
-	txCopy := tx.TrimmedCopy()
-	fmt.Println(txCopy)
-	curve := elliptic.P256()
+	outputs := make([]TxOutput, len(prevOuts))
+	for i, prevOut := range prevOuts {
+		outputs[i] = prevOut.Output
+	}
 	for i, input := range tx.Inputs {
-		prevTX := prevTXs[hex.EncodeToString(input.ID)]
-		txCopy.Inputs[i].Sig = nil // Clear the signature for verification
-		txCopy.Inputs[i].PubKey = prevTX.Outputs[input.OutIndex].ScriptPubKey
-		txCopy.ID = txCopy.Hash()
-		txCopy.Inputs[i].PubKey = nil
-		log.Printf("Transaction Hash: %x", txCopy.ID)
-		r := new(big.Int).SetBytes(input.Sig[:len(input.Sig)/2])
-		s := new(big.Int).SetBytes(input.Sig[len(input.Sig)/2:])
-		log.Printf("Signature Components: R=%x, S=%x", r, s)
-
-		x := new(big.Int).SetBytes(input.PubKey[:len(input.PubKey)/2])
-		y := new(big.Int).SetBytes(input.PubKey[len(input.PubKey)/2:])
-
-		rawPubKey := ecdsa.PublicKey{Curve: curve, X: x, Y: y}
-		log.Printf("Reconstructed Public Key: X=%x, Y=%x", x, y)
-		if !ecdsa.Verify(&rawPubKey, txCopy.ID, r, s) {
-			return false
+		if err := executeScripts(tx, i, input.ScriptSig, outputs, prevOuts[i].Height, ctx); err != nil {
+			return fmt.Errorf("input %d: %w", i, err)
 		}
 	}
-	return true
+	return nil
 }
-func NewTransaction(w *wallet.Wallet, to string, amount int, UTXO *UTXOSet) *Transaction {
-	var inputs []TxInput
+
+// Payment is one recipient of a transaction and the amount paid to it.
+type Payment struct {
+	Address string
+	Amount  int
+}
+
+// NewTransaction pays amount to the address to from w, leaving fee for the
+// miner. The fee is implicit: whatever the inputs hold beyond the outputs.
+// selector picks which of w's coins to spend.
+func NewTransaction(w *wallet.Wallet, to string, amount, fee int, UTXO *UTXOSet, selector CoinSelector) (*Transaction, error) {
+	return NewBatchTransaction(w, []Payment{{to, amount}}, fee, 0, 0, UTXO, selector)
+}
+
+// NewBatchTransaction pays every payment from w in a single transaction,
+// with one output per payment in order and the change last.
+func NewBatchTransaction(w *wallet.Wallet, payments []Payment, fee int, lockTime, sequence uint32, UTXO *UTXOSet, selector CoinSelector) (*Transaction, error) {
+	tx, err := NewUnsignedTransaction(string(w.Address()), payments, fee, lockTime, sequence, UTXO, selector)
+	if err != nil {
+		return nil, err
+	}
+	return signWithWallet(tx, w, UTXO)
+}
+
+// NewDataTransaction commits data to the chain in an unspendable output,
+// paying fee from w.
+func NewDataTransaction(w *wallet.Wallet, data []byte, fee int, UTXO *UTXOSet, selector CoinSelector) (*Transaction, error) {
+	script, err := DataScript(data)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := fundTransaction(string(w.Address()), []TxOutput{{0, script}}, 0, fee, 0, 0, UTXO, selector)
+	if err != nil {
+		return nil, err
+	}
+	return signWithWallet(tx, w, UTXO)
+}
+
+func signWithWallet(tx *Transaction, w *wallet.Wallet, UTXO *UTXOSet) (*Transaction, error) {
+	if err := UTXO.Blockchain.SignTransaction(tx, w.PrivateKey); err != nil {
+		return nil, err
+	}
+	// ? The ID commits to the signatures so blocks can check it against the contents
+	tx.ID = tx.Hash()
+	return tx, nil
+}
+
+// NewUnsignedTransaction builds the transaction paying payments from the
+// coins of the address from, without unlocking scripts. Its change goes back
+// to from.
+func NewUnsignedTransaction(from string, payments []Payment, fee int, lockTime, sequence uint32, UTXO *UTXOSet, selector CoinSelector) (*Transaction, error) {
 	var outputs []TxOutput
 
-	pubKeyHash := wallet.PublicKeyHash(w.PublicKey)
+	if len(payments) == 0 {
+		return nil, ErrNoPayments
+	}
+	amount := 0
+	for _, p := range payments {
+		if p.Amount <= 0 || p.Amount > MaxSupply {
+			return nil, fmt.Errorf("%w: paying %d to %s", ErrBadOutputValue, p.Amount, p.Address)
+		}
+		out, err := NewTXOutput(p.Amount, p.Address)
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, *out)
+		if amount, err = addValue(amount, p.Amount); err != nil {
+			return nil, err
+		}
+	}
+	return fundTransaction(from, outputs, amount, fee, lockTime, sequence, UTXO, selector)
+}
 
-	acc, validOutputs := UTXO.FindSpendableOutputs(pubKeyHash, amount)
+// fundTransaction adds inputs spending the coins of the address from to pay
+// for outputs worth amount plus fee, and change back to from if there is any.
+// Every input gets sequence as its relative lock, so only coins at least that
+// many blocks deep are spent.
+func fundTransaction(from string, outputs []TxOutput, amount, fee int, lockTime, sequence uint32, UTXO *UTXOSet, selector CoinSelector) (*Transaction, error) {
+	var inputs []TxInput
 
-	if acc < amount {
-		log.Panicf("Not enough funds: %d < %d", acc, amount)
+	if _, err := addValue(amount, fee); err != nil {
+		return nil, fmt.Errorf("fee %d: %w", fee, err)
+	}
+	pubKeyHash, err := wallet.PubKeyHashFromAddress(from)
+	if err != nil {
+		return nil, err
+	}
+
+	// ? Even a transaction paying nothing needs an input, or nothing would make its ID unique
+	acc, validOutputs, err := UTXO.FindSpendableOutputs(pubKeyHash, max(amount+fee, 1), sequence, selector)
+	if err != nil {
+		return nil, err
+	}
+	if acc < max(amount+fee, 1) {
+		return nil, fmt.Errorf("%w: have %d, need %d", ErrInsufficientFunds, acc, amount+fee)
 	}
 
 	for txid, outs := range validOutputs {
 		txID, err := hex.DecodeString(txid)
-		utils.HandleError(err)
+		if err != nil {
+			return nil, err
+		}
 		for _, out := range outs {
-			input := TxInput{txID, out, nil, w.PublicKey}
+			input := TxInput{txID, out, nil, sequence}
 			inputs = append(inputs, input)
 		}
 	}
-	from := string(w.Address())
-	outputs = append(outputs, *NewTXOutput(amount, to))
-	if acc > amount {
-		outputs = append(outputs, *NewTXOutput(acc-amount, from))
+	if acc > amount+fee {
+		change, err := NewTXOutput(acc-amount-fee, from)
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, *change)
 	}
-	tx := Transaction{nil, inputs, outputs}
+	tx := Transaction{nil, inputs, outputs, lockTime}
 	tx.ID = tx.Hash()
-	UTXO.Blockchain.SignTransaction(&tx, w.PrivateKey)
-	return &tx
+	return &tx, nil
 }
-func CoinbaseTx(to, data string) *Transaction {
+
+// CoinbaseTx pays the subsidy of the block at height plus the fees of the
+// block's other transactions to the address to.
+func CoinbaseTx(to, data string, height, fees int) (*Transaction, error) {
 	if data == "" {
 		randData := make([]byte, 24)
-		_, err := rand.Read(randData)
-		utils.HandleError(err)
+		if _, err := rand.Read(randData); err != nil {
+			return nil, err
+		}
 		data = fmt.Sprintf("%x", randData)
 	}
-	txin := TxInput{[]byte{}, -1, nil, []byte(data)}
-	txout := NewTXOutput(100, to)
+	txin := TxInput{[]byte{}, -1, []byte(data), 0}
+	txout, err := NewTXOutput(BlockSubsidy(height)+fees, to)
+	if err != nil {
+		return nil, err
+	}
 
-	tx := Transaction{nil, []TxInput{txin}, []TxOutput{*txout}}
+	tx := Transaction{nil, []TxInput{txin}, []TxOutput{*txout}, 0}
 	tx.ID = tx.Hash()
-	return &tx
+	return &tx, nil
 }
 
 func (tx Transaction) String() string {
@@ -206,14 +308,23 @@ func (tx Transaction) String() string {
 		lines = append(lines, fmt.Sprintf("     Input %d:", i))
 		lines = append(lines, fmt.Sprintf("       TXID:     %x", input.ID))
 		lines = append(lines, fmt.Sprintf("       Out:       %d", input.OutIndex))
-		lines = append(lines, fmt.Sprintf("       Signature: %x", input.Sig))
-		lines = append(lines, fmt.Sprintf("       PubKey:    %x", input.PubKey))
+		if tx.IsCoinbase() {
+			lines = append(lines, fmt.Sprintf("       Data:      %x", input.ScriptSig))
+		} else {
+			lines = append(lines, fmt.Sprintf("       Script:    %s", DisassembleScript(input.ScriptSig)))
+		}
+		if input.Sequence > 0 {
+			lines = append(lines, fmt.Sprintf("       Sequence:  %d blocks", input.Sequence))
+		}
 	}
 
 	for i, output := range tx.Outputs {
 		lines = append(lines, fmt.Sprintf("     Output %d:", i))
 		lines = append(lines, fmt.Sprintf("       Value:  %d", output.Value))
-		lines = append(lines, fmt.Sprintf("       Script: %x", output.ScriptPubKey))
+		lines = append(lines, fmt.Sprintf("       Script: %s", DisassembleScript(output.ScriptPubKey)))
+	}
+	if tx.LockTime > 0 {
+		lines = append(lines, fmt.Sprintf("     Locked until %s", LockTimeString(tx.LockTime)))
 	}
 
 	return strings.Join(lines, "\n")
@@ -6,41 +6,111 @@ import (
 	"github.com/nthskyradiated/blockchain-in-golang/wallet"
 )
 
+// TxInput spends output OutIndex of transaction ID. ScriptSig is the
+// unlocking script, or arbitrary data in a coinbase. A non-zero Sequence is a
+// relative lock: the input is only valid in blocks at least Sequence blocks
+// after the one that created the output.
 type TxInput struct {
-	ID       []byte
-	OutIndex int
-	Sig      []byte
-	PubKey   []byte
+	ID        []byte
+	OutIndex  int
+	ScriptSig []byte
+	Sequence  uint32
 }
 
+// TxOutput holds Value until someone satisfies its locking script.
 type TxOutput struct {
 	Value        int
 	ScriptPubKey []byte
 }
 
+// TxOutputs are the unspent outputs of one transaction, along with the
+// height of the block that created them and whether it was a coinbase.
 type TxOutputs struct{
-	Outputs []TxOutput
+	Outputs  map[int]TxOutput
+	Height   int
+	Coinbase bool
 }
 
+// PubKey returns the public key a P2PKH unlocking script reveals, or nil.
+func (in *TxInput) PubKey() []byte {
+	pushes := ScriptPushes(in.ScriptSig)
+	if len(pushes) != 2 || len(pushes[1]) != 65 || pushes[1][0] != 4 {
+		return nil
+	}
+	return pushes[1]
+}
+
+// RedeemScript returns the multisig or HTLC redeem script a P2SH unlocking
+// script reveals, or nil.
+func (in *TxInput) RedeemScript() []byte {
+	pushes := ScriptPushes(in.ScriptSig)
+	if len(pushes) == 0 {
+		return nil
+	}
+	redeem := pushes[len(pushes)-1]
+	if _, _, ok := ParseMultisigScript(redeem); ok {
+		return redeem
+	}
+	if _, err := ParseHTLC(redeem); err == nil {
+		return redeem
+	}
+	return nil
+}
+
+// UsesKey reports whether the input spends an output locked to pubKeyHash,
+// either with its key or with its redeem script.
 func (in *TxInput) UsesKey(pubKeyHash []byte) bool {
-	lockingHash := wallet.PublicKeyHash(in.PubKey)
-	return bytes.Equal(lockingHash, pubKeyHash)
+	if pubKey := in.PubKey(); pubKey != nil && bytes.Equal(wallet.PublicKeyHash(pubKey), pubKeyHash) {
+		return true
+	}
+	redeem := in.RedeemScript()
+	return redeem != nil && bytes.Equal(wallet.PublicKeyHash(redeem), pubKeyHash)
 }
 
-func (out *TxOutput) Lock(address []byte) {
-	pubKeyHash := utils.Base58Decode([]byte(address))
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
-	out.ScriptPubKey = pubKeyHash
+func (out *TxOutput) Lock(address []byte) error {
+	script, err := LockingScript(string(address))
+	if err != nil {
+		return err
+	}
+	out.ScriptPubKey = script
+	return nil
 }
 
+// IsLockedWithKey reports whether the output pays to pubKeyHash, which is a
+// script hash for multisig addresses.
 func (out *TxOutput) IsLockedWithKey(pubKeyHash []byte) bool {
-	return bytes.Equal(out.ScriptPubKey, pubKeyHash)
+	lockingHash := ExtractPubKeyHash(out.ScriptPubKey)
+	if lockingHash == nil {
+		lockingHash = ExtractScriptHash(out.ScriptPubKey)
+	}
+	return lockingHash != nil && bytes.Equal(lockingHash, pubKeyHash)
 }
 
-func NewTXOutput(value int, address string) *TxOutput {
+func NewTXOutput(value int, address string) (*TxOutput, error) {
 	out := &TxOutput{value, nil}
-	out.Lock([]byte(address))
-	return out
+	if err := out.Lock([]byte(address)); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// newTxOutputs returns the outputs of tx that go into the UTXO set once it is
+// in a block at height, which are all but the unspendable ones.
+func newTxOutputs(tx *Transaction, height int) TxOutputs {
+	outs := TxOutputs{make(map[int]TxOutput), height, tx.IsCoinbase()}
+	for outIdx, out := range tx.Outputs {
+		if !IsUnspendable(out.ScriptPubKey) {
+			outs.Outputs[outIdx] = out
+		}
+	}
+	return outs
+}
+
+// Mature reports whether the outputs may be spent in a block at height.
+// Coinbase outputs have to wait CoinbaseMaturity blocks, except the genesis
+// coinbase that every chain starts out spending.
+func (outs TxOutputs) Mature(height int) bool {
+	return !outs.Coinbase || outs.Height == 0 || height-outs.Height >= CoinbaseMaturity
 }
 
 func (outs TxOutputs) Serialize() []byte {
@@ -0,0 +1,219 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+
+	"github.com/dgraph-io/badger"
+	"github.com/nthskyradiated/blockchain-in-golang/utils"
+	"github.com/nthskyradiated/blockchain-in-golang/wallet"
+)
+
+// The transaction index maps every main chain txid to the block holding it.
+// The address index has one key per transaction and pubkey hash it pays or
+// spends from, ordered by height so a prefix scan reads an address history
+// oldest first. Script hashes and the hashes of the data in data outputs are
+// indexed the same way:
+//
+//	txidx-<txid>                              -> TxLocation
+//	addr-<len><pubKeyHash><height><txid>      -> block hash
+//
+// Both follow the UTXO set: connectBlock adds a block's entries and
+// disconnectBlock removes them.
+var (
+	txIndexPrefix   = []byte("txidx-")
+	addrIndexPrefix = []byte("addr-")
+)
+
+var ErrTxNotFound = errors.New("transaction not found")
+
+type TxLocation struct {
+	BlockHash []byte
+	Position  int
+}
+
+type HistoryEntry struct {
+	TxID      []byte
+	BlockHash []byte
+	Height    int
+}
+
+func txIndexKey(txID []byte) []byte {
+	return append(append([]byte{}, txIndexPrefix...), txID...)
+}
+
+func addrIndexKeyPrefix(pubKeyHash []byte) []byte {
+	return appendBytes(append([]byte{}, addrIndexPrefix...), pubKeyHash)
+}
+
+func addrIndexKey(pubKeyHash []byte, height int, txID []byte) []byte {
+	key := binary.BigEndian.AppendUint64(addrIndexKeyPrefix(pubKeyHash), uint64(height))
+	return append(key, txID...)
+}
+
+// touchedKeys returns the pubkey hashes a transaction pays to or spends from,
+// along with the script hashes and data hashes of its outputs and inputs.
+func touchedKeys(tx *Transaction) [][]byte {
+	var keys [][]byte
+	add := func(key []byte) {
+		for _, k := range keys {
+			if bytes.Equal(k, key) {
+				return
+			}
+		}
+		keys = append(keys, key)
+	}
+
+	if !tx.IsCoinbase() {
+		for _, in := range tx.Inputs {
+			if pubKey := in.PubKey(); pubKey != nil {
+				add(wallet.PublicKeyHash(pubKey))
+			}
+			if redeem := in.RedeemScript(); redeem != nil {
+				add(wallet.PublicKeyHash(redeem))
+			}
+		}
+	}
+	for _, out := range tx.Outputs {
+		if pubKeyHash := ExtractPubKeyHash(out.ScriptPubKey); pubKeyHash != nil {
+			add(pubKeyHash)
+		}
+		if scriptHash := ExtractScriptHash(out.ScriptPubKey); scriptHash != nil {
+			add(scriptHash)
+		}
+		// ? Data outputs are indexed under the hash of their data, so FindData can look them up
+		if data := ExtractData(out.ScriptPubKey); data != nil {
+			add(wallet.PublicKeyHash(data))
+		}
+	}
+	return keys
+}
+
+func indexBlock(txn *badger.Txn, block *Block) error {
+	for i, tx := range block.Transactions {
+		if err := txn.Set(txIndexKey(tx.ID), utils.Serialize(TxLocation{block.Hash, i})); err != nil {
+			return err
+		}
+		for _, key := range touchedKeys(tx) {
+			if err := txn.Set(addrIndexKey(key, block.Height, tx.ID), block.Hash); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func unindexBlock(txn *badger.Txn, block *Block) error {
+	for _, tx := range block.Transactions {
+		if err := txn.Delete(txIndexKey(tx.ID)); err != nil {
+			return err
+		}
+		for _, key := range touchedKeys(tx) {
+			if err := txn.Delete(addrIndexKey(key, block.Height, tx.ID)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// indexChain indexes every block from tip back to the genesis block and
+// returns the number of transactions indexed.
+func indexChain(txn *badger.Txn, tip []byte) (int, error) {
+	count := 0
+	for hash := tip; len(hash) > 0; {
+		block, err := getBlock(txn, hash)
+		if err != nil {
+			return 0, err
+		}
+		if err := indexBlock(txn, block); err != nil {
+			return 0, err
+		}
+		count += len(block.Transactions)
+		hash = block.PrevHash
+	}
+	return count, nil
+}
+
+func getTxLocation(txn *badger.Txn, txID []byte) (TxLocation, error) {
+	item, err := txn.Get(txIndexKey(txID))
+	if err != nil {
+		return TxLocation{}, err
+	}
+	v, err := item.ValueCopy(nil)
+	if err != nil {
+		return TxLocation{}, err
+	}
+	return utils.Deserialize[TxLocation](v)
+}
+
+// ReindexTransactions rebuilds the transaction and address indexes from the
+// main chain and returns the number of transactions indexed.
+func (bc *BlockChain) ReindexTransactions() (int, error) {
+	u := UTXOSet{bc}
+	if err := u.DeleteByPrefix(txIndexPrefix); err != nil {
+		return 0, err
+	}
+	if err := u.DeleteByPrefix(addrIndexPrefix); err != nil {
+		return 0, err
+	}
+
+	var count int
+	err := bc.Database.Update(func(txn *badger.Txn) error {
+		var err error
+		count, err = indexChain(txn, bc.LastHash)
+		return err
+	})
+	return count, err
+}
+
+// AddressHistory returns the main chain transactions paying to or spending
+// from pubKeyHash, oldest first.
+func (bc *BlockChain) AddressHistory(pubKeyHash []byte) ([]HistoryEntry, error) {
+	var history []HistoryEntry
+	prefix := addrIndexKeyPrefix(pubKeyHash)
+
+	err := bc.Database.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			item := it.Item()
+			key := item.KeyCopy(nil)[len(prefix):]
+			blockHash, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			history = append(history, HistoryEntry{
+				TxID:      key[8:],
+				BlockHash: blockHash,
+				Height:    int(binary.BigEndian.Uint64(key[:8])),
+			})
+		}
+		return nil
+	})
+	return history, err
+}
+
+// FindData returns the main chain transactions with a data output carrying
+// data, oldest first.
+func (bc *BlockChain) FindData(data []byte) ([]HistoryEntry, error) {
+	history, err := bc.AddressHistory(wallet.PublicKeyHash(data))
+	if err != nil {
+		return nil, err
+	}
+	var found []HistoryEntry
+	for _, entry := range history {
+		tx, err := bc.FindTransaction(entry.TxID)
+		if err != nil {
+			return nil, err
+		}
+		for _, out := range tx.Outputs {
+			if d := ExtractData(out.ScriptPubKey); d != nil && bytes.Equal(d, data) {
+				found = append(found, entry)
+				break
+			}
+		}
+	}
+	return found, nil
+}
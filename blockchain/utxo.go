@@ -3,82 +3,202 @@ package blockchain
 import (
 	"bytes"
 	"encoding/hex"
-	"log"
+	"fmt"
+	"maps"
+	"slices"
+
 	"github.com/dgraph-io/badger"
 	"github.com/nthskyradiated/blockchain-in-golang/utils"
 )
 
 var (
-	utxoPrefix   = []byte("utxo-")
+	utxoPrefix = []byte("utxo-")
+	undoPrefix = []byte("undo-")
 )
 
 type UTXOSet struct {
 	Blockchain *BlockChain
 }
 
-func (u UTXOSet) Reindex() {
+func (u UTXOSet) Reindex() error {
 	db := u.Blockchain.Database
-	u.DeleteByPrefix(utxoPrefix)
-	UTXO := u.Blockchain.FindUTXOutputs()
+	if err := u.DeleteByPrefix(utxoPrefix); err != nil {
+		return err
+	}
+	UTXO, err := u.Blockchain.FindUTXOutputs()
+	if err != nil {
+		return err
+	}
 
-	err := db.Update(func(txn *badger.Txn) error {
+	return db.Update(func(txn *badger.Txn) error {
 		for txID, outputs := range UTXO {
 			key, err := hex.DecodeString(txID)
 			if err != nil {
 				return err
 			}
-			key = append(utxoPrefix, key...)
-			err = txn.Set(key, outputs.Serialize())
-			utils.HandleError(err)
+			if err := txn.Set(utxoKey(key), outputs.Serialize()); err != nil {
+				return err
+			}
 		}
 		return nil
 	})
-	utils.HandleError(err)
 }
 
-func (u *UTXOSet) Update(block *Block) {
-	db := u.Blockchain.Database
-	err := db.Update(func(txn *badger.Txn) error {
-		for _, tx := range block.Transactions {
-			if !tx.IsCoinbase() {
-				for _, input := range tx.Inputs {
-				updatedOuts := TxOutputs{}
-				inID := append(utxoPrefix, input.ID...)
-					item, err := txn.Get(inID)
-				utils.HandleError(err)
-				v, err := item.ValueCopy(nil)
-				utils.HandleError(err)
-
-				outs := utils.Deserialize[TxOutputs](v)
-				for outIdx, out := range outs.Outputs {
-					if outIdx != input.OutIndex {
-						updatedOuts.Outputs = append(updatedOuts.Outputs, out)
-					}
+func (u *UTXOSet) Update(block *Block) error {
+	return u.Blockchain.Database.Update(func(txn *badger.Txn) error {
+		return connectBlock(txn, block)
+	})
+}
+
+// SpentOutput is an output removed from the UTXO set by a block, kept so the
+// block can be disconnected again during a reorganization.
+type SpentOutput struct {
+	TxID     []byte
+	Index    int
+	Output   TxOutput
+	Height   int
+	Coinbase bool
+}
+
+type BlockUndo struct {
+	Spent []SpentOutput
+}
+
+func (undo BlockUndo) Serialize() []byte {
+	return utils.Serialize(undo)
+}
+
+// nextHeight returns the height of the block that would extend the tip.
+func nextHeight(txn *badger.Txn) (int, error) {
+	lastHash, err := getLastHash(txn)
+	if err != nil {
+		return 0, err
+	}
+	tip, err := getBlock(txn, lastHash)
+	if err != nil {
+		return 0, err
+	}
+	return tip.Height + 1, nil
+}
+
+// nextBlockContext describes the block that would extend the tip.
+func nextBlockContext(txn *badger.Txn) (BlockContext, error) {
+	lastHash, err := getLastHash(txn)
+	if err != nil {
+		return BlockContext{}, err
+	}
+	tip, err := getBlock(txn, lastHash)
+	if err != nil {
+		return BlockContext{}, err
+	}
+	mtp, err := medianTimePast(txn, tip)
+	if err != nil {
+		return BlockContext{}, err
+	}
+	return BlockContext{tip.Height + 1, mtp}, nil
+}
+
+func utxoKey(txID []byte) []byte {
+	return append(append([]byte{}, utxoPrefix...), txID...)
+}
+
+func undoKey(blockHash []byte) []byte {
+	return append(append([]byte{}, undoPrefix...), blockHash...)
+}
+
+func getUTXO(txn *badger.Txn, txID []byte) (TxOutputs, error) {
+	item, err := txn.Get(utxoKey(txID))
+	if err != nil {
+		return TxOutputs{}, err
+	}
+	v, err := item.ValueCopy(nil)
+	if err != nil {
+		return TxOutputs{}, err
+	}
+	return utils.Deserialize[TxOutputs](v)
+}
+
+func putUTXO(txn *badger.Txn, txID []byte, outs TxOutputs) error {
+	if len(outs.Outputs) == 0 {
+		return txn.Delete(utxoKey(txID))
+	}
+	return txn.Set(utxoKey(txID), outs.Serialize())
+}
+
+// connectBlock moves the UTXO set forward over block and stores the outputs
+// it spent as the block's undo data. The transaction indexes follow along.
+func connectBlock(txn *badger.Txn, block *Block) error {
+	undo := BlockUndo{}
+	for _, tx := range block.Transactions {
+		if !tx.IsCoinbase() {
+			for _, input := range tx.Inputs {
+				outs, err := getUTXO(txn, input.ID)
+				if err != nil {
+					return err
 				}
-				if len(updatedOuts.Outputs) == 0 {
-					if err := txn.Delete(inID); err != nil {
-						log.Panic(err)
-					}
-				} else {
-					if err := txn.Set(inID, updatedOuts.Serialize()); err != nil {
-						log.Panic(err)
-					}
+				undo.Spent = append(undo.Spent, SpentOutput{input.ID, input.OutIndex, outs.Outputs[input.OutIndex], outs.Height, outs.Coinbase})
+				delete(outs.Outputs, input.OutIndex)
+				if err := putUTXO(txn, input.ID, outs); err != nil {
+					return err
 				}
 			}
 		}
-		newOutputs := TxOutputs{}
-			newOutputs.Outputs = append(newOutputs.Outputs, tx.Outputs...)
-			txID := append(utxoPrefix, tx.ID...)
-			if err := txn.Set(txID, newOutputs.Serialize()); err != nil {
-				log.Panic(err)
-			}
+		if err := putUTXO(txn, tx.ID, newTxOutputs(tx, block.Height)); err != nil {
+			return err
 		}
-		return nil
-	})
-	utils.HandleError(err)
+	}
+	if err := indexBlock(txn, block); err != nil {
+		return err
+	}
+	return txn.Set(undoKey(block.Hash), undo.Serialize())
 }
 
-func (u *UTXOSet) CountTransactions() int {
+// disconnectBlock reverts connectBlock using the undo data stored for block.
+func disconnectBlock(txn *badger.Txn, block *Block) error {
+	item, err := txn.Get(undoKey(block.Hash))
+	if err != nil {
+		return fmt.Errorf("no undo data for block %x: %w", block.Hash, err)
+	}
+	v, err := item.ValueCopy(nil)
+	if err != nil {
+		return err
+	}
+	undo, err := utils.Deserialize[BlockUndo](v)
+	if err != nil {
+		return err
+	}
+
+	created := make(map[string]bool)
+	for _, tx := range block.Transactions {
+		created[hex.EncodeToString(tx.ID)] = true
+		if err := txn.Delete(utxoKey(tx.ID)); err != nil {
+			return err
+		}
+	}
+
+	for _, spent := range undo.Spent {
+		if created[hex.EncodeToString(spent.TxID)] {
+			continue
+		}
+		outs, err := getUTXO(txn, spent.TxID)
+		if err == badger.ErrKeyNotFound {
+			outs, err = TxOutputs{make(map[int]TxOutput), spent.Height, spent.Coinbase}, nil
+		}
+		if err != nil {
+			return err
+		}
+		outs.Outputs[spent.Index] = spent.Output
+		if err := putUTXO(txn, spent.TxID, outs); err != nil {
+			return err
+		}
+	}
+	if err := unindexBlock(txn, block); err != nil {
+		return err
+	}
+	return txn.Delete(undoKey(block.Hash))
+}
+
+func (u *UTXOSet) CountTransactions() (int, error) {
 	db := u.Blockchain.Database
 	count := 0
 	err := db.View(func(txn *badger.Txn) error {
@@ -90,11 +210,73 @@ func (u *UTXOSet) CountTransactions() int {
 		}
 		return nil
 	})
-	utils.HandleError(err)
-	return count
+	return count, err
+}
+
+// Balance returns what the outputs locked to pubKeyHash are worth, split into
+// what can be spent in the next block and coinbase rewards still maturing.
+func (u UTXOSet) Balance(pubKeyHash []byte) (int, int, error) {
+	mature, immature := 0, 0
+	err := u.Blockchain.Database.View(func(txn *badger.Txn) error {
+		height, err := nextHeight(txn)
+		if err != nil {
+			return err
+		}
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Seek(utxoPrefix); it.ValidForPrefix(utxoPrefix); it.Next() {
+			v, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			outs, err := utils.Deserialize[TxOutputs](v)
+			if err != nil {
+				return err
+			}
+			for _, out := range outs.Outputs {
+				if !out.IsLockedWithKey(pubKeyHash) {
+					continue
+				}
+				if outs.Mature(height) {
+					mature += out.Value
+				} else {
+					immature += out.Value
+				}
+			}
+		}
+		return nil
+	})
+	return mature, immature, err
 }
 
-func (u *UTXOSet) DeleteByPrefix(prefix []byte) {
+// Supply returns the value of every unspent output, which is what the mined
+// coins are worth minus the fees and subsidy miners did not claim.
+func (u UTXOSet) Supply() (int, error) {
+	supply := 0
+	err := u.Blockchain.Database.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Seek(utxoPrefix); it.ValidForPrefix(utxoPrefix); it.Next() {
+			v, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			outs, err := utils.Deserialize[TxOutputs](v)
+			if err != nil {
+				return err
+			}
+			for _, out := range outs.Outputs {
+				if supply, err = addValue(supply, out.Value); err != nil {
+					return fmt.Errorf("%w: %v", ErrSupplyExceeded, err)
+				}
+			}
+		}
+		return nil
+	})
+	return supply, err
+}
+
+func (u *UTXOSet) DeleteByPrefix(prefix []byte) error {
 	deleteKeys := func(keysForDelete [][]byte) error {
 		if err := u.Blockchain.Database.Update(func(txn *badger.Txn) error {
 			for _, key := range keysForDelete {
@@ -110,7 +292,7 @@ func (u *UTXOSet) DeleteByPrefix(prefix []byte) {
 	}
 
 	collectSize := 100000
-	u.Blockchain.Database.View(func(txn *badger.Txn) error {
+	return u.Blockchain.Database.View(func(txn *badger.Txn) error {
 		opts := badger.DefaultIteratorOptions
 		opts.PrefetchValues = false
 		it := txn.NewIterator(opts)
@@ -124,7 +306,7 @@ func (u *UTXOSet) DeleteByPrefix(prefix []byte) {
 			keysCollected++
 			if keysCollected == collectSize {
 				if err := deleteKeys(keysForDelete); err != nil {
-					log.Panic(err)
+					return err
 				}
 				keysForDelete = make([][]byte, 0, collectSize)
 				keysCollected = 0
@@ -132,16 +314,15 @@ func (u *UTXOSet) DeleteByPrefix(prefix []byte) {
 		}
 		if keysCollected > 0 {
 			if err := deleteKeys(keysForDelete); err != nil {
-				log.Panic(err)
+				return err
 			}
 		}
 		return nil
 
 	})
-
 }
 
-func (u UTXOSet) FindUnspentTransactions(pubKeyHash []byte) []TxOutput {
+func (u UTXOSet) FindUnspentTransactions(pubKeyHash []byte) ([]TxOutput, error) {
 	var unspentTxs []TxOutput
 
 	db := u.Blockchain.Database
@@ -152,8 +333,13 @@ func (u UTXOSet) FindUnspentTransactions(pubKeyHash []byte) []TxOutput {
 		for it.Seek(utxoPrefix); it.ValidForPrefix(utxoPrefix); it.Next() {
 			item := it.Item()
 			v, err := item.ValueCopy(nil)
-			utils.HandleError(err)
-			outs := utils.Deserialize[TxOutputs](v)
+			if err != nil {
+				return err
+			}
+			outs, err := utils.Deserialize[TxOutputs](v)
+			if err != nil {
+				return err
+			}
 			for _, out := range outs.Outputs {
 				if out.IsLockedWithKey(pubKeyHash) {
 					unspentTxs = append(unspentTxs, out)
@@ -162,16 +348,25 @@ func (u UTXOSet) FindUnspentTransactions(pubKeyHash []byte) []TxOutput {
 		}
 		return nil
 	})
-	utils.HandleError(err)	
-	return unspentTxs
+	return unspentTxs, err
 }
 
-func (u UTXOSet) FindSpendableOutputs(pubKeyHash []byte, amount int) (int, map[string][]int) {
-	unspentOuts := make(map[string][]int)
-	accumulated := 0
+// SpendableCoins returns the outputs locked to pubKeyHash that an input with
+// sequence can spend in the next block and are not reserved by a pending
+// transaction.
+func (u UTXOSet) SpendableCoins(pubKeyHash []byte, sequence uint32) ([]Coin, error) {
+	var coins []Coin
 	db := u.Blockchain.Database
 
 	err := db.View(func(txn *badger.Txn) error {
+		height, err := nextHeight(txn)
+		if err != nil {
+			return err
+		}
+		reserved, err := reservedOutpoints(txn)
+		if err != nil {
+			return err
+		}
 		opts := badger.DefaultIteratorOptions
 
 		it := txn.NewIterator(opts)
@@ -179,23 +374,54 @@ func (u UTXOSet) FindSpendableOutputs(pubKeyHash []byte, amount int) (int, map[s
 
 		for it.Seek(utxoPrefix); it.ValidForPrefix(utxoPrefix); it.Next() {
 			item := it.Item()
-			k := item.Key()
-			var v []byte
 			v, err := item.ValueCopy(nil)
-			utils.HandleError(err)
-			k = bytes.TrimPrefix(k, utxoPrefix)
-			txID := hex.EncodeToString(k)
-			outs := utils.Deserialize[TxOutputs](v)
-
-			for outIdx, out := range outs.Outputs {
-				if out.IsLockedWithKey(pubKeyHash) && accumulated < amount {
-					accumulated += out.Value
-					unspentOuts[txID] = append(unspentOuts[txID], outIdx)
+			if err != nil {
+				return err
+			}
+			txID := bytes.TrimPrefix(item.KeyCopy(nil), utxoPrefix)
+			outs, err := utils.Deserialize[TxOutputs](v)
+			if err != nil {
+				return err
+			}
+			if !outs.Mature(height) || height-outs.Height < int(sequence) {
+				continue
+			}
+
+			for _, outIdx := range slices.Sorted(maps.Keys(outs.Outputs)) {
+				out := outs.Outputs[outIdx]
+				if out.IsLockedWithKey(pubKeyHash) && !reserved[Outpoint{txID, outIdx}.String()] {
+					coins = append(coins, Coin{txID, outIdx, out.Value, outs.Height})
 				}
 			}
 		}
 		return nil
 	})
-	utils.HandleError(err)
-	return accumulated, unspentOuts
-}
\ No newline at end of file
+	return coins, err
+}
+
+// FindSpendableOutputs picks outputs worth at least amount with selector,
+// from those deep enough for inputs with sequence. It returns what the picked
+// outputs are worth, or everything spendable when that is less than amount.
+func (u UTXOSet) FindSpendableOutputs(pubKeyHash []byte, amount int, sequence uint32, selector CoinSelector) (int, map[string][]int, error) {
+	unspentOuts := make(map[string][]int)
+	accumulated := 0
+
+	coins, err := u.SpendableCoins(pubKeyHash, sequence)
+	if err != nil {
+		return 0, nil, err
+	}
+	selected := selector(coins, amount)
+	if selected == nil {
+		for _, coin := range coins {
+			accumulated += coin.Value
+		}
+		return accumulated, unspentOuts, nil
+	}
+
+	for _, coin := range selected {
+		txID := hex.EncodeToString(coin.TxID)
+		accumulated += coin.Value
+		unspentOuts[txID] = append(unspentOuts[txID], coin.Index)
+	}
+	return accumulated, unspentOuts, nil
+}
@@ -0,0 +1,284 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dgraph-io/badger"
+)
+
+var (
+	ErrUnknownParent      = errors.New("parent block is unknown")
+	ErrBadHeight          = errors.New("height does not follow the parent block")
+	ErrBadTimestamp       = errors.New("timestamp is too far in the past or future")
+	ErrBadDifficulty      = errors.New("target does not match the expected difficulty")
+	ErrBadProofOfWork     = errors.New("hash does not meet the proof-of-work target")
+	ErrBadBlockHash       = errors.New("hash does not match the block header")
+	ErrBadVersion         = errors.New("block version is not supported")
+	ErrBadMerkleRoot      = errors.New("Merkle root does not match the transactions")
+	ErrNoTransactions     = errors.New("block has no transactions")
+	ErrBadCoinbase        = errors.New("block must start with its only coinbase")
+	ErrCoinbaseValue      = errors.New("coinbase pays more than the block subsidy and fees")
+	ErrBlockTooLarge      = errors.New("block is larger than the maximum block size")
+	ErrBadTxID            = errors.New("transaction ID does not match its contents")
+	ErrBadOutputValue     = errors.New("output value is negative or more than the maximum supply")
+	ErrMissingInput       = errors.New("input spends an unknown or already spent output")
+	ErrImmatureSpend      = errors.New("input spends a coinbase output that has not matured")
+	ErrDoubleSpend        = errors.New("output is spent twice in the same block")
+	ErrInvalidSignature   = errors.New("transaction signature is invalid")
+	ErrOutputsExceedInput = errors.New("transaction outputs exceed its inputs")
+	ErrLooseCoinbase      = errors.New("coinbase transactions are only valid inside a block")
+	ErrTxLocked           = errors.New("transaction lock time has not been reached")
+	ErrNoInputs           = errors.New("transaction has no inputs")
+)
+
+// BlockError reports which consensus rule a block broke. The rule is one of
+// the Err* values above and can be matched with errors.Is.
+type BlockError struct {
+	Hash []byte
+	Err  error
+}
+
+func (e *BlockError) Error() string {
+	return fmt.Sprintf("block %x rejected: %v", e.Hash, e.Err)
+}
+
+func (e *BlockError) Unwrap() error {
+	return e.Err
+}
+
+// ValidateBlock runs every consensus check against the block's parent and the
+// current UTXO set, so the block is expected to extend the current tip.
+func (bc *BlockChain) ValidateBlock(block *Block) error {
+	return bc.Database.View(func(txn *badger.Txn) error {
+		parent, err := getBlock(txn, block.PrevHash)
+		if err != nil {
+			return &BlockError{block.Hash, ErrUnknownParent}
+		}
+		if err := checkBlockHeader(txn, block, parent); err != nil {
+			return err
+		}
+		if err := checkBlockBody(block); err != nil {
+			return err
+		}
+		return checkBlockTransactions(txn, block)
+	})
+}
+
+func checkBlockHeader(txn *badger.Txn, block, parent *Block) error {
+	if block.Height != parent.Height+1 {
+		return &BlockError{block.Hash, ErrBadHeight}
+	}
+
+	mtp, err := medianTimePast(txn, parent)
+	if err != nil {
+		return err
+	}
+	if block.Timestamp <= mtp || block.Timestamp > time.Now().Add(maxFutureDrift).Unix() {
+		return &BlockError{block.Hash, ErrBadTimestamp}
+	}
+
+	bits, err := nextBits(txn, parent)
+	if err != nil {
+		return err
+	}
+	if block.Bits != bits {
+		return &BlockError{block.Hash, ErrBadDifficulty}
+	}
+
+	if block.Version != BlockVersion {
+		return &BlockError{block.Hash, ErrBadVersion}
+	}
+	if !bytes.Equal(block.Header().Hash(), block.Hash) {
+		return &BlockError{block.Hash, ErrBadBlockHash}
+	}
+	if !NewProofOfWork(block).Validate() {
+		return &BlockError{block.Hash, ErrBadProofOfWork}
+	}
+	return nil
+}
+
+// checkBlockBody checks that the transactions are the ones the header commits
+// to, which needs no UTXO set either.
+func checkBlockBody(block *Block) error {
+	if len(block.Transactions) == 0 {
+		return &BlockError{block.Hash, ErrNoTransactions}
+	}
+	if !bytes.Equal(block.MerkleRoot, block.HashTransactions()) {
+		return &BlockError{block.Hash, ErrBadMerkleRoot}
+	}
+	if len(block.Serialize()) > MaxBlockSize {
+		return &BlockError{block.Hash, ErrBlockTooLarge}
+	}
+	return nil
+}
+
+func checkBlockTransactions(txn *badger.Txn, block *Block) error {
+	coinbases, coinbaseValue, fees := 0, 0, 0
+	spent := make(map[string]bool)
+	created := make(map[string]TxOutputs)
+
+	parent, err := getBlock(txn, block.PrevHash)
+	if err != nil {
+		return err
+	}
+	mtp, err := medianTimePast(txn, parent)
+	if err != nil {
+		return err
+	}
+	ctx := BlockContext{block.Height, mtp}
+
+	for i, tx := range block.Transactions {
+		if err := checkTxSanity(tx); err != nil {
+			return &BlockError{block.Hash, fmt.Errorf("tx %x: %w", tx.ID, err)}
+		}
+
+		if tx.IsCoinbase() {
+			// ? Only the first transaction may be a coinbase
+			if i != 0 {
+				return &BlockError{block.Hash, ErrBadCoinbase}
+			}
+			coinbases++
+			if coinbaseValue, err = outputsValue(tx); err != nil {
+				return &BlockError{block.Hash, fmt.Errorf("tx %x: %w", tx.ID, err)}
+			}
+		} else {
+			fee, err := checkTxInputs(txn, tx, ctx, spent, created)
+			if err == nil {
+				fees, err = addValue(fees, fee)
+			}
+			if err != nil {
+				return &BlockError{block.Hash, fmt.Errorf("tx %x: %w", tx.ID, err)}
+			}
+		}
+
+		created[hex.EncodeToString(tx.ID)] = newTxOutputs(tx, block.Height)
+	}
+
+	if coinbases != 1 {
+		return &BlockError{block.Hash, ErrBadCoinbase}
+	}
+	if coinbaseValue > BlockSubsidy(block.Height)+fees {
+		return &BlockError{block.Hash, ErrCoinbaseValue}
+	}
+	return nil
+}
+
+// CheckTransaction checks a loose transaction as if it went into the next
+// block on top of the current tip and returns its fee.
+func (bc *BlockChain) CheckTransaction(tx *Transaction) (int, error) {
+	if tx.IsCoinbase() {
+		return 0, ErrLooseCoinbase
+	}
+	if err := checkTxSanity(tx); err != nil {
+		return 0, err
+	}
+
+	fee := 0
+	err := bc.Database.View(func(txn *badger.Txn) error {
+		ctx, err := nextBlockContext(txn)
+		if err != nil {
+			return err
+		}
+		fee, err = checkTxInputs(txn, tx, ctx, make(map[string]bool), make(map[string]TxOutputs))
+		return err
+	})
+	return fee, err
+}
+
+// checkTxSanity runs the checks that need nothing but the transaction itself.
+func checkTxSanity(tx *Transaction) error {
+	if !bytes.Equal(tx.ID, tx.Hash()) {
+		return ErrBadTxID
+	}
+	if len(tx.Inputs) == 0 {
+		return ErrNoInputs
+	}
+	if _, err := outputsValue(tx); err != nil {
+		return err
+	}
+	for _, out := range tx.Outputs {
+		if IsUnspendable(out.ScriptPubKey) && len(out.ScriptPubKey) > maxDataScriptSize {
+			return ErrDataTooLarge
+		}
+	}
+	return nil
+}
+
+// checkTxInputs checks the inputs of a non-coinbase transaction going into the
+// block described by ctx and returns its fee.
+func checkTxInputs(txn *badger.Txn, tx *Transaction, ctx BlockContext, spent map[string]bool, created map[string]TxOutputs) (int, error) {
+	if !tx.IsFinal(ctx) {
+		return 0, fmt.Errorf("%w: locked until %s", ErrTxLocked, LockTimeString(tx.LockTime))
+	}
+	prevOuts := make([]SpentOutput, len(tx.Inputs))
+	inputValue := 0
+
+	for i, in := range tx.Inputs {
+		outpoint := fmt.Sprintf("%x:%d", in.ID, in.OutIndex)
+		if spent[outpoint] {
+			return 0, ErrDoubleSpend
+		}
+		spent[outpoint] = true
+
+		outs, ok := created[hex.EncodeToString(in.ID)]
+		if !ok {
+			var err error
+			if outs, err = getUTXO(txn, in.ID); err != nil {
+				return 0, ErrMissingInput
+			}
+		}
+		out, ok := outs.Outputs[in.OutIndex]
+		if !ok {
+			return 0, ErrMissingInput
+		}
+		if !outs.Mature(ctx.Height) {
+			return 0, ErrImmatureSpend
+		}
+		if ctx.Height-outs.Height < int(in.Sequence) {
+			return 0, fmt.Errorf("%w: input %d waits %d blocks", ErrTimelocked, i, in.Sequence)
+		}
+		prevOuts[i] = SpentOutput{in.ID, in.OutIndex, out, outs.Height, outs.Coinbase}
+		var err error
+		if inputValue, err = addValue(inputValue, out.Value); err != nil {
+			return 0, err
+		}
+	}
+
+	outputValue, err := outputsValue(tx)
+	if err != nil {
+		return 0, err
+	}
+	if outputValue > inputValue {
+		return 0, ErrOutputsExceedInput
+	}
+	if err := tx.Verify(prevOuts, ctx); err != nil {
+		return 0, err
+	}
+	return inputValue - outputValue, nil
+}
+
+// outputsValue adds up the outputs of tx, failing on any output or total
+// outside what MaxSupply allows.
+func outputsValue(tx *Transaction) (int, error) {
+	total := 0
+	for _, out := range tx.Outputs {
+		var err error
+		if total, err = addValue(total, out.Value); err != nil {
+			return 0, err
+		}
+	}
+	return total, nil
+}
+
+// addValue adds value to total, failing when either is out of range or the
+// sum passes MaxSupply, which also keeps the sum from overflowing.
+func addValue(total, value int) (int, error) {
+	if value < 0 || value > MaxSupply || total > MaxSupply-value {
+		return 0, fmt.Errorf("%w: %d on top of %d", ErrBadOutputValue, value, total)
+	}
+	return total + value, nil
+}
@@ -1,15 +1,17 @@
 package cli
 
 import (
+	"context"
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"math"
 	"os"
-	"runtime"
 	"strconv"
+	"strings"
 	"github.com/nthskyradiated/blockchain-in-golang/blockchain"
 	"github.com/nthskyradiated/blockchain-in-golang/network"
-	"github.com/nthskyradiated/blockchain-in-golang/utils"
 	"github.com/nthskyradiated/blockchain-in-golang/wallet"
 )
 
@@ -20,41 +22,100 @@ func (cli *CommandLine) printUsage() {
 	fmt.Println("  getbalance -address ADDRESS - Get balance of an address")
 	fmt.Println("  createblockchain -address ADDRESS - Create a blockchain and send genesis block reward to ADDRESS")
 	fmt.Println("  print - Print the blockchain")
-	fmt.Println("  send -from FROM -to TO -amount AMOUNT -mine - Send amount of coins. Then -mine flag is set, mine off of this node")
-	fmt.Println("  createwallet - Create a new Wallet")
-	fmt.Println("  listaddresses - List the addresses in our wallet file")
-	fmt.Println("  reindex - Rebuilds the UTXO set")
-	fmt.Println("  startnode -miner ADDRESS - Start a node with ID specified in NODE_ID env. var. -miner enables mining")
+	fmt.Println("  send -from FROM -to TO -amount AMOUNT -fee FEE -strategy STRATEGY -mine - Send amount of coins, leaving FEE for the miner and picking coins with STRATEGY (largest, oldest or bnb). Then -mine flag is set, mine off of this node")
+	fmt.Println("  send -from FROM -to ADDRESS:AMOUNT [-to ADDRESS:AMOUNT ...] -file PAYMENTS - Pay several addresses in one transaction, listed as flags or in a CSV or JSON file")
+	fmt.Println("  send -locktime HEIGHT|UNIXTIME -out PST - Keep the transaction out of blocks until a height, or a time from 500000000 on. Until then it is signed into PST for tx broadcast")
+	fmt.Println("  send -sequence BLOCKS - Only spend outputs at least BLOCKS blocks deep, and keep the transaction out of blocks until they are")
+	fmt.Println("  send -release TXID - Free the outputs reserved by a sent transaction that will never be mined")
+	fmt.Println("  createwallet - Derive a new address from our seed, creating the seed on first use")
+	fmt.Println("  exportmnemonic - Show the mnemonic phrase backing up our seed")
+	fmt.Println("  restorewallet -mnemonic PHRASE - Regenerate the keys of a mnemonic phrase and find their funds on the chain")
+	fmt.Println("  wallet encrypt - Encrypt the wallet file with a passphrase, read from " + newPassphraseEnv + " or asked for")
+	fmt.Println("  wallet changepassphrase - Encrypt the wallet file with a new passphrase")
+	fmt.Println("  listaddresses -pubkeys - List the addresses in our wallet file, with their public keys with -pubkeys")
+	fmt.Println("  exportkey -address ADDRESS -format wif|pem - Print the private key of an address in wallet import format or as a PKCS#8 PEM block")
+	fmt.Println("  importkey -wif KEY | -pem FILE | -watch PUBKEY|ADDRESS - Import a private key, or watch a public key or address without its key, and show its balance")
+	fmt.Println("  createmultisig -required M -keys KEY,KEY,... - Create an M-of-N multisig address from hex public keys or our own addresses")
+	fmt.Println("  sendmultisig -from MULTISIG -to TO -amount AMOUNT -fee FEE -out FILE - Same as tx create followed by tx sign, for a multisig address")
+	fmt.Println("  signmultisig -in FILE - Same as tx sign -in FILE")
+	fmt.Println("  finalizemultisig -in FILE -mine - Same as tx broadcast -in FILE")
+	fmt.Println("  tx create -from FROM -to TO -amount AMOUNT -fee FEE -locktime LOCKTIME -sequence BLOCKS -out PST - Write an unsigned transaction and the outputs it spends to a PST file")
+	fmt.Println("  tx sign -in PST -out PST - Add the signatures of our keys to a PST, without needing the chain")
+	fmt.Println("  tx combine -out PST PST... - Merge the signatures of separately signed copies of a PST")
+	fmt.Println("  tx inspect -in PST - Show a PST and the signatures it still needs")
+	fmt.Println("  tx broadcast -in PST -mine - Send the fully signed PST to the network, or mine it on this node with -mine")
+	fmt.Println("  swap initiate -from FROM -to TO -amount AMOUNT -duration 48h -secrethash HASH - Lock AMOUNT in a contract TO can redeem with a secret and FROM can refund after -duration. -secrethash takes part in the other party's swap")
+	fmt.Println("  swap redeem -contract CONTRACT -txid TXID -secret SECRET - Claim a contract paying to us by revealing its secret")
+	fmt.Println("  swap refund -contract CONTRACT -txid TXID - Take back the coins of our contract once its deadline has passed")
+	fmt.Println("  swap audit -contract CONTRACT -txid TXID - Check a contract and show the secret once it has been redeemed")
+	fmt.Println("  notarize -from FROM -file PATH -fee FEE -mine - Commit the SHA-256 hash of a file to the chain, paid for by FROM")
+	fmt.Println("  verifynotary -file PATH - Show the block and time a file's hash was committed to the chain")
+	fmt.Println("  reindex -tx - Rebuilds the UTXO set, or the transaction and address indexes with -tx")
+	fmt.Println("  supply - Show the circulating supply and the issuance schedule")
+	fmt.Println("  history -address ADDRESS - List the transactions paying to or spending from an address")
+	fmt.Println("  startnode -miner ADDRESS -workers N - Start a node with ID specified in NODE_ID env. var. -miner enables mining on N goroutines")
+	fmt.Println("  startnode -light - Start a light node that only syncs block headers and verifies payments to our wallet")
+	fmt.Println("  Commands using an encrypted wallet file ask for its passphrase, or read it from " + passphraseEnv)
 }
 
-func (cli *CommandLine) validateArgs() {
+func (cli *CommandLine) validateArgs() error {
 	if len(os.Args) < 2 {
 		cli.printUsage()
-		runtime.Goexit()
+		return errUsage
 	}
+	return nil
 }
 
-func (cli *CommandLine) StartNode(nodeId, minerAddress string) {
+func (cli *CommandLine) StartNode(nodeId, minerAddress string) error {
 	fmt.Printf("Starting Node %s\n", nodeId)
 
 	if len(minerAddress) > 0 {
-		if wallet.ValidateAddress(minerAddress) {
-			fmt.Println("Mining is on. Address to receive rewards: ", minerAddress)
-		} else {
-			log.Panic("Wrong miner address!")
+		if _, err := wallet.PubKeyHashFromAddress(minerAddress); err != nil {
+			return fmt.Errorf("miner address: %w", err)
 		}
+		fmt.Println("Mining is on. Address to receive rewards: ", minerAddress)
+		wallets, err := wallet.NewWallets(nodeId)
+		if err != nil {
+			return err
+		}
+		if _, err := wallets.GetWallet(minerAddress); err != nil {
+			fmt.Println("Warning: the miner address is not in our wallet file")
+		}
+	}
+	return network.StartServer(nodeId, minerAddress)
+}
+
+func (cli *CommandLine) StartLightNode(nodeId string) error {
+	fmt.Printf("Starting light node %s\n", nodeId)
+
+	wallets, err := wallet.NewWallets(nodeId)
+	if err != nil {
+		return err
+	}
+	addresses := wallets.GetAllAddresses()
+	for address := range wallets.WatchOnly {
+		addresses = append(addresses, address)
+	}
+	for _, address := range addresses {
+		fmt.Println("Watching payments to:", address)
 	}
-	network.StartServer(nodeId, minerAddress)
+	return network.StartLightServer(nodeId, addresses)
 }
 
-func (cli *CommandLine) printChain(nodeId string) {
+func (cli *CommandLine) printChain(nodeId string) error {
 
-	chain := blockchain.ContinueBlockChain(nodeId)
+	chain, err := blockchain.ContinueBlockChain(nodeId)
+	if err != nil {
+		return err
+	}
 	defer chain.Database.Close()
 	iter := chain.Iterator()
 	fmt.Println("printing")
 	for {
-		block := iter.Next()
+		block, err := iter.Next()
+		if err != nil {
+			return err
+		}
 		fmt.Printf("Prev. hash: %x\n", block.PrevHash)
 		fmt.Printf("Hash: %x\n", block.Hash)
 		pow := blockchain.NewProofOfWork(block)
@@ -67,101 +128,340 @@ func (cli *CommandLine) printChain(nodeId string) {
 			break
 		}
 	}
+	return nil
 }
 
-func (cli *CommandLine) createblockchain(address, nodeId string) {
-	if !wallet.ValidateAddress(address) {
-		log.Panicf("Invalid address: %s", address)
+func (cli *CommandLine) createblockchain(address, nodeId string) error {
+	if _, err := wallet.PubKeyHashFromAddress(address); err != nil {
+		return err
+	}
+	chain, err := blockchain.NewBlockChain(address, nodeId)
+	if err != nil {
+		return err
 	}
-	chain := blockchain.NewBlockChain(address, nodeId)
 	defer chain.Database.Close()
 
 	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
-	UTXOSet.Reindex()
+	if err := UTXOSet.Reindex(); err != nil {
+		return err
+	}
 
 	fmt.Println("Blockchain created successfully!")
+	return nil
 }
 
-func (cli *CommandLine) listAddresses(nodeId string) {
-	wallets, _ := wallet.NewWallets(nodeId)
+func (cli *CommandLine) listAddresses(nodeId string, pubKeys bool) error {
+	wallets, err := wallet.NewWallets(nodeId)
+	if err != nil {
+		return err
+	}
 	addresses := wallets.GetAllAddresses()
 
 	for _, address := range addresses {
+		if pubKeys {
+			fmt.Printf("%s %x\n", address, wallets.Wallets[address].PublicKey)
+			continue
+		}
 		fmt.Println(address)
 	}
+	for address, script := range wallets.Multisigs {
+		required, keys, _ := blockchain.ParseMultisigScript(script)
+		fmt.Printf("%s (%d-of-%d multisig)\n", address, required, len(keys))
+	}
+	for address, pubKey := range wallets.WatchOnly {
+		if pubKeys && len(pubKey) > 0 {
+			fmt.Printf("%s %x (watch-only)\n", address, pubKey)
+			continue
+		}
+		fmt.Printf("%s (watch-only)\n", address)
+	}
+	return nil
 }
 
-func (cli *CommandLine) createWallet(nodeId string) {
-	wallets, _ := wallet.NewWallets(nodeId)
-	address := wallets.AddWallet(nodeId)
-	wallets.SaveFile(nodeId)
+func (cli *CommandLine) createWallet(nodeId string) error {
+	wallets, err := wallet.NewWallets(nodeId)
+	if err != nil {
+		return err
+	}
+	newSeed := wallets.Entropy == nil
+	address, err := wallets.AddWallet(nodeId)
+	if err != nil {
+		return err
+	}
 	fmt.Printf("New address is: %s\n", address)
+	if newSeed {
+		fmt.Println("Created a new seed; back it up with exportmnemonic")
+	}
+	return nil
 }
 
-func (cli *CommandLine) reindexUTXO(nodeId string) {
-	chain := blockchain.ContinueBlockChain(nodeId)
+func (cli *CommandLine) reindexUTXO(nodeId string) error {
+	chain, err := blockchain.ContinueBlockChain(nodeId)
+	if err != nil {
+		return err
+	}
 	defer chain.Database.Close()
 	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
-	UTXOSet.Reindex()
+	if err := UTXOSet.Reindex(); err != nil {
+		return err
+	}
 
-	count := UTXOSet.CountTransactions()
+	count, err := UTXOSet.CountTransactions()
+	if err != nil {
+		return err
+	}
 	fmt.Printf("Done! There are %d transactions in the UTXO set.\n", count)
+	return nil
 }
 
-func (cli *CommandLine) getbalance(address, nodeId string) {
-	if !wallet.ValidateAddress(address) {
-		log.Panicf("Invalid address: %s", address)
+func (cli *CommandLine) reindexTransactions(nodeId string) error {
+	chain, err := blockchain.ContinueBlockChain(nodeId)
+	if err != nil {
+		return err
 	}
+	defer chain.Database.Close()
+
+	count, err := chain.ReindexTransactions()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Done! Indexed %d transactions.\n", count)
+	return nil
+}
 
-	chain := blockchain.ContinueBlockChain(nodeId)
+func (cli *CommandLine) history(address, nodeId string) error {
+	pubKeyHash, err := wallet.PubKeyHashFromAddress(address)
+	if err != nil {
+		return err
+	}
+
+	chain, err := blockchain.ContinueBlockChain(nodeId)
+	if err != nil {
+		return err
+	}
+	defer chain.Database.Close()
+
+	history, err := chain.AddressHistory(pubKeyHash)
+	if err != nil {
+		return err
+	}
+	for _, entry := range history {
+		tx, err := chain.FindTransaction(entry.TxID)
+		if err != nil {
+			return err
+		}
+
+		received, sent := 0, 0
+		for _, out := range tx.Outputs {
+			if out.IsLockedWithKey(pubKeyHash) {
+				received += out.Value
+			}
+		}
+		if !tx.IsCoinbase() {
+			for _, in := range tx.Inputs {
+				if !in.UsesKey(pubKeyHash) {
+					continue
+				}
+				prevTx, err := chain.FindTransaction(in.ID)
+				if err != nil {
+					return err
+				}
+				sent += prevTx.Outputs[in.OutIndex].Value
+			}
+		}
+		fmt.Printf("Block %d: tx %x received %d sent %d\n", entry.Height, entry.TxID, received, sent)
+	}
+	return nil
+}
+
+func (cli *CommandLine) getbalance(address, nodeId string) error {
+	pubKeyHash, err := wallet.PubKeyHashFromAddress(address)
+	if err != nil {
+		return err
+	}
+
+	chain, err := blockchain.ContinueBlockChain(nodeId)
+	if err != nil {
+		return err
+	}
 	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
 	defer chain.Database.Close()
 
-	balance := 0
-	pubKeyHash := utils.Base58Decode([]byte(address))
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
-	UTXOs := UTXOSet.FindUnspentTransactions(pubKeyHash)
+	mature, immature, err := UTXOSet.Balance(pubKeyHash)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Balance of %s: %d\n", address, mature+immature)
+	fmt.Printf("  Spendable: %d\n", mature)
+	fmt.Printf("  Immature:  %d\n", immature)
+	return nil
+}
+
+func (cli *CommandLine) supply(nodeId string) error {
+	chain, err := blockchain.ContinueBlockChain(nodeId)
+	if err != nil {
+		return err
+	}
+	defer chain.Database.Close()
 
-	for _, out := range UTXOs {
-		balance += out.Value
+	height, err := chain.GetBestHeight()
+	if err != nil {
+		return err
+	}
+	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
+	circulating, err := UTXOSet.Supply()
+	if err != nil {
+		return err
 	}
-	fmt.Printf("Balance of %s: %d\n", address, balance)
+
+	fmt.Printf("Circulating supply: %d\n", circulating)
+	fmt.Printf("Issued by height %d: %d of %d\n", height, blockchain.Issued(height+1), blockchain.MaxSupply)
+	fmt.Printf("Subsidy of the next block: %d\n", blockchain.BlockSubsidy(height+1))
+	return nil
 }
 
-func (cli *CommandLine) send(from, to string, amount int, nodeId string, mineNow bool) {
-	if !wallet.ValidateAddress(from) || !wallet.ValidateAddress(to) {
-		log.Panicf("Invalid address: from %s, to %s", from, to)
+func (cli *CommandLine) send(from string, payments []blockchain.Payment, fee int, lockTime, sequence uint32, strategy, out, nodeId string, mineNow bool) error {
+	selector, err := blockchain.CoinSelectorByName(strategy)
+	if err != nil {
+		return err
+	}
+	if _, err := wallet.PubKeyHashFromAddress(from); err != nil {
+		return fmt.Errorf("sender: %w", err)
+	}
+	for _, p := range payments {
+		if _, err := wallet.PubKeyHashFromAddress(p.Address); err != nil {
+			return fmt.Errorf("recipient %s: %w", p.Address, err)
+		}
+	}
+	chain, err := blockchain.ContinueBlockChain(nodeId)
+	if err != nil {
+		return err
 	}
-	chain := blockchain.ContinueBlockChain(nodeId)
 	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
 	defer chain.Database.Close()
 
 	wallets, err := wallet.NewWallets(nodeId)
-	utils.HandleError(err)
-	wallet := wallets.GetWallet(from)
+	if err != nil {
+		return err
+	}
+	wallet, err := wallets.GetWallet(from)
+	if err != nil {
+		return err
+	}
 
-	tx := blockchain.NewTransaction(&wallet, to, amount, &UTXOSet)
+	tx, err := blockchain.NewBatchTransaction(&wallet, payments, fee, lockTime, sequence, &UTXOSet, selector)
+	if err != nil {
+		return err
+	}
+	if lockTime > 0 {
+		// ? Nodes turn away transactions that are still locked, so keep it to broadcast later instead
+		if err := chain.VerifyTransaction(tx); errors.Is(err, blockchain.ErrTxLocked) {
+			return saveLocked(chain, tx, &wallet, out)
+		} else if err != nil {
+			return err
+		}
+	}
+
+	// ? Mining here pays the reward to the sender, so the sender is always the one mining the block
+	if err := submitTx(chain, tx, from, fee, mineNow); err != nil {
+		return err
+	}
+	fmt.Println("Transaction successful!")
+	return nil
+}
+
+// saveLocked writes tx, which cannot be mined before its lock time, signed
+// with w's key to the PST file out for tx broadcast to send once it can be.
+func saveLocked(chain *blockchain.BlockChain, tx *blockchain.Transaction, w *wallet.Wallet, out string) error {
+	unsigned := tx.TrimmedCopy()
+	unsigned.ID = unsigned.Hash()
+	partial, err := chain.NewPartialTx(&unsigned, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := partial.Sign(w.PrivateKey); err != nil {
+		return err
+	}
+	if out == "" {
+		out = fmt.Sprintf("%x.pst", unsigned.ID)
+	}
+	if err := writePartial(out, partial); err != nil {
+		return err
+	}
+	fmt.Printf("Transaction is locked until %s, wrote it to %s for tx broadcast -in %s\n", blockchain.LockTimeString(tx.LockTime), out, out)
+	return nil
+}
+
+// release frees the outputs reserved by a sent transaction, for when it was
+// dropped by the network and will never be mined.
+func (cli *CommandLine) release(txIDHex, nodeId string) error {
+	txID, err := hex.DecodeString(txIDHex)
+	if err != nil {
+		return fmt.Errorf("%w: %q", blockchain.ErrNotPending, txIDHex)
+	}
+	chain, err := blockchain.ContinueBlockChain(nodeId)
+	if err != nil {
+		return err
+	}
+	defer chain.Database.Close()
 
+	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
+	if err := UTXOSet.Release(txID); err != nil {
+		return err
+	}
+	fmt.Printf("Released the outputs reserved by %x\n", txID)
+	return nil
+}
+
+// submitTx mines tx into a block on this node paying the reward and fee to
+// rewardTo with mineNow, and otherwise sends it to the network and reserves
+// the outputs it spends once the node has taken it.
+func submitTx(chain *blockchain.BlockChain, tx *blockchain.Transaction, rewardTo string, fee int, mineNow bool) error {
 	if mineNow {
-		// ? Adding the coinbaseTx here would always ensure that the sender is the one mining the block
-		cbTx := blockchain.CoinbaseTx(from, "")
-		block := chain.MineBlock([]*blockchain.Transaction{cbTx, tx})
-		UTXOSet.Update(block)
-		} else {
-			network.SendTx(network.KnownNodes[0], tx)
-			fmt.Println("send tx")
+		height, err := chain.GetBestHeight()
+		if err != nil {
+			return err
+		}
+		cbTx, err := blockchain.CoinbaseTx(rewardTo, "", height+1, fee)
+		if err != nil {
+			return err
 		}
-		fmt.Println("Transaction successful!")
+		_, err = chain.MineBlock(context.Background(), []*blockchain.Transaction{cbTx, tx})
+		return err
+	}
+	if len(network.KnownNodes) == 0 {
+		return network.ErrNodeUnavailable
+	}
+	if err := network.SendTx(network.KnownNodes[0], tx); err != nil {
+		return err
+	}
+	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
+	if err := UTXOSet.Reserve(tx); err != nil {
+		return err
+	}
+	fmt.Printf("Sent transaction %x\n", tx.ID)
+	return nil
+}
 
+// Run executes the command given on the command line and returns the exit
+// code for the process.
+func (cli *CommandLine) Run() int {
+	if err := cli.run(); err != nil {
+		return report(err)
+	}
+	return 0
 }
 
-func (cli *CommandLine) Run() {
-	cli.validateArgs()
+func (cli *CommandLine) run() error {
+	if err := cli.validateArgs(); err != nil {
+		return err
+	}
 			nodeID := os.Getenv("NODE_ID")
 	if nodeID == "" {
 		fmt.Printf("NODE_ID env is not set!")
-		runtime.Goexit()
+		return errUsage
 	}
+	wallet.Passphrase = unlockPassphrase
 	getBalanceCmd := flag.NewFlagSet("getbalance", flag.ExitOnError)
 	createBlockchainCmd := flag.NewFlagSet("createblockchain", flag.ExitOnError)
 	sendCmd := flag.NewFlagSet("send", flag.ExitOnError)
@@ -170,99 +470,244 @@ func (cli *CommandLine) Run() {
 	listAddressesCmd := flag.NewFlagSet("listaddresses", flag.ExitOnError)
 	reindexUTXOCmd := flag.NewFlagSet("reindexutxo", flag.ExitOnError)
 	startNodeCmd := flag.NewFlagSet("startnode", flag.ExitOnError)
+	historyCmd := flag.NewFlagSet("history", flag.ExitOnError)
+	supplyCmd := flag.NewFlagSet("supply", flag.ExitOnError)
+	createMultisigCmd := flag.NewFlagSet("createmultisig", flag.ExitOnError)
+	exportKeyCmd := flag.NewFlagSet("exportkey", flag.ExitOnError)
+	importKeyCmd := flag.NewFlagSet("importkey", flag.ExitOnError)
+	exportMnemonicCmd := flag.NewFlagSet("exportmnemonic", flag.ExitOnError)
+	restoreWalletCmd := flag.NewFlagSet("restorewallet", flag.ExitOnError)
+	notarizeCmd := flag.NewFlagSet("notarize", flag.ExitOnError)
+	verifyNotaryCmd := flag.NewFlagSet("verifynotary", flag.ExitOnError)
 
 	getBalanceAddress := getBalanceCmd.String("address", "", "Address to get balance of")
 	createBlockchainAddress := createBlockchainCmd.String("address", "", "Address to send genesis block reward to")
 	sendFrom := sendCmd.String("from", "", "Address to send from")
-	sendTo := sendCmd.String("to", "", "Address to send to")
-	sendAmount := sendCmd.Int("amount", 0, "Amount to send")
+	var sendTo recipients
+	sendCmd.Var(&sendTo, "to", "Address to send to, or ADDRESS:AMOUNT; repeat to pay several addresses")
+	sendAmount := sendCmd.Int("amount", 0, "Amount to send to each -to address without its own amount")
+	sendFile := sendCmd.String("file", "", "CSV or JSON file listing the addresses and amounts to pay")
+	sendFee := sendCmd.Int("fee", 0, "Fee to leave for the miner")
 	sendMine := sendCmd.Bool("mine", false, "Mine immediately on the same node")
+	sendLockTime := sendCmd.Uint("locktime", 0, "Block height, or Unix time from 500000000 on, before which the transaction cannot be mined")
+	sendSequence := sendCmd.Uint("sequence", 0, "Blocks each spent output must be buried under; only outputs that deep are spent")
+	sendOut := sendCmd.String("out", "", "PST file to write a transaction that is still locked to, TXID.pst by default")
+	sendStrategy := sendCmd.String("strategy", blockchain.DefaultStrategy, "Coin selection strategy: "+blockchain.StrategyNames())
+	sendRelease := sendCmd.String("release", "", "ID of a sent transaction whose reserved outputs to free instead of sending")
+	listPubKeys := listAddressesCmd.Bool("pubkeys", false, "Also print the public key of each address")
+	multisigRequired := createMultisigCmd.Int("required", 0, "Number of signatures needed to spend")
+	multisigKeys := createMultisigCmd.String("keys", "", "Comma separated hex public keys or addresses in our wallet file")
+	exportKeyAddress := exportKeyCmd.String("address", "", "Address in our wallet file")
+	exportKeyFormat := exportKeyCmd.String("format", "wif", "Key format: wif or pem")
+	importKeyWIF := importKeyCmd.String("wif", "", "Private key in wallet import format")
+	importKeyPEM := importKeyCmd.String("pem", "", "File with a PKCS#8 or EC PEM private key")
+	importKeyWatch := importKeyCmd.String("watch", "", "Hex public key or address to watch without its key")
+	restoreMnemonic := restoreWalletCmd.String("mnemonic", "", "Mnemonic phrase, quoted")
+	notarizeFrom := notarizeCmd.String("from", "", "Address to pay the fee from")
+	notarizeFile := notarizeCmd.String("file", "", "File to notarize")
+	notarizeFee := notarizeCmd.Int("fee", 0, "Fee to leave for the miner")
+	notarizeMine := notarizeCmd.Bool("mine", false, "Mine immediately on the same node")
+	verifyNotaryFile := verifyNotaryCmd.String("file", "", "File to look up")
+	reindexTx := reindexUTXOCmd.Bool("tx", false, "Rebuild the transaction and address indexes instead of the UTXO set")
+	historyAddress := historyCmd.String("address", "", "Address to list the transactions of")
 	startNodeMiner := startNodeCmd.String("miner", "", "Enable mining mode and send reward to ADDRESS")
+	startNodeWorkers := startNodeCmd.Int("workers", blockchain.MiningWorkers, "Number of goroutines used for mining")
+	startNodeLight := startNodeCmd.Bool("light", false, "Only sync block headers and verify payments with Merkle proofs")
 
+	// ? The flag sets exit on a parse error, so Parse never returns one
 	switch os.Args[1] {
 
 	case "getbalance":
-		err := getBalanceCmd.Parse(os.Args[2:])
-		utils.HandleError(err)
+		getBalanceCmd.Parse(os.Args[2:])
 
 	case "createblockchain":
-		err := createBlockchainCmd.Parse(os.Args[2:])
-		utils.HandleError(err)
+		createBlockchainCmd.Parse(os.Args[2:])
 
 	case "send":
-		err := sendCmd.Parse(os.Args[2:])
-		utils.HandleError(err)
+		sendCmd.Parse(os.Args[2:])
 
 	case "print":
-		err := printChainCmd.Parse(os.Args[2:])
-		utils.HandleError(err)
+		printChainCmd.Parse(os.Args[2:])
 
 	case "listaddresses":
-		err := listAddressesCmd.Parse(os.Args[2:])
-		utils.HandleError(err)
+		listAddressesCmd.Parse(os.Args[2:])
 
 	case "createwallet":
-		err := createWalletCmd.Parse(os.Args[2:])
-		utils.HandleError(err)
+		createWalletCmd.Parse(os.Args[2:])
 
 	case "reindex":
-		err := reindexUTXOCmd.Parse(os.Args[2:])
-		utils.HandleError(err)
+		reindexUTXOCmd.Parse(os.Args[2:])
 
 	case "startnode":
-		err := startNodeCmd.Parse(os.Args[2:])
-		utils.HandleError(err)
+		startNodeCmd.Parse(os.Args[2:])
+
+	case "history":
+		historyCmd.Parse(os.Args[2:])
+
+	case "supply":
+		supplyCmd.Parse(os.Args[2:])
+
+	case "createmultisig":
+		createMultisigCmd.Parse(os.Args[2:])
+
+	case "exportkey":
+		exportKeyCmd.Parse(os.Args[2:])
+
+	case "importkey":
+		importKeyCmd.Parse(os.Args[2:])
+
+	case "exportmnemonic":
+		exportMnemonicCmd.Parse(os.Args[2:])
+
+	case "restorewallet":
+		restoreWalletCmd.Parse(os.Args[2:])
+
+	case "notarize":
+		notarizeCmd.Parse(os.Args[2:])
+
+	case "verifynotary":
+		verifyNotaryCmd.Parse(os.Args[2:])
+
+	case "wallet":
+		return cli.runWallet(os.Args[2:], nodeID)
+
+	case "tx":
+		return cli.runTx(os.Args[2:], nodeID)
+
+	case "sendmultisig", "signmultisig", "finalizemultisig":
+		return cli.runMultisig(os.Args[1], os.Args[2:], nodeID)
+
+	case "swap":
+		return cli.runSwap(os.Args[2:], nodeID)
 
 	default:
 		cli.printUsage()
-		runtime.Goexit()
+		return errUsage
 	}
 
 	if getBalanceCmd.Parsed() {
 		if *getBalanceAddress == "" {
 			getBalanceCmd.Usage()
-			runtime.Goexit()
+			return errUsage
 		}
-		cli.getbalance(*getBalanceAddress, nodeID)
+		return cli.getbalance(*getBalanceAddress, nodeID)
 	}
 
 	if createBlockchainCmd.Parsed() {
 		if *createBlockchainAddress == "" {
 			createBlockchainCmd.Usage()
-			runtime.Goexit()
+			return errUsage
 		}
-		cli.createblockchain(*createBlockchainAddress, nodeID)
+		return cli.createblockchain(*createBlockchainAddress, nodeID)
 	}
 
 	if printChainCmd.Parsed() {
-		cli.printChain(nodeID)
+		return cli.printChain(nodeID)
 	}
 
 	if createWalletCmd.Parsed() {
-		cli.createWallet(nodeID)
+		return cli.createWallet(nodeID)
 	}
 
 	if listAddressesCmd.Parsed() {
-		cli.listAddresses(nodeID)
+		return cli.listAddresses(nodeID, *listPubKeys)
 	}
 	if reindexUTXOCmd.Parsed() {
-		cli.reindexUTXO(nodeID)
+		if *reindexTx {
+			return cli.reindexTransactions(nodeID)
+		}
+		return cli.reindexUTXO(nodeID)
+	}
+	if supplyCmd.Parsed() {
+		return cli.supply(nodeID)
+	}
+	if historyCmd.Parsed() {
+		if *historyAddress == "" {
+			historyCmd.Usage()
+			return errUsage
+		}
+		return cli.history(*historyAddress, nodeID)
 	}
 	if sendCmd.Parsed() {
-		if *sendFrom == "" || *sendTo == "" || *sendAmount <= 0 {
+		if *sendRelease != "" {
+			return cli.release(*sendRelease, nodeID)
+		}
+		if *sendFrom == "" || (len(sendTo) == 0 && *sendFile == "") || *sendAmount < 0 || *sendFee < 0 || *sendLockTime > math.MaxUint32 || *sendSequence > math.MaxUint32 {
 			sendCmd.Usage()
-			runtime.Goexit()
+			return errUsage
+		}
+		payments, err := sendTo.payments(*sendAmount)
+		if err != nil {
+			return err
+		}
+		if *sendFile != "" {
+			batch, err := readPayments(*sendFile)
+			if err != nil {
+				return err
+			}
+			payments = append(payments, batch...)
+		}
+		return cli.send(*sendFrom, payments, *sendFee, uint32(*sendLockTime), uint32(*sendSequence), *sendStrategy, *sendOut, nodeID, *sendMine)
+	}
+	if createMultisigCmd.Parsed() {
+		if *multisigRequired <= 0 || *multisigKeys == "" {
+			createMultisigCmd.Usage()
+			return errUsage
+		}
+		return cli.createMultisig(*multisigRequired, strings.Split(*multisigKeys, ","), nodeID)
+	}
+	if exportKeyCmd.Parsed() {
+		if *exportKeyAddress == "" {
+			exportKeyCmd.Usage()
+			return errUsage
+		}
+		return cli.exportKey(*exportKeyAddress, *exportKeyFormat, nodeID)
+	}
+	if importKeyCmd.Parsed() {
+		given := 0
+		for _, v := range []string{*importKeyWIF, *importKeyPEM, *importKeyWatch} {
+			if v != "" {
+				given++
+			}
+		}
+		if given != 1 {
+			importKeyCmd.Usage()
+			return errUsage
+		}
+		return cli.importKey(*importKeyWIF, *importKeyPEM, *importKeyWatch, nodeID)
+	}
+	if exportMnemonicCmd.Parsed() {
+		return cli.exportMnemonic(nodeID)
+	}
+	if restoreWalletCmd.Parsed() {
+		if *restoreMnemonic == "" {
+			restoreWalletCmd.Usage()
+			return errUsage
+		}
+		return cli.restoreWallet(*restoreMnemonic, nodeID)
+	}
+	if notarizeCmd.Parsed() {
+		if *notarizeFrom == "" || *notarizeFile == "" || *notarizeFee < 0 {
+			notarizeCmd.Usage()
+			return errUsage
+		}
+		return cli.notarize(*notarizeFrom, *notarizeFile, *notarizeFee, nodeID, *notarizeMine)
+	}
+	if verifyNotaryCmd.Parsed() {
+		if *verifyNotaryFile == "" {
+			verifyNotaryCmd.Usage()
+			return errUsage
 		}
-		cli.send(*sendFrom, *sendTo, *sendAmount, nodeID, *sendMine)
+		return cli.verifyNotary(*verifyNotaryFile, nodeID)
 	}
 
 		if startNodeCmd.Parsed() {
-		nodeID := os.Getenv("NODE_ID")
 		fmt.Printf("Starting node with ID: %s\n", nodeID)
-		if nodeID == "" {
-			startNodeCmd.Usage()
-			runtime.Goexit()
+		if *startNodeLight {
+			return cli.StartLightNode(nodeID)
 		}
-		cli.StartNode(nodeID, *startNodeMiner)
+		blockchain.MiningWorkers = *startNodeWorkers
+		return cli.StartNode(nodeID, *startNodeMiner)
 	}
+	return nil
 }
@@ -0,0 +1,77 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/nthskyradiated/blockchain-in-golang/blockchain"
+	"github.com/nthskyradiated/blockchain-in-golang/network"
+	"github.com/nthskyradiated/blockchain-in-golang/wallet"
+)
+
+const (
+	exitFailure = 1
+	exitUsage   = 2
+)
+
+// errUsage means the usage was already printed and there is nothing to add.
+var errUsage = errors.New("usage")
+
+// hints tell the user what to do about the errors they are most likely to
+// run into.
+var hints = []struct {
+	err  error
+	hint string
+}{
+	{blockchain.ErrNoChain, "create one with createblockchain -address ADDRESS"},
+	{blockchain.ErrChainExists, "remove the ./tmp/blocks_NODE_ID directory to start over"},
+	{blockchain.ErrNewerDatabase, "run a newer build, or remove the ./tmp/blocks_NODE_ID directory and sync the chain again"},
+	{blockchain.ErrInsufficientFunds, "check the balance with getbalance -address ADDRESS"},
+	{errBadPayment, "pass -to ADDRESS:AMOUNT, or a bare address together with -amount"},
+	{blockchain.ErrNotPending, "pass the ID printed when the transaction was sent; reservations also expire on their own"},
+	{network.ErrNodeUnavailable, "start the central node (NODE_ID 3000) with startnode, or mine the transaction here with -mine"},
+	{blockchain.ErrUnknownStrategy, "leave -strategy out to use the default"},
+	{blockchain.ErrTxNotFound, "rebuild the indexes with reindex -tx if the transaction should be there"},
+	{blockchain.ErrBadMultisig, "pass -keys as comma separated hex public keys from listaddresses -pubkeys, at least -required of them"},
+	{blockchain.ErrIncompleteTx, "have more of the signers run tx sign -in PST, then tx combine their copies"},
+	{blockchain.ErrNotPST, "pass a file written by tx create, tx sign or tx combine"},
+	{blockchain.ErrNothingToSign, "none of our keys can sign the inputs of this transaction, or they already signed"},
+	{blockchain.ErrStaleBlock, "nothing was mined, run the command again to mine on the new tip"},
+	{blockchain.ErrTxLocked, "wait for the lock time, or sign it now with tx create -locktime and tx sign, then tx broadcast it once unlocked"},
+	{blockchain.ErrTimelocked, "wait until the output being spent is old enough"},
+	{blockchain.ErrNotHTLC, "pass the contract printed by swap initiate"},
+	{blockchain.ErrWrongSecret, "use the secret printed by swap initiate, or read it off the redeemed contract with swap audit"},
+	{blockchain.ErrNotInTx, "pass the contract transaction printed by swap initiate along with its contract"},
+	{blockchain.ErrDataTooLarge, "commit a hash of the data instead, as notarize does"},
+	{errNotNotarized, "notarize it with notarize -from ADDRESS -file PATH, and check again once the transaction is mined"},
+	{wallet.ErrInvalidAddress, "check the address for typos"},
+	{wallet.ErrBadMnemonic, "check the words and their order against the backup, quoting the whole phrase"},
+	{wallet.ErrNoSeed, "create an address with createwallet first"},
+	{wallet.ErrSeedExists, "restore on a node without a wallet file, or move ./tmp/wallets_NODE_ID.data away first"},
+	{wallet.ErrWalletLocked, "set " + passphraseEnv + " or run the command in a terminal to be asked for it"},
+	{wallet.ErrWrongPassphrase, "check the passphrase, including " + passphraseEnv + " if it is set"},
+	{wallet.ErrAlreadyEncrypted, "change the passphrase with wallet changepassphrase"},
+	{wallet.ErrNotEncrypted, "encrypt it with wallet encrypt"},
+	{wallet.ErrBadWalletFile, "restore the wallet file from a backup, or the keys with restorewallet -mnemonic"},
+	{errPassphraseMismatch, "type the same passphrase both times"},
+	{wallet.ErrBadKey, "pass a key printed by exportkey, a P-256 PEM private key, or a hex public key from listaddresses -pubkeys"},
+	{errUnknownKeyFormat, "pass -format wif or -format pem"},
+	{wallet.ErrWalletNotFound, "send from one of the addresses printed by listaddresses"},
+}
+
+// report prints err for the user and returns the exit code to use for it.
+func report(err error) int {
+	if errors.Is(err, errUsage) {
+		return exitUsage
+	}
+
+	fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	for _, h := range hints {
+		if errors.Is(err, h.err) {
+			fmt.Fprintf(os.Stderr, "Hint: %s\n", h.hint)
+			break
+		}
+	}
+	return exitFailure
+}
@@ -0,0 +1,112 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/nthskyradiated/blockchain-in-golang/blockchain"
+	"github.com/nthskyradiated/blockchain-in-golang/wallet"
+)
+
+var errUnknownKeyFormat = errors.New("unknown key format")
+
+// exportKey prints the private key of one of our addresses in wallet import
+// format, or as a PKCS#8 PEM block.
+func (cli *CommandLine) exportKey(address, format, nodeId string) error {
+	if format != "wif" && format != "pem" {
+		return fmt.Errorf("%w: %q", errUnknownKeyFormat, format)
+	}
+	wallets, err := wallet.NewWallets(nodeId)
+	if err != nil {
+		return err
+	}
+	w, err := wallets.GetWallet(address)
+	if err != nil {
+		return err
+	}
+	if format == "wif" {
+		fmt.Println(wallet.EncodeWIF(&w))
+		return nil
+	}
+	block, err := wallet.EncodePEM(&w)
+	if err != nil {
+		return err
+	}
+	fmt.Print(string(block))
+	return nil
+}
+
+// importKey adds a private key in wallet import format or read from a PEM
+// file, or else watches a public key or address, and shows what the address
+// holds on the chain.
+func (cli *CommandLine) importKey(wif, pemFile, watch, nodeId string) error {
+	wallets, err := wallet.NewWallets(nodeId)
+	if err != nil {
+		return err
+	}
+
+	var address string
+	switch {
+	case watch != "":
+		var pubKey []byte
+		address, pubKey, err = wallet.ParseWatchOnly(watch)
+		if err != nil {
+			return err
+		}
+		if err := wallets.ImportWatchOnly(address, pubKey, nodeId); err != nil {
+			return err
+		}
+		fmt.Printf("Watching %s\n", address)
+
+	default:
+		var w *wallet.Wallet
+		if pemFile != "" {
+			data, err := os.ReadFile(pemFile)
+			if err != nil {
+				return err
+			}
+			w, err = wallet.DecodePEM(data)
+			if err != nil {
+				return fmt.Errorf("%s: %w", pemFile, err)
+			}
+		} else if w, err = wallet.DecodeWIF(wif); err != nil {
+			return err
+		}
+		address, err = wallets.ImportWallet(w, nodeId)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Imported %s; back up the wallet file, the mnemonic does not cover imported keys\n", address)
+	}
+	return rescanAddress(address, nodeId)
+}
+
+// rescanAddress shows the balance and number of transactions of an address
+// newly added to the wallet file.
+func rescanAddress(address, nodeId string) error {
+	chain, err := blockchain.ContinueBlockChain(nodeId)
+	if errors.Is(err, blockchain.ErrNoChain) {
+		fmt.Println("No chain on this node yet to look for its funds")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer chain.Database.Close()
+	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
+
+	pubKeyHash, _ := wallet.PubKeyHashFromAddress(address)
+	history, err := chain.AddressHistory(pubKeyHash)
+	if err != nil {
+		return err
+	}
+	mature, immature, err := UTXOSet.Balance(pubKeyHash)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Balance of %s: %d in %d transactions\n", address, mature+immature, len(history))
+	fmt.Printf("  Spendable: %d\n", mature)
+	fmt.Printf("  Immature:  %d\n", immature)
+	return nil
+}
@@ -0,0 +1,99 @@
+package cli
+
+import (
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/nthskyradiated/blockchain-in-golang/blockchain"
+	"github.com/nthskyradiated/blockchain-in-golang/wallet"
+)
+
+// createMultisig makes the M-of-N address of keys, each a hex public key or
+// an address in our wallet file, and remembers its redeem script.
+func (cli *CommandLine) createMultisig(required int, keys []string, nodeId string) error {
+	wallets, err := wallet.NewWallets(nodeId)
+	if err != nil {
+		return err
+	}
+	var pubKeys [][]byte
+	for _, key := range keys {
+		key = strings.TrimSpace(key)
+		if w, err := wallets.GetWallet(key); err == nil {
+			pubKeys = append(pubKeys, w.PublicKey)
+			continue
+		}
+		pubKey, err := hex.DecodeString(key)
+		if err != nil {
+			return fmt.Errorf("%w: %q is neither a public key nor one of our addresses", blockchain.ErrBadMultisig, key)
+		}
+		pubKeys = append(pubKeys, pubKey)
+	}
+
+	script, err := blockchain.MultisigScript(required, pubKeys)
+	if err != nil {
+		return err
+	}
+	address, err := wallets.AddMultisig(script, nodeId)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("New %d-of-%d multisig address is: %s\n", required, len(pubKeys), address)
+	fmt.Printf("Redeem script: %s\n", blockchain.DisassembleScript(script))
+	return nil
+}
+
+// runMultisig runs sendmultisig, signmultisig and finalizemultisig, the
+// multisig commands from before PST files, as shorthands for the tx
+// subcommands. Their files are PST files.
+func (cli *CommandLine) runMultisig(command string, args []string, nodeId string) error {
+	cmd := flag.NewFlagSet(command, flag.ExitOnError)
+	switch command {
+	case "sendmultisig":
+		from := cmd.String("from", "", "Multisig address to send from")
+		var to recipients
+		cmd.Var(&to, "to", "Address to send to, or ADDRESS:AMOUNT; repeat to pay several addresses")
+		amount := cmd.Int("amount", 0, "Amount to send to each -to address without its own amount")
+		fee := cmd.Int("fee", 0, "Fee to leave for the miner")
+		strategy := cmd.String("strategy", blockchain.DefaultStrategy, "Coin selection strategy: "+blockchain.StrategyNames())
+		out := cmd.String("out", "", "PST file to write")
+		cmd.Parse(args)
+		if *from == "" || len(to) == 0 || *out == "" || *amount < 0 || *fee < 0 {
+			cmd.Usage()
+			return errUsage
+		}
+		payments, err := to.payments(*amount)
+		if err != nil {
+			return err
+		}
+		if err := cli.txCreate(*from, payments, *fee, 0, 0, *strategy, *out, nodeId); err != nil {
+			return err
+		}
+		// ? sendmultisig signed with whatever keys we hold, which may be none of them
+		if err := cli.txSign(*out, *out, nodeId); err != nil && !errors.Is(err, blockchain.ErrNothingToSign) {
+			return err
+		}
+		return nil
+
+	case "signmultisig":
+		in := cmd.String("in", "", "PST file to sign in place")
+		cmd.Parse(args)
+		if *in == "" {
+			cmd.Usage()
+			return errUsage
+		}
+		return cli.txSign(*in, *in, nodeId)
+
+	default:
+		in := cmd.String("in", "", "Fully signed PST file")
+		mine := cmd.Bool("mine", false, "Mine immediately on the same node")
+		cmd.Parse(args)
+		if *in == "" {
+			cmd.Usage()
+			return errUsage
+		}
+		return cli.txBroadcast(*in, nodeId, *mine)
+	}
+}
@@ -0,0 +1,104 @@
+package cli
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/nthskyradiated/blockchain-in-golang/blockchain"
+	"github.com/nthskyradiated/blockchain-in-golang/wallet"
+)
+
+// notaryTag starts the data output of a notarization, telling a document
+// hash apart from other data committed to the chain.
+var notaryTag = []byte("NTRY")
+
+var errNotNotarized = errors.New("file has not been notarized on this chain")
+
+// notaryData returns the data output contents committing to the file at path.
+func notaryData(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(append([]byte{}, notaryTag...)), nil
+}
+
+// notarize commits the SHA-256 hash of a file to the chain in a data output
+// paid for by the address from.
+func (cli *CommandLine) notarize(from, path string, fee int, nodeId string, mineNow bool) error {
+	data, err := notaryData(path)
+	if err != nil {
+		return err
+	}
+	chain, err := blockchain.ContinueBlockChain(nodeId)
+	if err != nil {
+		return err
+	}
+	defer chain.Database.Close()
+	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
+
+	wallets, err := wallet.NewWallets(nodeId)
+	if err != nil {
+		return err
+	}
+	w, err := wallets.GetWallet(from)
+	if err != nil {
+		return err
+	}
+	selector, _ := blockchain.CoinSelectorByName(blockchain.DefaultStrategy)
+	tx, err := blockchain.NewDataTransaction(&w, data, fee, &UTXOSet, selector)
+	if err != nil {
+		return err
+	}
+	if err := submitTx(chain, tx, from, fee, mineNow); err != nil {
+		return err
+	}
+	fmt.Printf("File hash: %x\n", data[len(notaryTag):])
+	fmt.Printf("Notarized in transaction %x\n", tx.ID)
+	return nil
+}
+
+// verifyNotary reports the blocks that committed to the file at path.
+func (cli *CommandLine) verifyNotary(path, nodeId string) error {
+	data, err := notaryData(path)
+	if err != nil {
+		return err
+	}
+	chain, err := blockchain.ContinueBlockChain(nodeId)
+	if err != nil {
+		return err
+	}
+	defer chain.Database.Close()
+
+	found, err := chain.FindData(data)
+	if err != nil {
+		return err
+	}
+	if len(found) == 0 {
+		return fmt.Errorf("%w: hash %x", errNotNotarized, data[len(notaryTag):])
+	}
+	height, err := chain.GetBestHeight()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("File hash: %x\n", data[len(notaryTag):])
+	for _, entry := range found {
+		block, err := chain.GetBlock(entry.BlockHash)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Notarized in block %d (%x) at %s by transaction %x, %d confirmations\n",
+			entry.Height, entry.BlockHash, time.Unix(block.Timestamp, 0).UTC().Format(time.RFC3339), entry.TxID, height-entry.Height+1)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package cli
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/nthskyradiated/blockchain-in-golang/blockchain"
+)
+
+var errBadPayment = errors.New("payment must look like ADDRESS:AMOUNT with a positive AMOUNT")
+
+// recipients collects repeated -to flags.
+type recipients []string
+
+func (r *recipients) String() string {
+	return strings.Join(*r, ",")
+}
+
+func (r *recipients) Set(value string) error {
+	*r = append(*r, value)
+	return nil
+}
+
+// payments turns the -to flags into payments. A bare address is paid amount,
+// as send has always done; ADDRESS:AMOUNT carries its own amount.
+func (r recipients) payments(amount int) ([]blockchain.Payment, error) {
+	var payments []blockchain.Payment
+	for _, to := range r {
+		address, value, found := strings.Cut(to, ":")
+		if !found {
+			if amount <= 0 {
+				return nil, fmt.Errorf("%w: %q has no amount and -amount is not set", errBadPayment, to)
+			}
+			payments = append(payments, blockchain.Payment{Address: to, Amount: amount})
+			continue
+		}
+		p, err := parsePayment(address, value)
+		if err != nil {
+			return nil, err
+		}
+		payments = append(payments, p)
+	}
+	return payments, nil
+}
+
+func parsePayment(address, amount string) (blockchain.Payment, error) {
+	address, amount = strings.TrimSpace(address), strings.TrimSpace(amount)
+	value, err := strconv.Atoi(amount)
+	if err != nil || value <= 0 || address == "" {
+		return blockchain.Payment{}, fmt.Errorf("%w: %q", errBadPayment, address+":"+amount)
+	}
+	return blockchain.Payment{Address: address, Amount: value}, nil
+}
+
+// readPayments loads a batch of payments from a JSON file holding a list of
+// {"address": ..., "amount": ...} objects, or from a CSV file with one
+// address,amount row per payment and an optional header row.
+func readPayments(path string) ([]blockchain.Payment, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		var payments []blockchain.Payment
+		if err := json.NewDecoder(f).Decode(&payments); err != nil {
+			return nil, fmt.Errorf("reading %s: %w", path, err)
+		}
+		return payments, nil
+	}
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = 2
+	r.TrimLeadingSpace = true
+	var payments []blockchain.Payment
+	for line := 1; ; line++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			return payments, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", path, err)
+		}
+		if line == 1 && strings.EqualFold(record[0], "address") {
+			continue
+		}
+		p, err := parsePayment(record[0], record[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", path, line, err)
+		}
+		payments = append(payments, p)
+	}
+}
@@ -0,0 +1,66 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/nthskyradiated/blockchain-in-golang/blockchain"
+	"github.com/nthskyradiated/blockchain-in-golang/wallet"
+)
+
+// exportMnemonic prints the words that back up every key derived from our
+// seed.
+func (cli *CommandLine) exportMnemonic(nodeId string) error {
+	wallets, err := wallet.NewWallets(nodeId)
+	if err != nil {
+		return err
+	}
+	mnemonic, err := wallets.Mnemonic()
+	if err != nil {
+		return err
+	}
+	fmt.Println(mnemonic)
+	imported, err := wallets.Imported()
+	if err != nil {
+		return err
+	}
+	if imported > 0 {
+		fmt.Printf("%d keys in the wallet file are not derived from the seed and still need the wallet file backed up\n", imported)
+	}
+	return nil
+}
+
+// restoreWallet regenerates the keys of mnemonic, looking through the address
+// index for the ones that have been used, and shows what they hold.
+func (cli *CommandLine) restoreWallet(mnemonic, nodeId string) error {
+	chain, err := blockchain.ContinueBlockChain(nodeId)
+	if err != nil {
+		return err
+	}
+	defer chain.Database.Close()
+	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
+
+	wallets, err := wallet.NewWallets(nodeId)
+	if err != nil {
+		return err
+	}
+	addresses, err := wallets.Restore(mnemonic, func(pubKeyHash []byte) (bool, error) {
+		history, err := chain.AddressHistory(pubKeyHash)
+		return len(history) > 0, err
+	}, nodeId)
+	if err != nil {
+		return err
+	}
+
+	total := 0
+	for _, address := range addresses {
+		pubKeyHash, _ := wallet.PubKeyHashFromAddress(address)
+		mature, immature, err := UTXOSet.Balance(pubKeyHash)
+		if err != nil {
+			return err
+		}
+		total += mature + immature
+		fmt.Printf("%s: %d\n", address, mature+immature)
+	}
+	fmt.Printf("Restored %d addresses holding %d\n", len(addresses), total)
+	return nil
+}
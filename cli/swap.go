@@ -0,0 +1,271 @@
+package cli
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/nthskyradiated/blockchain-in-golang/blockchain"
+	"github.com/nthskyradiated/blockchain-in-golang/wallet"
+)
+
+// runSwap runs the swap subcommands for atomic swaps across two chains. The
+// initiator locks coins to the participant behind the hash of a secret only
+// the initiator knows, and the participant locks coins the other way on the
+// other chain behind the same hash with an earlier deadline. Redeeming the
+// participant's contract reveals the secret, which lets the participant
+// redeem the initiator's.
+func (cli *CommandLine) runSwap(args []string, nodeId string) error {
+	initiateCmd := flag.NewFlagSet("swap initiate", flag.ExitOnError)
+	redeemCmd := flag.NewFlagSet("swap redeem", flag.ExitOnError)
+	refundCmd := flag.NewFlagSet("swap refund", flag.ExitOnError)
+	auditCmd := flag.NewFlagSet("swap audit", flag.ExitOnError)
+
+	initiateFrom := initiateCmd.String("from", "", "Address to fund the contract from, which also gets the refund")
+	initiateTo := initiateCmd.String("to", "", "Address of the other party, who can redeem the contract")
+	initiateAmount := initiateCmd.Int("amount", 0, "Amount to lock in the contract")
+	initiateFee := initiateCmd.Int("fee", 0, "Fee to leave for the miner")
+	initiateDuration := initiateCmd.Duration("duration", 48*time.Hour, "How long until the contract can be refunded; the participant should use half the initiator's")
+	initiateSecretHash := initiateCmd.String("secrethash", "", "Secret hash of the other party's contract, to take part in their swap")
+	initiateMine := initiateCmd.Bool("mine", false, "Mine immediately on the same node")
+	redeemContract := redeemCmd.String("contract", "", "Contract script in hex")
+	redeemTx := redeemCmd.String("txid", "", "ID of the transaction paying to the contract")
+	redeemSecret := redeemCmd.String("secret", "", "Secret in hex")
+	redeemFee := redeemCmd.Int("fee", 0, "Fee to leave for the miner")
+	redeemMine := redeemCmd.Bool("mine", false, "Mine immediately on the same node")
+	refundContract := refundCmd.String("contract", "", "Contract script in hex")
+	refundTx := refundCmd.String("txid", "", "ID of the transaction paying to the contract")
+	refundFee := refundCmd.Int("fee", 0, "Fee to leave for the miner")
+	refundMine := refundCmd.Bool("mine", false, "Mine immediately on the same node")
+	auditContract := auditCmd.String("contract", "", "Contract script in hex")
+	auditTx := auditCmd.String("txid", "", "ID of the transaction paying to the contract")
+
+	if len(args) == 0 {
+		cli.printUsage()
+		return errUsage
+	}
+	switch args[0] {
+	case "initiate":
+		initiateCmd.Parse(args[1:])
+		if *initiateFrom == "" || *initiateTo == "" || *initiateAmount <= 0 || *initiateFee < 0 || *initiateDuration <= 0 {
+			initiateCmd.Usage()
+			return errUsage
+		}
+		return cli.swapInitiate(*initiateFrom, *initiateTo, *initiateAmount, *initiateFee, *initiateDuration, *initiateSecretHash, nodeId, *initiateMine)
+
+	case "redeem":
+		redeemCmd.Parse(args[1:])
+		if *redeemContract == "" || *redeemTx == "" || *redeemSecret == "" || *redeemFee < 0 {
+			redeemCmd.Usage()
+			return errUsage
+		}
+		secret, err := hex.DecodeString(*redeemSecret)
+		if err != nil || len(secret) != blockchain.SecretSize {
+			return fmt.Errorf("%w: -secret must be %d bytes of hex", blockchain.ErrWrongSecret, blockchain.SecretSize)
+		}
+		return cli.swapSpend(*redeemContract, *redeemTx, secret, *redeemFee, nodeId, *redeemMine)
+
+	case "refund":
+		refundCmd.Parse(args[1:])
+		if *refundContract == "" || *refundTx == "" || *refundFee < 0 {
+			refundCmd.Usage()
+			return errUsage
+		}
+		return cli.swapSpend(*refundContract, *refundTx, nil, *refundFee, nodeId, *refundMine)
+
+	case "audit":
+		auditCmd.Parse(args[1:])
+		if *auditContract == "" || *auditTx == "" {
+			auditCmd.Usage()
+			return errUsage
+		}
+		return cli.swapAudit(*auditContract, *auditTx, nodeId)
+	}
+	cli.printUsage()
+	return errUsage
+}
+
+// swapInitiate locks amount from the address from in a contract paying to
+// the address to. Without a secret hash it makes up a new secret and prints
+// it; it must be kept until the other party's contract is redeemed.
+func (cli *CommandLine) swapInitiate(from, to string, amount, fee int, duration time.Duration, secretHashHex, nodeId string, mineNow bool) error {
+	refund, err := walletPubKeyHash(from)
+	if err != nil {
+		return fmt.Errorf("sender: %w", err)
+	}
+	recipient, err := walletPubKeyHash(to)
+	if err != nil {
+		return fmt.Errorf("recipient: %w", err)
+	}
+
+	var secret []byte
+	secretHash, err := hex.DecodeString(secretHashHex)
+	if err != nil || (secretHashHex != "" && len(secretHash) != sha256.Size) {
+		return fmt.Errorf("%w: -secrethash must be %d bytes of hex", blockchain.ErrWrongSecret, sha256.Size)
+	}
+	if secretHashHex == "" {
+		secret = make([]byte, blockchain.SecretSize)
+		if _, err := rand.Read(secret); err != nil {
+			return err
+		}
+		hash := sha256.Sum256(secret)
+		secretHash = hash[:]
+	}
+	contract := blockchain.HTLC{
+		SecretHash: secretHash,
+		Recipient:  recipient,
+		Refund:     refund,
+		LockTime:   uint32(time.Now().Add(duration).Unix()),
+	}
+
+	chain, err := blockchain.ContinueBlockChain(nodeId)
+	if err != nil {
+		return err
+	}
+	defer chain.Database.Close()
+	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
+
+	wallets, err := wallet.NewWallets(nodeId)
+	if err != nil {
+		return err
+	}
+	w, err := wallets.GetWallet(from)
+	if err != nil {
+		return err
+	}
+	selector, _ := blockchain.CoinSelectorByName(blockchain.DefaultStrategy)
+	tx, err := blockchain.NewBatchTransaction(&w, []blockchain.Payment{{Address: contract.Address(), Amount: amount}}, fee, 0, 0, &UTXOSet, selector)
+	if err != nil {
+		return err
+	}
+	if err := submitTx(chain, tx, from, fee, mineNow); err != nil {
+		return err
+	}
+
+	if secret != nil {
+		fmt.Printf("Secret:      %x\n", secret)
+	}
+	fmt.Printf("Secret hash: %x\n", secretHash)
+	fmt.Printf("Contract:    %x\n", contract.Script())
+	fmt.Printf("Contract address: %s\n", contract.Address())
+	fmt.Printf("Refundable after: %s\n", blockchain.LockTimeString(contract.LockTime))
+	fmt.Printf("Contract transaction: %x\n", tx.ID)
+	return nil
+}
+
+// swapSpend redeems the contract with secret, or refunds it without one,
+// paying its value to our key in the contract.
+func (cli *CommandLine) swapSpend(contractHex, txIDHex string, secret []byte, fee int, nodeId string, mineNow bool) error {
+	contract, contractTx, chain, err := loadContract(contractHex, txIDHex, nodeId)
+	if err != nil {
+		return err
+	}
+	defer chain.Database.Close()
+
+	h, _ := blockchain.ParseHTLC(contract)
+	key := h.Refund
+	if secret != nil {
+		key = h.Recipient
+	}
+	wallets, err := wallet.NewWallets(nodeId)
+	if err != nil {
+		return err
+	}
+	w, err := wallets.GetWallet(wallet.PubKeyHashAddress(key))
+	if err != nil {
+		return err
+	}
+
+	tx, err := blockchain.NewHTLCSpend(contractTx, contract, &w, secret, fee)
+	if err != nil {
+		return err
+	}
+	if err := chain.VerifyTransaction(tx); err != nil {
+		return err
+	}
+	if err := submitTx(chain, tx, string(w.Address()), fee, mineNow); err != nil {
+		return err
+	}
+	fmt.Printf("Transaction %x successful!\n", tx.ID)
+	return nil
+}
+
+// swapAudit shows what a contract pays whom and whether it has been redeemed
+// or refunded, along with the secret a redeem revealed.
+func (cli *CommandLine) swapAudit(contractHex, txIDHex, nodeId string) error {
+	contract, contractTx, chain, err := loadContract(contractHex, txIDHex, nodeId)
+	if err != nil {
+		return err
+	}
+	defer chain.Database.Close()
+
+	h, _ := blockchain.ParseHTLC(contract)
+	index, _ := blockchain.ContractOutput(contractTx, contract)
+	fmt.Printf("Contract address: %s\n", h.Address())
+	fmt.Printf("Contract value:   %d\n", contractTx.Outputs[index].Value)
+	fmt.Printf("Recipient:        %s\n", wallet.PubKeyHashAddress(h.Recipient))
+	fmt.Printf("Refund to:        %s\n", wallet.PubKeyHashAddress(h.Refund))
+	fmt.Printf("Secret hash:      %x\n", h.SecretHash)
+	fmt.Printf("Refundable after: %s\n", blockchain.LockTimeString(h.LockTime))
+
+	spend, err := chain.FindContractSpend(contractTx, index)
+	if err != nil {
+		return err
+	}
+	switch {
+	case spend == nil:
+		fmt.Println("Status: unspent")
+	case blockchain.ExtractSecret(spend.Inputs[0]) != nil:
+		fmt.Printf("Status: redeemed in %x\n", spend.ID)
+		fmt.Printf("Secret: %x\n", blockchain.ExtractSecret(spend.Inputs[0]))
+	default:
+		fmt.Printf("Status: refunded in %x\n", spend.ID)
+	}
+	return nil
+}
+
+// loadContract parses a contract and finds the transaction paying to it. The
+// caller closes the chain.
+func loadContract(contractHex, txIDHex, nodeId string) ([]byte, *blockchain.Transaction, *blockchain.BlockChain, error) {
+	contract, err := hex.DecodeString(contractHex)
+	if err != nil {
+		return nil, nil, nil, blockchain.ErrNotHTLC
+	}
+	if _, err := blockchain.ParseHTLC(contract); err != nil {
+		return nil, nil, nil, err
+	}
+	txID, err := hex.DecodeString(txIDHex)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("%w: %q", blockchain.ErrTxNotFound, txIDHex)
+	}
+
+	chain, err := blockchain.ContinueBlockChain(nodeId)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	contractTx, err := chain.FindTransaction(txID)
+	if err == nil {
+		_, err = blockchain.ContractOutput(&contractTx, contract)
+	}
+	if err != nil {
+		chain.Database.Close()
+		return nil, nil, nil, err
+	}
+	return contract, &contractTx, chain, nil
+}
+
+// walletPubKeyHash returns the pubkey hash of a wallet address, turning away
+// multisig addresses.
+func walletPubKeyHash(address string) ([]byte, error) {
+	version, pubKeyHash, err := wallet.DecodeAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	if version == wallet.MultisigVersion {
+		return nil, fmt.Errorf("%w: %s is a multisig address", wallet.ErrInvalidAddress, address)
+	}
+	return pubKeyHash, nil
+}
@@ -0,0 +1,278 @@
+package cli
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+
+	"github.com/nthskyradiated/blockchain-in-golang/blockchain"
+	"github.com/nthskyradiated/blockchain-in-golang/wallet"
+)
+
+// runTx runs the tx subcommands, which pass partially signed transactions
+// around in PST files so the keys never have to be on a node with the chain.
+func (cli *CommandLine) runTx(args []string, nodeId string) error {
+	createCmd := flag.NewFlagSet("tx create", flag.ExitOnError)
+	signCmd := flag.NewFlagSet("tx sign", flag.ExitOnError)
+	combineCmd := flag.NewFlagSet("tx combine", flag.ExitOnError)
+	inspectCmd := flag.NewFlagSet("tx inspect", flag.ExitOnError)
+	broadcastCmd := flag.NewFlagSet("tx broadcast", flag.ExitOnError)
+
+	createFrom := createCmd.String("from", "", "Address or multisig address to send from")
+	var createTo recipients
+	createCmd.Var(&createTo, "to", "Address to send to, or ADDRESS:AMOUNT; repeat to pay several addresses")
+	createAmount := createCmd.Int("amount", 0, "Amount to send to each -to address without its own amount")
+	createFile := createCmd.String("file", "", "CSV or JSON file listing the addresses and amounts to pay")
+	createFee := createCmd.Int("fee", 0, "Fee to leave for the miner")
+	createStrategy := createCmd.String("strategy", blockchain.DefaultStrategy, "Coin selection strategy: "+blockchain.StrategyNames())
+	createLockTime := createCmd.Uint("locktime", 0, "Block height, or Unix time from 500000000 on, before which the transaction cannot be mined")
+	createSequence := createCmd.Uint("sequence", 0, "Blocks each spent output must be buried under; only outputs that deep are spent")
+	createOut := createCmd.String("out", "", "PST file to write")
+	signIn := signCmd.String("in", "", "PST file to sign")
+	signOut := signCmd.String("out", "", "PST file to write, the -in file by default")
+	combineOut := combineCmd.String("out", "", "PST file to write")
+	inspectIn := inspectCmd.String("in", "", "PST file to show")
+	broadcastIn := broadcastCmd.String("in", "", "Fully signed PST file")
+	broadcastMine := broadcastCmd.Bool("mine", false, "Mine immediately on the same node")
+
+	if len(args) == 0 {
+		cli.printUsage()
+		return errUsage
+	}
+	switch args[0] {
+	case "create":
+		createCmd.Parse(args[1:])
+		if *createFrom == "" || (len(createTo) == 0 && *createFile == "") || *createOut == "" || *createAmount < 0 || *createFee < 0 || *createLockTime > math.MaxUint32 || *createSequence > math.MaxUint32 {
+			createCmd.Usage()
+			return errUsage
+		}
+		payments, err := createTo.payments(*createAmount)
+		if err != nil {
+			return err
+		}
+		if *createFile != "" {
+			batch, err := readPayments(*createFile)
+			if err != nil {
+				return err
+			}
+			payments = append(payments, batch...)
+		}
+		return cli.txCreate(*createFrom, payments, *createFee, uint32(*createLockTime), uint32(*createSequence), *createStrategy, *createOut, nodeId)
+
+	case "sign":
+		signCmd.Parse(args[1:])
+		if *signIn == "" {
+			signCmd.Usage()
+			return errUsage
+		}
+		if *signOut == "" {
+			*signOut = *signIn
+		}
+		return cli.txSign(*signIn, *signOut, nodeId)
+
+	case "combine":
+		combineCmd.Parse(args[1:])
+		if *combineOut == "" || combineCmd.NArg() == 0 {
+			fmt.Println("Usage: tx combine -out FILE PST...")
+			combineCmd.Usage()
+			return errUsage
+		}
+		return cli.txCombine(combineCmd.Args(), *combineOut)
+
+	case "inspect":
+		inspectCmd.Parse(args[1:])
+		if *inspectIn == "" {
+			inspectCmd.Usage()
+			return errUsage
+		}
+		return cli.txInspect(*inspectIn)
+
+	case "broadcast":
+		broadcastCmd.Parse(args[1:])
+		if *broadcastIn == "" {
+			broadcastCmd.Usage()
+			return errUsage
+		}
+		return cli.txBroadcast(*broadcastIn, nodeId, *broadcastMine)
+	}
+	cli.printUsage()
+	return errUsage
+}
+
+// txCreate builds the unsigned transaction paying payments from the address
+// from and writes it to out. No keys are needed, only the redeem script when
+// from is a multisig address.
+func (cli *CommandLine) txCreate(from string, payments []blockchain.Payment, fee int, lockTime, sequence uint32, strategy, out, nodeId string) error {
+	selector, err := blockchain.CoinSelectorByName(strategy)
+	if err != nil {
+		return err
+	}
+	version, _, err := wallet.DecodeAddress(from)
+	if err != nil {
+		return fmt.Errorf("sender: %w", err)
+	}
+	for _, p := range payments {
+		if _, err := wallet.PubKeyHashFromAddress(p.Address); err != nil {
+			return fmt.Errorf("recipient %s: %w", p.Address, err)
+		}
+	}
+	redeemScripts := make(map[string][]byte)
+	if version == wallet.MultisigVersion {
+		wallets, err := wallet.NewWallets(nodeId)
+		if err != nil {
+			return err
+		}
+		script, err := wallets.GetMultisig(from)
+		if err != nil {
+			return err
+		}
+		redeemScripts[hex.EncodeToString(wallet.PublicKeyHash(script))] = script
+	}
+
+	chain, err := blockchain.ContinueBlockChain(nodeId)
+	if err != nil {
+		return err
+	}
+	defer chain.Database.Close()
+	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
+
+	tx, err := blockchain.NewUnsignedTransaction(from, payments, fee, lockTime, sequence, &UTXOSet, selector)
+	if err != nil {
+		return err
+	}
+	partial, err := chain.NewPartialTx(tx, redeemScripts)
+	if err != nil {
+		return err
+	}
+	if err := writePartial(out, partial); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote transaction %x to %s, %d signatures missing\n", tx.ID, out, partial.Missing())
+	return nil
+}
+
+// txSign adds the signatures of the keys in our wallet file. It does not
+// touch the chain, so it works on a machine that has never synced.
+func (cli *CommandLine) txSign(in, out, nodeId string) error {
+	partial, err := readPartial(in)
+	if err != nil {
+		return err
+	}
+	wallets, err := wallet.NewWallets(nodeId)
+	if err != nil {
+		return err
+	}
+	added := 0
+	for _, w := range wallets.Wallets {
+		n, err := partial.Sign(w.PrivateKey)
+		if err != nil {
+			return err
+		}
+		added += n
+	}
+	if added == 0 {
+		return blockchain.ErrNothingToSign
+	}
+	if err := writePartial(out, partial); err != nil {
+		return err
+	}
+	fmt.Printf("Added %d signatures, %d missing\n", added, partial.Missing())
+	return nil
+}
+
+// txCombine merges the signatures of copies of one PST signed separately.
+func (cli *CommandLine) txCombine(files []string, out string) error {
+	partial, err := readPartial(files[0])
+	if err != nil {
+		return err
+	}
+	for _, file := range files[1:] {
+		other, err := readPartial(file)
+		if err != nil {
+			return err
+		}
+		if err := partial.Combine(other); err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+	}
+	if err := writePartial(out, partial); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %s, %d signatures missing\n", out, partial.Missing())
+	return nil
+}
+
+func (cli *CommandLine) txInspect(file string) error {
+	partial, err := readPartial(file)
+	if err != nil {
+		return err
+	}
+	fmt.Println(partial.Tx)
+	for i, input := range partial.Inputs {
+		from := blockchain.ScriptAddress(input.Prevout.ScriptPubKey)
+		if input.RedeemScript != nil {
+			required, keys, _ := blockchain.ParseMultisigScript(input.RedeemScript)
+			fmt.Printf("Input %d spends %d from %s, %d of %d signatures (%d-of-%d multisig)\n", i, input.Prevout.Value, from, len(input.Sigs), required, required, len(keys))
+			continue
+		}
+		fmt.Printf("Input %d spends %d from %s, %d of 1 signatures\n", i, input.Prevout.Value, from, len(input.Sigs))
+	}
+	for i, out := range partial.Tx.Outputs {
+		fmt.Printf("Output %d pays %d to %s\n", i, out.Value, blockchain.ScriptAddress(out.ScriptPubKey))
+	}
+	fmt.Printf("Fee: %d\n", partial.Fee())
+	if missing := partial.Missing(); missing > 0 {
+		fmt.Printf("Missing %d signatures\n", missing)
+	} else {
+		fmt.Println("Complete, ready for tx broadcast")
+	}
+	return nil
+}
+
+// txBroadcast finishes the fully signed transaction in file and sends it to
+// the network, or mines it here paying the reward back to the first input's
+// address with mineNow.
+func (cli *CommandLine) txBroadcast(file, nodeId string, mineNow bool) error {
+	partial, err := readPartial(file)
+	if err != nil {
+		return err
+	}
+	tx, err := partial.Finalize()
+	if err != nil {
+		return err
+	}
+
+	chain, err := blockchain.ContinueBlockChain(nodeId)
+	if err != nil {
+		return err
+	}
+	defer chain.Database.Close()
+	if err := chain.VerifyTransaction(tx); err != nil {
+		return err
+	}
+
+	from := blockchain.ScriptAddress(partial.Inputs[0].Prevout.ScriptPubKey)
+	if err := submitTx(chain, tx, from, partial.Fee(), mineNow); err != nil {
+		return err
+	}
+	fmt.Printf("Transaction %x successful!\n", tx.ID)
+	return nil
+}
+
+func readPartial(file string) (*blockchain.PartialTx, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	partial, err := blockchain.DeserializePartialTx(data)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", file, err)
+	}
+	return partial, nil
+}
+
+func writePartial(file string, partial *blockchain.PartialTx) error {
+	return os.WriteFile(file, partial.Serialize(), 0644)
+}
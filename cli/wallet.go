@@ -0,0 +1,155 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/nthskyradiated/blockchain-in-golang/wallet"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+const (
+	passphraseEnv    = "WALLET_PASSPHRASE"
+	newPassphraseEnv = "WALLET_NEW_PASSPHRASE"
+)
+
+var (
+	errEmptyPassphrase    = errors.New("passphrase is empty")
+	errPassphraseMismatch = errors.New("passphrases do not match")
+)
+
+var (
+	stdin    = bufio.NewReader(os.Stdin)
+	unlocked []byte
+)
+
+// runWallet runs the wallet subcommands, which manage the encryption of the
+// wallet file.
+func (cli *CommandLine) runWallet(args []string, nodeId string) error {
+	encryptCmd := flag.NewFlagSet("wallet encrypt", flag.ExitOnError)
+	changeCmd := flag.NewFlagSet("wallet changepassphrase", flag.ExitOnError)
+
+	if len(args) == 0 {
+		cli.printUsage()
+		return errUsage
+	}
+	switch args[0] {
+	case "encrypt":
+		encryptCmd.Parse(args[1:])
+		return cli.walletEncrypt(nodeId)
+
+	case "changepassphrase":
+		changeCmd.Parse(args[1:])
+		return cli.walletChangePassphrase(nodeId)
+	}
+	cli.printUsage()
+	return errUsage
+}
+
+func (cli *CommandLine) walletEncrypt(nodeId string) error {
+	wallets, err := wallet.NewWallets(nodeId)
+	if err != nil {
+		return err
+	}
+	if wallets.Encrypted() {
+		return wallet.ErrAlreadyEncrypted
+	}
+	passphrase, err := newPassphrase()
+	if err != nil {
+		return err
+	}
+	if err := wallets.Encrypt(passphrase, nodeId); err != nil {
+		return err
+	}
+	fmt.Println("Wallet file encrypted. Commands using it now ask for the passphrase, or read it from " + passphraseEnv)
+	return nil
+}
+
+func (cli *CommandLine) walletChangePassphrase(nodeId string) error {
+	wallets, err := wallet.NewWallets(nodeId)
+	if err != nil {
+		return err
+	}
+	if !wallets.Encrypted() {
+		return wallet.ErrNotEncrypted
+	}
+	passphrase, err := newPassphrase()
+	if err != nil {
+		return err
+	}
+	if err := wallets.ChangePassphrase(passphrase, nodeId); err != nil {
+		return err
+	}
+	fmt.Println("Passphrase changed")
+	return nil
+}
+
+// unlockPassphrase gives the wallet package the passphrase of an encrypted
+// wallet file, from the environment or else asked for once.
+func unlockPassphrase() ([]byte, error) {
+	if unlocked != nil {
+		return unlocked, nil
+	}
+	if env, ok := os.LookupEnv(passphraseEnv); ok {
+		unlocked = []byte(env)
+		return unlocked, nil
+	}
+	passphrase, err := readPassphrase("Wallet passphrase: ")
+	if errors.Is(err, io.EOF) {
+		return nil, wallet.ErrWalletLocked
+	}
+	if err != nil {
+		return nil, err
+	}
+	unlocked = passphrase
+	return unlocked, nil
+}
+
+// newPassphrase asks for a new passphrase twice, unless it is in the
+// environment.
+func newPassphrase() ([]byte, error) {
+	if env, ok := os.LookupEnv(newPassphraseEnv); ok {
+		if env == "" {
+			return nil, errEmptyPassphrase
+		}
+		return []byte(env), nil
+	}
+	passphrase, err := readPassphrase("New passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	if len(passphrase) == 0 {
+		return nil, errEmptyPassphrase
+	}
+	repeated, err := readPassphrase("Repeat passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(passphrase, repeated) {
+		return nil, errPassphraseMismatch
+	}
+	return passphrase, nil
+}
+
+// readPassphrase prompts for a passphrase without echoing it, or reads a line
+// when stdin is not a terminal.
+func readPassphrase(prompt string) ([]byte, error) {
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		line, err := stdin.ReadString('\n')
+		if err != nil && line == "" {
+			return nil, fmt.Errorf("reading passphrase: %w", err)
+		}
+		return []byte(strings.TrimRight(line, "\r\n")), nil
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	passphrase, err := terminal.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	return passphrase, err
+}
@@ -0,0 +1,277 @@
+package mempool
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/nthskyradiated/blockchain-in-golang/blockchain"
+)
+
+const (
+	// DefaultMaxSize is how many bytes of transactions a pool holds, enough
+	// to fill a few blocks.
+	DefaultMaxSize = 3 * blockchain.MaxBlockSize
+	// DefaultMaxAge is how long a transaction may wait to be mined before it
+	// is dropped.
+	DefaultMaxAge = 24 * time.Hour
+)
+
+var (
+	ErrAlreadyInPool = errors.New("transaction is already in the memory pool")
+	ErrConflict      = errors.New("transaction spends an output a pending transaction already spends")
+	ErrPoolFull      = errors.New("memory pool is full of transactions paying a higher fee rate")
+)
+
+// Entry is a pending transaction along with what the pool knows about it.
+type Entry struct {
+	Tx    *blockchain.Transaction
+	Fee   int
+	Size  int
+	Added time.Time
+}
+
+// betterThan orders entries by fee rate and then by age, so the pool mines
+// the best paying transactions and evicts the worst paying ones first.
+func (e *Entry) betterThan(other *Entry) int {
+	// ? Compare fee/size rates by cross multiplying to stay in integers
+	if c := e.Fee*other.Size - other.Fee*e.Size; c != 0 {
+		return c
+	}
+	return other.Added.Compare(e.Added)
+}
+
+// Pool holds the transactions waiting to be mined. Every transaction in it
+// spends unspent outputs of the chain, and no two of them spend the same one.
+// A Pool is safe for concurrent use.
+type Pool struct {
+	chain   *blockchain.BlockChain
+	maxSize int
+	maxAge  time.Duration
+
+	mu      sync.RWMutex
+	entries map[string]*Entry
+	spends  map[string]string
+	size    int
+}
+
+func New(chain *blockchain.BlockChain, maxSize int, maxAge time.Duration) *Pool {
+	return &Pool{
+		chain:   chain,
+		maxSize: maxSize,
+		maxAge:  maxAge,
+		entries: make(map[string]*Entry),
+		spends:  make(map[string]string),
+	}
+}
+
+func outpoint(txID []byte, index int) string {
+	return fmt.Sprintf("%x:%d", txID, index)
+}
+
+// Add validates tx against the UTXO set and adds it to the pool. When the
+// pool is full, transactions paying a lower fee rate are evicted to make room.
+func (p *Pool) Add(tx *blockchain.Transaction) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.expire(time.Now())
+
+	id := hex.EncodeToString(tx.ID)
+	if _, ok := p.entries[id]; ok {
+		return ErrAlreadyInPool
+	}
+	for _, in := range tx.Inputs {
+		if _, ok := p.spends[outpoint(in.ID, in.OutIndex)]; ok {
+			return ErrConflict
+		}
+	}
+	fee, err := p.chain.CheckTransaction(tx)
+	if err != nil {
+		return err
+	}
+	entry := &Entry{tx, fee, len(tx.Serialize()), time.Now()}
+
+	var evict []*Entry
+	if p.size+entry.Size > p.maxSize {
+		worst := slices.SortedFunc(slices.Values(p.all()), (*Entry).betterThan)
+		freed := 0
+		for _, e := range worst {
+			if p.size-freed+entry.Size <= p.maxSize || e.betterThan(entry) >= 0 {
+				break
+			}
+			evict = append(evict, e)
+			freed += e.Size
+		}
+		if p.size-freed+entry.Size > p.maxSize {
+			return ErrPoolFull
+		}
+	}
+	for _, e := range evict {
+		p.remove(e.Tx.ID)
+	}
+
+	p.entries[id] = entry
+	for _, in := range tx.Inputs {
+		p.spends[outpoint(in.ID, in.OutIndex)] = id
+	}
+	p.size += entry.Size
+	return nil
+}
+
+// Remove drops a transaction from the pool and reports whether it was there.
+func (p *Pool) Remove(txID []byte) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.remove(txID)
+}
+
+func (p *Pool) remove(txID []byte) bool {
+	id := hex.EncodeToString(txID)
+	entry, ok := p.entries[id]
+	if !ok {
+		return false
+	}
+	for _, in := range entry.Tx.Inputs {
+		delete(p.spends, outpoint(in.ID, in.OutIndex))
+	}
+	delete(p.entries, id)
+	p.size -= entry.Size
+	return true
+}
+
+// RemoveBlock drops the transactions a newly connected block mined, along
+// with any that spend an output the block spent. It returns how many were
+// dropped.
+func (p *Pool) RemoveBlock(block *blockchain.Block) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	removed := 0
+	for _, tx := range block.Transactions {
+		if p.remove(tx.ID) {
+			removed++
+		}
+		for _, in := range tx.Inputs {
+			if id, ok := p.spends[outpoint(in.ID, in.OutIndex)]; ok {
+				txID, _ := hex.DecodeString(id)
+				p.remove(txID)
+				removed++
+			}
+		}
+	}
+	return removed
+}
+
+// Revalidate checks every pending transaction against the UTXO set again and
+// drops the ones that are no longer valid, as happens after a reorganization.
+// It returns how many were dropped.
+func (p *Pool) Revalidate() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	removed := 0
+	for _, e := range p.all() {
+		if _, err := p.chain.CheckTransaction(e.Tx); err != nil {
+			p.remove(e.Tx.ID)
+			removed++
+		}
+	}
+	return removed
+}
+
+// Expire drops the transactions that have waited longer than the pool's
+// maximum age and returns how many were dropped.
+func (p *Pool) Expire() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.expire(time.Now())
+}
+
+func (p *Pool) expire(now time.Time) int {
+	removed := 0
+	for _, e := range p.all() {
+		if now.Sub(e.Added) > p.maxAge {
+			p.remove(e.Tx.ID)
+			removed++
+		}
+	}
+	return removed
+}
+
+func (p *Pool) all() []*Entry {
+	entries := make([]*Entry, 0, len(p.entries))
+	for _, e := range p.entries {
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// Get returns a pending transaction by its ID.
+func (p *Pool) Get(txID []byte) (*blockchain.Transaction, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	e, ok := p.entries[hex.EncodeToString(txID)]
+	if !ok {
+		return nil, false
+	}
+	return e.Tx, true
+}
+
+func (p *Pool) Has(txID []byte) bool {
+	_, ok := p.Get(txID)
+	return ok
+}
+
+// SpentBy returns the ID of the pending transaction spending an output.
+func (p *Pool) SpentBy(txID []byte, index int) ([]byte, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	id, ok := p.spends[outpoint(txID, index)]
+	if !ok {
+		return nil, false
+	}
+	spender, _ := hex.DecodeString(id)
+	return spender, true
+}
+
+// Entries returns copies of the pending entries, best fee rate first.
+func (p *Pool) Entries() []Entry {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	sorted := slices.SortedFunc(slices.Values(p.all()), func(a, b *Entry) int {
+		return b.betterThan(a)
+	})
+	entries := make([]Entry, len(sorted))
+	for i, e := range sorted {
+		entries[i] = *e
+	}
+	return entries
+}
+
+// Transactions returns the pending transactions, best fee rate first.
+func (p *Pool) Transactions() []*blockchain.Transaction {
+	var txs []*blockchain.Transaction
+	for _, e := range p.Entries() {
+		txs = append(txs, e.Tx)
+	}
+	return txs
+}
+
+// Count returns how many transactions are pending.
+func (p *Pool) Count() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return len(p.entries)
+}
+
+// Size returns the serialized size of the pending transactions in bytes.
+func (p *Pool) Size() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.size
+}
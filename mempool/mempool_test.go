@@ -0,0 +1,242 @@
+package mempool
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/nthskyradiated/blockchain-in-golang/blockchain"
+	"github.com/nthskyradiated/blockchain-in-golang/wallet"
+)
+
+const coinValue = 10
+
+// testChain is a chain whose block 1 splits the genesis reward into coins
+// of coinValue for the pool's transactions to spend.
+type testChain struct {
+	chain  *blockchain.BlockChain
+	alice  *wallet.Wallet
+	bob    string
+	coins  *blockchain.Transaction
+	block1 *blockchain.Block
+}
+
+func newTestChain(t *testing.T, coins int) *testChain {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	if err := os.Mkdir("tmp", 0755); err != nil {
+		t.Fatal(err)
+	}
+	alice, err := wallet.CreateWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := wallet.CreateWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, err := blockchain.NewBlockChain(string(alice.Address()), "mempool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { chain.Database.Close() })
+
+	var payments []blockchain.Payment
+	for range coins {
+		payments = append(payments, blockchain.Payment{Address: string(alice.Address()), Amount: coinValue})
+	}
+	selector, err := blockchain.CoinSelectorByName(blockchain.DefaultStrategy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	UTXO := blockchain.UTXOSet{Blockchain: chain}
+	if err := UTXO.Reindex(); err != nil {
+		t.Fatal(err)
+	}
+	split, err := blockchain.NewBatchTransaction(alice, payments, 0, 0, 0, &UTXO, selector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc := &testChain{chain: chain, alice: alice, bob: string(bob.Address()), coins: split}
+	tc.block1 = tc.mine(t, split)
+	return tc
+}
+
+func (tc *testChain) coinbase(t *testing.T, height int) *blockchain.Transaction {
+	t.Helper()
+	cbTx, err := blockchain.CoinbaseTx(string(tc.alice.Address()), "", height, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cbTx
+}
+
+// mine mines txs into a block on the tip.
+func (tc *testChain) mine(t *testing.T, txs ...*blockchain.Transaction) *blockchain.Block {
+	t.Helper()
+	height, err := tc.chain.GetBestHeight()
+	if err != nil {
+		t.Fatal(err)
+	}
+	txs = append([]*blockchain.Transaction{tc.coinbase(t, height+1)}, txs...)
+	block, err := tc.chain.MineBlock(context.Background(), txs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+// spend pays coin number index to bob, leaving fee.
+func (tc *testChain) spend(t *testing.T, index, fee int) *blockchain.Transaction {
+	t.Helper()
+	out, err := blockchain.NewTXOutput(coinValue-fee, tc.bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := &blockchain.Transaction{
+		Inputs:  []blockchain.TxInput{{ID: tc.coins.ID, OutIndex: index}},
+		Outputs: []blockchain.TxOutput{*out},
+	}
+	if err := tc.chain.SignTransaction(tx, tc.alice.PrivateKey); err != nil {
+		t.Fatal(err)
+	}
+	tx.ID = tx.Hash()
+	return tx
+}
+
+func TestAddRejectsConflicts(t *testing.T) {
+	tc := newTestChain(t, 2)
+	pool := New(tc.chain, DefaultMaxSize, DefaultMaxAge)
+
+	first := tc.spend(t, 0, 1)
+	if err := pool.Add(first); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.Add(first); !errors.Is(err, ErrAlreadyInPool) {
+		t.Errorf("adding it again: err = %v, want %v", err, ErrAlreadyInPool)
+	}
+	// ? A higher fee does not replace the transaction already spending the coin
+	if err := pool.Add(tc.spend(t, 0, 2)); !errors.Is(err, ErrConflict) {
+		t.Errorf("adding a double spend: err = %v, want %v", err, ErrConflict)
+	}
+	if spender, ok := pool.SpentBy(tc.coins.ID, 0); !ok || string(spender) != string(first.ID) {
+		t.Errorf("coin 0 spent by %x, %t, want %x", spender, ok, first.ID)
+	}
+
+	missing := &blockchain.Transaction{
+		Inputs:  []blockchain.TxInput{{ID: make([]byte, 32), OutIndex: 0}},
+		Outputs: first.Outputs,
+	}
+	missing.ID = missing.Hash()
+	if err := pool.Add(missing); !errors.Is(err, blockchain.ErrMissingInput) {
+		t.Errorf("adding a spend of a missing output: err = %v, want %v", err, blockchain.ErrMissingInput)
+	}
+	if err := pool.Add(tc.spend(t, 1, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if pool.Count() != 2 {
+		t.Errorf("pool holds %d transactions, want 2", pool.Count())
+	}
+}
+
+func TestEvictionByFeeRate(t *testing.T) {
+	tc := newTestChain(t, 4)
+	low, high, mid, lowest := tc.spend(t, 0, 1), tc.spend(t, 1, 3), tc.spend(t, 2, 2), tc.spend(t, 3, 1)
+	// ? The spends differ only in their signatures and values, so they all have the same size
+	size := len(low.Serialize())
+	pool := New(tc.chain, 2*size, DefaultMaxAge)
+
+	for _, tx := range []*blockchain.Transaction{low, high, mid} {
+		if err := pool.Add(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if pool.Has(low.ID) || !pool.Has(high.ID) || !pool.Has(mid.ID) {
+		t.Errorf("pool should have evicted only the lowest fee rate")
+	}
+	if err := pool.Add(lowest); !errors.Is(err, ErrPoolFull) {
+		t.Errorf("adding a lower fee rate to a full pool: err = %v, want %v", err, ErrPoolFull)
+	}
+	if pool.Size() != 2*size {
+		t.Errorf("pool size = %d, want %d", pool.Size(), 2*size)
+	}
+
+	entries := pool.Entries()
+	if len(entries) != 2 || entries[0].Fee != 3 || entries[1].Fee != 2 {
+		t.Errorf("entries by fee rate = %+v, want the fee 3 one first and then fee 2", entries)
+	}
+}
+
+func TestRemoveBlock(t *testing.T) {
+	tc := newTestChain(t, 3)
+	pool := New(tc.chain, DefaultMaxSize, DefaultMaxAge)
+
+	mined, conflicting, unrelated := tc.spend(t, 0, 1), tc.spend(t, 1, 1), tc.spend(t, 2, 1)
+	for _, tx := range []*blockchain.Transaction{mined, conflicting, unrelated} {
+		if err := pool.Add(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	block := tc.mine(t, mined, tc.spend(t, 1, 2))
+
+	if removed := pool.RemoveBlock(block); removed != 2 {
+		t.Errorf("RemoveBlock removed %d transactions, want 2", removed)
+	}
+	if pool.Has(mined.ID) || pool.Has(conflicting.ID) || !pool.Has(unrelated.ID) {
+		t.Errorf("pool should keep only the transaction the block does not touch")
+	}
+	if _, ok := pool.SpentBy(tc.coins.ID, 1); ok {
+		t.Errorf("coin 1 is still marked as spent by the pool")
+	}
+}
+
+func TestRevalidateAfterReorg(t *testing.T) {
+	tc := newTestChain(t, 2)
+	pool := New(tc.chain, DefaultMaxSize, DefaultMaxAge)
+
+	replaced, kept := tc.spend(t, 0, 1), tc.spend(t, 1, 1)
+	for _, tx := range []*blockchain.Transaction{replaced, kept} {
+		if err := pool.Add(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tip := tc.mine(t)
+
+	// ? A longer branch from block 1 spends coin 0 differently
+	side := tc.block1
+	for height, txs := range [][]*blockchain.Transaction{{tc.spend(t, 0, 2)}, nil} {
+		txs = append([]*blockchain.Transaction{tc.coinbase(t, height+2)}, txs...)
+		block, err := blockchain.CreateBlock(context.Background(), txs, side.Hash, height+2, blockchain.InitialBits, tip.Timestamp+int64(height))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tc.chain.AddBlock(block); err != nil {
+			t.Fatal(err)
+		}
+		side = block
+	}
+	if string(tc.chain.LastHash) != string(side.Hash) {
+		t.Fatalf("tip = %x, want the side branch %x", tc.chain.LastHash, side.Hash)
+	}
+
+	if removed := pool.Revalidate(); removed != 1 {
+		t.Errorf("Revalidate removed %d transactions, want 1", removed)
+	}
+	if pool.Has(replaced.ID) || !pool.Has(kept.ID) {
+		t.Errorf("pool should drop the spend of coin 0 and keep the one of coin 1")
+	}
+}
+
+func TestExpire(t *testing.T) {
+	tc := newTestChain(t, 1)
+	pool := New(tc.chain, DefaultMaxSize, time.Hour)
+	tx := tc.spend(t, 0, 1)
+	if err := pool.Add(tx); err != nil {
+		t.Fatal(err)
+	}
+	if removed := pool.expire(time.Now().Add(2 * time.Hour)); removed != 1 || pool.Count() != 0 {
+		t.Errorf("expire removed %d transactions, %d left, want 1 and 0", removed, pool.Count())
+	}
+}
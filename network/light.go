@@ -0,0 +1,169 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"syscall"
+
+	"github.com/nthskyradiated/blockchain-in-golang/blockchain"
+	"github.com/nthskyradiated/blockchain-in-golang/utils"
+	"github.com/nthskyradiated/blockchain-in-golang/wallet"
+	DEATH "github.com/vrecan/death/v3"
+)
+
+// A light node only keeps block headers. It syncs them from KnownNodes[0],
+// then asks for Merkle proofs of the transactions paying its addresses and
+// checks them against the headers it has verified itself.
+
+func SendLightVersion(addr string, headers *blockchain.HeaderChain) error {
+	bestHeight, err := headers.BestHeight()
+	if err != nil {
+		return err
+	}
+	payload := utils.Serialize(Version{version, bestHeight, nodeAddress})
+	request := append(CmdToBytes("version"), payload...)
+
+	SendData(addr, request)
+	return nil
+}
+
+func HandleHeaders(request []byte, headers *blockchain.HeaderChain, pubKeyHashes [][]byte) error {
+	payload, err := utils.DecodePayload[Headers](request, commandLength)
+	if err != nil {
+		return err
+	}
+
+	for _, data := range payload.Headers {
+		header, err := blockchain.DeserializeHeader(data)
+		if err != nil {
+			return err
+		}
+		if err := headers.AddHeader(header); err != nil {
+			return err
+		}
+	}
+	bestHeight, err := headers.BestHeight()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Received %d headers, best height is %d\n", len(payload.Headers), bestHeight)
+
+	if len(payload.Headers) == maxHeaders {
+		SendGetHeaders(payload.AddrFrom, headers.LastHash)
+		return nil
+	}
+	SendGetProofs(payload.AddrFrom, pubKeyHashes)
+	return nil
+}
+
+func HandleProofs(request []byte, headers *blockchain.HeaderChain, pubKeyHashes [][]byte) error {
+	payload, err := utils.DecodePayload[Proofs](request, commandLength)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range payload.Proofs {
+		tx, err := blockchain.DeserializeTransaction(p.Transaction)
+		if err != nil {
+			return err
+		}
+
+		confirmations, err := headers.VerifyTxProof(blockchain.TxProof{BlockHash: p.BlockHash, Transaction: tx, Proof: p.Proof})
+		if err != nil {
+			fmt.Printf("Rejected proof for transaction %x: %v\n", tx.ID, err)
+			continue
+		}
+
+		for i, out := range tx.Outputs {
+			for _, pubKeyHash := range pubKeyHashes {
+				if out.IsLockedWithKey(pubKeyHash) {
+					fmt.Printf("Verified payment of %d in transaction %x output %d (block %x, %d confirmations)\n",
+						out.Value, tx.ID, i, p.BlockHash, confirmations)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func HandleLightConnection(conn net.Conn, headers *blockchain.HeaderChain, pubKeyHashes [][]byte) {
+	defer conn.Close()
+
+	command, req, err := readCommand(conn)
+	if err != nil {
+		fmt.Printf("Dropping request from %s: %v\n", conn.RemoteAddr(), err)
+		return
+	}
+	fmt.Printf("Received %s command\n", command)
+
+	switch command {
+	case "headers":
+		err = HandleHeaders(req, headers, pubKeyHashes)
+	case "proofs":
+		err = HandleProofs(req, headers, pubKeyHashes)
+	case "inv":
+		var payload Inv
+		payload, err = utils.DecodePayload[Inv](req, commandLength)
+		if err == nil && payload.Type == "block" {
+			SendGetHeaders(payload.AddrFrom, headers.LastHash)
+		}
+	case "version":
+	default:
+		fmt.Println("Ignored on a light node")
+	}
+	if err != nil {
+		fmt.Printf("Failed to handle %s command: %v\n", command, err)
+	}
+}
+
+func StartLightServer(nodeID string, addresses []string) error {
+	var pubKeyHashes [][]byte
+	for _, address := range addresses {
+		pubKeyHash, err := wallet.PubKeyHashFromAddress(address)
+		if err != nil {
+			return err
+		}
+		pubKeyHashes = append(pubKeyHashes, pubKeyHash)
+	}
+
+	nodeAddress = fmt.Sprintf("localhost:%s", nodeID)
+	ln, err := net.Listen(protocol, nodeAddress)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+
+	headers, err := blockchain.OpenHeaderChain(nodeID)
+	if err != nil {
+		return err
+	}
+	defer headers.Database.Close()
+	go CloseHeaderDB(headers)
+
+	// ? The version message only registers us with the full node, headers are
+	// requested straight away
+	if err := SendLightVersion(KnownNodes[0], headers); err != nil {
+		return err
+	}
+	SendGetHeaders(KnownNodes[0], headers.LastHash)
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go HandleLightConnection(conn, headers, pubKeyHashes)
+	}
+}
+
+func CloseHeaderDB(headers *blockchain.HeaderChain) {
+	d := DEATH.NewDeath(syscall.SIGINT, syscall.SIGTERM, os.Interrupt)
+
+	d.WaitForDeathWithFunc(func() {
+		defer os.Exit(1)
+		defer runtime.Goexit()
+		headers.Database.Close()
+	})
+}
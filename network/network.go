@@ -2,16 +2,18 @@ package network
 
 import (
 	"bytes"
-	"encoding/gob"
-	"encoding/hex"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"os"
 	"runtime"
 	"slices"
+	"sync"
 	"syscall"
 	"github.com/nthskyradiated/blockchain-in-golang/blockchain"
+	"github.com/nthskyradiated/blockchain-in-golang/mempool"
 	"github.com/nthskyradiated/blockchain-in-golang/utils"
 	DEATH "github.com/vrecan/death/v3"
 )
@@ -20,6 +22,13 @@ const (
 	protocol = "tcp"
 	version = 1
 	commandLength = 12
+	maxHeaders = 2000
+)
+
+var (
+	ErrMalformedMessage = errors.New("message is too short to hold a command")
+	ErrUnknownCommand   = errors.New("unknown command")
+	ErrNodeUnavailable  = errors.New("node is not available")
 )
 
 var (
@@ -27,7 +36,10 @@ var (
 	mineAddress     string
 	KnownNodes      = []string{"localhost:3000"}
 	blocksInTransit = [][]byte{}
-	memoryPool      = make(map[string]blockchain.Transaction)
+	memoryPool      *mempool.Pool
+
+	miningMu     sync.Mutex
+	cancelMining context.CancelFunc
 )
 
 type Addr struct {
@@ -43,6 +55,32 @@ type GetBlocks struct {
 	AddrFrom string
 }
 
+type GetHeaders struct {
+	AddrFrom string
+	From     []byte
+}
+
+type Headers struct {
+	AddrFrom string
+	Headers  [][]byte
+}
+
+type GetProofs struct {
+	AddrFrom     string
+	PubKeyHashes [][]byte
+}
+
+type Proofs struct {
+	AddrFrom string
+	Proofs   []Proof
+}
+
+type Proof struct {
+	BlockHash   []byte
+	Transaction []byte
+	Proof       blockchain.MerkleProof
+}
+
 type GetData struct {
 	AddrFrom string
 	Type     string
@@ -115,7 +153,9 @@ func SendBlock(addr string, b *blockchain.Block) {
 	SendData(addr, request)
 }
 
-func SendData(addr string, data []byte) {
+// SendData sends data to addr, dropping addr from the known nodes when it
+// cannot be reached.
+func SendData(addr string, data []byte) error {
 	conn, err := net.Dial(protocol, addr)
 
 	if err != nil {
@@ -130,13 +170,16 @@ func SendData(addr string, data []byte) {
 
 		KnownNodes = updatedNodes
 
-		return
+		return fmt.Errorf("%w: %s", ErrNodeUnavailable, addr)
 	}
 
 	defer conn.Close()
 
-	_, err = io.Copy(conn, bytes.NewReader(data))
-	utils.HandleError(err)
+	if _, err = io.Copy(conn, bytes.NewReader(data)); err != nil {
+		fmt.Printf("sending to %s failed: %v\n", addr, err)
+		return fmt.Errorf("sending to %s: %w", addr, err)
+	}
+	return nil
 }
 
 func SendInv(address, kind string, items [][]byte) {
@@ -154,6 +197,20 @@ func SendGetBlocks(address string) {
 	SendData(address, request)
 }
 
+func SendGetHeaders(address string, from []byte) {
+	payload := utils.Serialize(GetHeaders{nodeAddress, from})
+	request := append(CmdToBytes("getheaders"), payload...)
+
+	SendData(address, request)
+}
+
+func SendGetProofs(address string, pubKeyHashes [][]byte) {
+	payload := utils.Serialize(GetProofs{nodeAddress, pubKeyHashes})
+	request := append(CmdToBytes("getproofs"), payload...)
+
+	SendData(address, request)
+}
+
 func SendGetData(address, kind string, id []byte) {
 	payload := utils.Serialize(GetData{nodeAddress, kind, id})
 	request := append(CmdToBytes("getdata"), payload...)
@@ -161,61 +218,113 @@ func SendGetData(address, kind string, id []byte) {
 	SendData(address, request)
 }
 
-func SendTx(addr string, tnx *blockchain.Transaction) {
+func SendTx(addr string, tnx *blockchain.Transaction) error {
 	data := Tx{nodeAddress, tnx.Serialize()}
 	payload := utils.Serialize(data)
 	request := append(CmdToBytes("tx"), payload...)
 
-	SendData(addr, request)
+	return SendData(addr, request)
 }
 
-func SendVersion(addr string, chain *blockchain.BlockChain) {
-	bestHeight := chain.GetBestHeight()
+func SendVersion(addr string, chain *blockchain.BlockChain) error {
+	bestHeight, err := chain.GetBestHeight()
+	if err != nil {
+		return err
+	}
 	payload := utils.Serialize(Version{version, bestHeight, nodeAddress})
 
 	request := append(CmdToBytes("version"), payload...)
 
 	SendData(addr, request)
+	return nil
 }
 
-func HandleAddr(request []byte) {
-payload := utils.DecodePayload[Addr](request, commandLength)
+func HandleAddr(request []byte) error {
+	payload, err := utils.DecodePayload[Addr](request, commandLength)
+	if err != nil {
+		return err
+	}
 
 	KnownNodes = append(KnownNodes, payload.AddrList...)
 	fmt.Printf("there are %d known nodes\n", len(KnownNodes))
 	RequestBlocks()
+	return nil
 }
 
-func HandleBlock(request []byte, chain *blockchain.BlockChain) {
-payload := utils.DecodePayload[Block](request, commandLength)
+func HandleBlock(request []byte, chain *blockchain.BlockChain) error {
+	payload, err := utils.DecodePayload[Block](request, commandLength)
+	if err != nil {
+		return err
+	}
 
 	blockData := payload.Block
-	block := utils.Deserialize[*blockchain.Block](blockData)
+	block, err := blockchain.DeserializeBlock(blockData)
+	if err != nil {
+		return err
+	}
 
 	fmt.Println("Recevied a new block!")
-	chain.AddBlock(block)
+	orphaned, err := chain.AddBlock(block)
+	if errors.Is(err, blockchain.ErrUnknownParent) {
+		// ? The block sits on a branch we have not seen yet, so ask for the peer's whole chain
+		SendGetBlocks(payload.AddrFrom)
+		return nil
+	}
+	if err != nil {
+		// ? Anything still in transit from this peer builds on the rejected block
+		blocksInTransit = [][]byte{}
+		return err
+	}
 
 	fmt.Printf("Added block %x\n", block.Hash)
 
+	if bytes.Equal(chain.LastHash, block.Hash) {
+		AbortMining()
+
+		if len(orphaned) > 0 {
+			// ? The new branch may have mined or spent anything that was pending
+			memoryPool.Revalidate()
+			returned := 0
+			for _, tx := range orphaned {
+				if memoryPool.Add(tx) == nil {
+					returned++
+				}
+			}
+			fmt.Printf("Chain reorganized, %d transactions returned to the memory pool\n", returned)
+		} else {
+			memoryPool.RemoveBlock(block)
+		}
+	}
+
 	if len(blocksInTransit) > 0 {
 		blockHash := blocksInTransit[0]
 		SendGetData(payload.AddrFrom, "block", blockHash)
 
 		blocksInTransit = blocksInTransit[1:]
-	} else {
-		UTXOSet := blockchain.UTXOSet{Blockchain: chain}
-		UTXOSet.Reindex()
 	}
+	return nil
 }
 
-func HandleInv(request []byte, chain *blockchain.BlockChain) {
-payload := utils.DecodePayload[Inv](request, commandLength)
+func HandleInv(request []byte, chain *blockchain.BlockChain) error {
+	payload, err := utils.DecodePayload[Inv](request, commandLength)
+	if err != nil {
+		return err
+	}
 	fmt.Printf("Recevied inventory with %d %s\n", len(payload.Items), payload.Type)
 
 	if payload.Type == "block" {
-		blocksInTransit = payload.Items
+		// ? Inventory lists the tip first, but blocks can only be added after their parent
+		blocksInTransit = [][]byte{}
+		for _, b := range slices.Backward(payload.Items) {
+			if _, err := chain.GetBlock(b); err != nil {
+				blocksInTransit = append(blocksInTransit, b)
+			}
+		}
+		if len(blocksInTransit) == 0 {
+			return nil
+		}
 
-		blockHash := payload.Items[0]
+		blockHash := blocksInTransit[0]
 		SendGetData(payload.AddrFrom, "block", blockHash)
 
 		newInTransit := [][]byte{}
@@ -227,56 +336,112 @@ payload := utils.DecodePayload[Inv](request, commandLength)
 		blocksInTransit = newInTransit
 	}
 
-	if payload.Type == "tx" {
+	if payload.Type == "tx" && len(payload.Items) > 0 {
 		txID := payload.Items[0]
 
-		if memoryPool[hex.EncodeToString(txID)].ID == nil {
+		if !memoryPool.Has(txID) {
 			SendGetData(payload.AddrFrom, "tx", txID)
 		}
 	}
+	return nil
 }
 
-func HandleGetBlocks(request []byte, chain *blockchain.BlockChain) {
-payload := utils.DecodePayload[GetBlocks](request, commandLength)
+func HandleGetBlocks(request []byte, chain *blockchain.BlockChain) error {
+	payload, err := utils.DecodePayload[GetBlocks](request, commandLength)
+	if err != nil {
+		return err
+	}
 
-	blocks := chain.GetBlockHashes()
+	blocks, err := chain.GetBlockHashes()
+	if err != nil {
+		return err
+	}
 	SendInv(payload.AddrFrom, "block", blocks)
+	return nil
+}
+
+func HandleGetHeaders(request []byte, chain *blockchain.BlockChain) error {
+	payload, err := utils.DecodePayload[GetHeaders](request, commandLength)
+	if err != nil {
+		return err
+	}
+
+	found, err := chain.GetHeaders(payload.From, maxHeaders)
+	if err != nil {
+		return err
+	}
+	var headers [][]byte
+	for _, header := range found {
+		headers = append(headers, header.Serialize())
+	}
+
+	data := utils.Serialize(Headers{nodeAddress, headers})
+	SendData(payload.AddrFrom, append(CmdToBytes("headers"), data...))
+	return nil
 }
 
-func HandleGetData(request []byte, chain *blockchain.BlockChain) {
-	var buff bytes.Buffer
-	var payload GetData
+func HandleGetProofs(request []byte, chain *blockchain.BlockChain) error {
+	payload, err := utils.DecodePayload[GetProofs](request, commandLength)
+	if err != nil {
+		return err
+	}
+
+	found, err := chain.FindPaymentProofs(payload.PubKeyHashes)
+	if err != nil {
+		return err
+	}
+	var proofs []Proof
+	for _, p := range found {
+		proofs = append(proofs, Proof{p.BlockHash, p.Transaction.Serialize(), p.Proof})
+	}
+
+	data := utils.Serialize(Proofs{nodeAddress, proofs})
+	SendData(payload.AddrFrom, append(CmdToBytes("proofs"), data...))
+	return nil
+}
 
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
-	utils.HandleError(err)
+func HandleGetData(request []byte, chain *blockchain.BlockChain) error {
+	payload, err := utils.DecodePayload[GetData](request, commandLength)
+	if err != nil {
+		return err
+	}
 
 	if payload.Type == "block" {
 		block, err := chain.GetBlock([]byte(payload.ID))
 		if err != nil {
-			return
+			return err
 		}
 
 		SendBlock(payload.AddrFrom, &block)
 	}
 
 	if payload.Type == "tx" {
-		txID := hex.EncodeToString(payload.ID)
-		tx := memoryPool[txID]
+		tx, ok := memoryPool.Get(payload.ID)
+		if !ok {
+			return fmt.Errorf("%w: %x", blockchain.ErrTxNotFound, payload.ID)
+		}
 
-		SendTx(payload.AddrFrom, &tx)
+		SendTx(payload.AddrFrom, tx)
 	}
+	return nil
 }
 
-func HandleTx(request []byte, chain *blockchain.BlockChain) {
-payload := utils.DecodePayload[Tx](request, commandLength)
+func HandleTx(request []byte, chain *blockchain.BlockChain) error {
+	payload, err := utils.DecodePayload[Tx](request, commandLength)
+	if err != nil {
+		return err
+	}
 
 	txData := payload.Transaction
-	tx := utils.Deserialize[blockchain.Transaction](txData)
-	memoryPool[hex.EncodeToString(tx.ID)] = tx
+	tx, err := blockchain.DeserializeTransaction(txData)
+	if err != nil {
+		return err
+	}
+	if err := memoryPool.Add(tx); err != nil {
+		return fmt.Errorf("tx %x: %w", tx.ID, err)
+	}
 
-	fmt.Printf("%s, %d", nodeAddress, len(memoryPool))
+	fmt.Printf("%s, %d", nodeAddress, memoryPool.Count())
 
 	if nodeAddress == KnownNodes[0] {
 		for _, node := range KnownNodes {
@@ -285,115 +450,186 @@ payload := utils.DecodePayload[Tx](request, commandLength)
 			}
 		}
 	} else {
-		if len(memoryPool) >= 2 && len(mineAddress) > 0 {
-			MineTx(chain)
+		if memoryPool.Count() >= 2 && len(mineAddress) > 0 {
+			return MineTx(chain)
 		}
 	}
+	return nil
 }
 
-func MineTx(chain *blockchain.BlockChain) {
-	var txs []*blockchain.Transaction
-
-	for id := range memoryPool {
-		fmt.Printf("tx: %s\n", memoryPool[id].ID)
-		tx := memoryPool[id]
-		if chain.VerifyTransaction(&tx) {
-			txs = append(txs, &tx)
-		}
+func MineTx(chain *blockchain.BlockChain) error {
+	candidates := memoryPool.Transactions()
+	for _, tx := range candidates {
+		fmt.Printf("tx: %x\n", tx.ID)
 	}
 
+	txs, fees, err := chain.SelectTransactions(candidates)
+	if err != nil {
+		return err
+	}
 	if len(txs) == 0 {
 		fmt.Println("All Transactions are invalid")
-		return
+		return nil
+	}
+
+	height, err := chain.GetBestHeight()
+	if err != nil {
+		return err
+	}
+	cbTx, err := blockchain.CoinbaseTx(mineAddress, "", height+1, fees)
+	if err != nil {
+		return err
 	}
+	txs = append([]*blockchain.Transaction{cbTx}, txs...)
 
-	cbTx := blockchain.CoinbaseTx(mineAddress, "")
-	txs = append(txs, cbTx)
+	ctx, cancel := context.WithCancel(context.Background())
+	miningMu.Lock()
+	cancelMining = cancel
+	miningMu.Unlock()
 
-	newBlock := chain.MineBlock(txs)
-	UTXOSet  := blockchain.UTXOSet{Blockchain: chain}
-	UTXOSet.Reindex()
+	newBlock, err := chain.MineBlock(ctx, txs)
 
-	fmt.Println("New Block mined")
+	miningMu.Lock()
+	cancelMining = nil
+	miningMu.Unlock()
+	cancel()
 
-	for _, tx := range txs {
-		txID := hex.EncodeToString(tx.ID)
-		delete(memoryPool, txID)
+	if errors.Is(err, context.Canceled) || errors.Is(err, blockchain.ErrStaleBlock) {
+		fmt.Println("Mining aborted, another node found a block first")
+		return nil
+	}
+	if err != nil {
+		return err
 	}
 
+	fmt.Println("New Block mined")
+
+	memoryPool.RemoveBlock(newBlock)
+
 	for _, node := range KnownNodes {
 		if node != nodeAddress {
 			SendInv(node, "block", [][]byte{newBlock.Hash})
 		}
 	}
 
-	if len(memoryPool) > 0 {
-		MineTx(chain)
+	if memoryPool.Count() > 0 {
+		return MineTx(chain)
+	}
+	return nil
+}
+
+// AbortMining stops the block MineTx is currently mining, if any, because a
+// new tip makes it stale.
+func AbortMining() {
+	miningMu.Lock()
+	defer miningMu.Unlock()
+	if cancelMining != nil {
+		cancelMining()
 	}
 }
 
-func HandleVersion(request []byte, chain *blockchain.BlockChain) {
-	payload := utils.DecodePayload[Version](request, commandLength)
+func HandleVersion(request []byte, chain *blockchain.BlockChain) error {
+	payload, err := utils.DecodePayload[Version](request, commandLength)
+	if err != nil {
+		return err
+	}
 
-	bestHeight := chain.GetBestHeight()
+	bestHeight, err := chain.GetBestHeight()
+	if err != nil {
+		return err
+	}
 	otherHeight := payload.BestHeight
 
 	if bestHeight < otherHeight {
 		SendGetBlocks(payload.AddrFrom)
 	} else if bestHeight > otherHeight {
-		SendVersion(payload.AddrFrom, chain)
+		if err := SendVersion(payload.AddrFrom, chain); err != nil {
+			return err
+		}
 	}
 
 	if !NodeIsKnown(payload.AddrFrom) {
 		KnownNodes = append(KnownNodes, payload.AddrFrom)
 	}
+	return nil
 }
-func HandleConnection(conn net.Conn, chain *blockchain.BlockChain) {
+
+// readCommand reads a whole request from conn and returns it with its command.
+func readCommand(conn net.Conn) (string, []byte, error) {
 	req, err := io.ReadAll(conn)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(req) < commandLength {
+		return "", nil, ErrMalformedMessage
+	}
+	return BytesToCmd(req[:commandLength]), req, nil
+}
+
+func HandleConnection(conn net.Conn, chain *blockchain.BlockChain) {
 	defer conn.Close()
-	
-	utils.HandleError(err)
-	command := BytesToCmd(req[:commandLength])
+
+	command, req, err := readCommand(conn)
+	if err != nil {
+		fmt.Printf("Dropping request from %s: %v\n", conn.RemoteAddr(), err)
+		return
+	}
 	fmt.Printf("Received %s command\n", command)
 
 	switch command {
 	case "addr":
-		HandleAddr(req)
+		err = HandleAddr(req)
 	case "block":
-		HandleBlock(req, chain)
+		err = HandleBlock(req, chain)
 	case "inv":
-		HandleInv(req, chain)
+		err = HandleInv(req, chain)
 	case "getblocks":
-		HandleGetBlocks(req, chain)
+		err = HandleGetBlocks(req, chain)
 	case "getdata":
-		HandleGetData(req, chain)
+		err = HandleGetData(req, chain)
+	case "getheaders":
+		err = HandleGetHeaders(req, chain)
+	case "getproofs":
+		err = HandleGetProofs(req, chain)
 	case "tx":
-		HandleTx(req, chain)
+		err = HandleTx(req, chain)
 	case "version":
-		HandleVersion(req, chain)
+		err = HandleVersion(req, chain)
 	default:
-		fmt.Println("Unknown command")
+		err = fmt.Errorf("%w: %q", ErrUnknownCommand, command)
+	}
+	if err != nil {
+		fmt.Printf("Failed to handle %s command: %v\n", command, err)
 	}
-
 }
 
-func StartServer(nodeID, minerAddress string) {
+func StartServer(nodeID, minerAddress string) error {
 	nodeAddress = fmt.Sprintf("localhost:%s", nodeID)
 	mineAddress = minerAddress
 	ln, err := net.Listen(protocol, nodeAddress)
-	utils.HandleError(err)
+	if err != nil {
+		return err
+	}
 	defer ln.Close()
 
-	chain := blockchain.ContinueBlockChain(nodeID)
+	chain, err := blockchain.ContinueBlockChain(nodeID)
+	if err != nil {
+		return err
+	}
 	defer chain.Database.Close()
 	go CloseDB(chain)
+	memoryPool = mempool.New(chain, mempool.DefaultMaxSize, mempool.DefaultMaxAge)
 
 	if nodeAddress != KnownNodes[0] {
-		SendVersion(KnownNodes[0], chain)
+		if err := SendVersion(KnownNodes[0], chain); err != nil {
+			return err
+		}
 	}
 	for {
 		conn, err := ln.Accept()
-		utils.HandleError(err)
+		if err != nil {
+			return err
+		}
 		go HandleConnection(conn, chain)
 
 	}
@@ -3,63 +3,55 @@ package utils
 import (
 	"bytes"
 	"encoding/gob"
-	"log"
+	"errors"
+	"fmt"
 	"github.com/mr-tron/base58"
 )
 
-func HandleError(err error) {
-	if err != nil {
-		log.Panic(err)
-	}
-}
+var (
+	ErrInvalidBase58    = errors.New("invalid Base58 data")
+	ErrMalformedPayload = errors.New("malformed payload")
+)
 
 func Base58Encode(input []byte) []byte {
-	encoded := base58.Encode(input)
-
-	if len(encoded) == 0 {
-		log.Panic("Failed to encode input to Base58")
-	}
-
-	return []byte(encoded)
+	return []byte(base58.Encode(input))
 }
 
-func Base58Decode(input []byte) []byte {
+func Base58Decode(input []byte) ([]byte, error) {
 	decoded, err := base58.Decode(string(input[:]))
-
 	if err != nil {
-		log.Panicf("Failed to decode Base58 input: %v", err)
+		return nil, fmt.Errorf("%w: %v", ErrInvalidBase58, err)
 	}
-
 	if len(decoded) == 0 {
-		log.Panic("Decoded Base58 input is empty")
+		return nil, ErrInvalidBase58
 	}
-	return decoded
+	return decoded, nil
 }
 
+// Serialize gob encodes one of our own types. That only fails for types gob
+// cannot handle at all, which is a programming error, so it panics.
 func Serialize[T any](data T) []byte {
 	var encoded bytes.Buffer
 	encoder := gob.NewEncoder(&encoded)
-	err := encoder.Encode(data)
-	HandleError(err)
+	if err := encoder.Encode(data); err != nil {
+		panic(err)
+	}
 	return encoded.Bytes()
 }
 
-func Deserialize[T any](data []byte) T {
+func Deserialize[T any](data []byte) (T, error) {
 	var result T
 	decoder := gob.NewDecoder(bytes.NewReader(data))
-	err := decoder.Decode(&result)
-	HandleError(err)
-	return result
+	if err := decoder.Decode(&result); err != nil {
+		return result, fmt.Errorf("%w: %v", ErrMalformedPayload, err)
+	}
+	return result, nil
 }
 
-func DecodePayload[T any](request []byte, commandLength int) T {
-    var buff bytes.Buffer
-    var payload T
-
-    buff.Write(request[commandLength:])
-    decoder := gob.NewDecoder(&buff)
-    err := decoder.Decode(&payload)
-    HandleError(err)
-
-    return payload
-}
\ No newline at end of file
+func DecodePayload[T any](request []byte, commandLength int) (T, error) {
+	if len(request) < commandLength {
+		var payload T
+		return payload, ErrMalformedPayload
+	}
+	return Deserialize[T](request[commandLength:])
+}
@@ -0,0 +1,149 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// WalletFileVersion is the version of the wallet file header.
+//
+// A wallet file is "WLT" uint8(version) uint8(flags) and then the protobuf
+// wallets. With flagEncrypted set, the flags are followed by the Argon2id
+// parameters uint32(time) uint32(memory in KiB) uint8(threads), the salt and
+// the AES-GCM nonce, and the wallets are sealed with the key derived from the
+// passphrase, the header being authenticated along with them. Files without
+// the header are read as plain protobuf, as written before it existed.
+const WalletFileVersion = 1
+
+const (
+	flagEncrypted = 1
+	saltSize      = 16
+	nonceSize     = 12
+	keySize       = 32
+)
+
+var (
+	ErrWalletLocked     = errors.New("wallet file is encrypted and no passphrase was given")
+	ErrWrongPassphrase  = errors.New("wrong passphrase for the wallet file")
+	ErrNotEncrypted     = errors.New("wallet file is not encrypted")
+	ErrAlreadyEncrypted = errors.New("wallet file is already encrypted")
+	ErrBadWalletFile    = errors.New("wallet file is damaged or from a newer version")
+)
+
+// Passphrase is asked for the passphrase when an encrypted wallet file is
+// loaded. Without it, encrypted files cannot be opened.
+var Passphrase func() ([]byte, error)
+
+var walletMagic = []byte("WLT")
+
+// kdfParams are the Argon2id costs of deriving a key from a passphrase.
+type kdfParams struct {
+	time    uint32
+	memory  uint32
+	threads uint8
+}
+
+// ? About a tenth of a second and 64 MiB to try a passphrase
+var defaultKDF = kdfParams{time: 3, memory: 64 * 1024, threads: 4}
+
+// maxKDFFactor bounds how much costlier than defaultKDF the parameters of a
+// wallet file may be, so a damaged or crafted header cannot make loading it
+// take hours or all the memory.
+const maxKDFFactor = 4
+
+func (p kdfParams) key(passphrase, salt []byte) []byte {
+	return argon2.IDKey(passphrase, salt, p.time, p.memory, p.threads, keySize)
+}
+
+// encodeWalletFile puts the header on data, encrypting it when passphrase is
+// set.
+func encodeWalletFile(data, passphrase []byte) ([]byte, error) {
+	if passphrase == nil {
+		return append(append(append([]byte{}, walletMagic...), WalletFileVersion, 0), data...), nil
+	}
+
+	header := append(append([]byte{}, walletMagic...), WalletFileVersion, flagEncrypted)
+	header = binary.BigEndian.AppendUint32(header, defaultKDF.time)
+	header = binary.BigEndian.AppendUint32(header, defaultKDF.memory)
+	header = append(header, defaultKDF.threads)
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	header = append(header, salt...)
+
+	aead, err := newAEAD(defaultKDF.key(passphrase, salt))
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	header = append(header, nonce...)
+	return aead.Seal(header, nonce, data, header), nil
+}
+
+// decodeWalletFile checks the header of file and returns the protobuf
+// wallets, asking for the passphrase when they are encrypted. The passphrase
+// is returned too, so the file can be saved the same way.
+func decodeWalletFile(file []byte) ([]byte, []byte, error) {
+	if !bytes.HasPrefix(file, walletMagic) {
+		return file, nil, nil
+	}
+	if len(file) < len(walletMagic)+2 || file[len(walletMagic)] != WalletFileVersion {
+		return nil, nil, ErrBadWalletFile
+	}
+	flags := file[len(walletMagic)+1]
+	rest := file[len(walletMagic)+2:]
+	if flags&flagEncrypted == 0 {
+		return rest, nil, nil
+	}
+
+	if len(rest) < 9+saltSize+nonceSize {
+		return nil, nil, ErrBadWalletFile
+	}
+	params := kdfParams{
+		time:    binary.BigEndian.Uint32(rest),
+		memory:  binary.BigEndian.Uint32(rest[4:]),
+		threads: rest[8],
+	}
+	if params.time == 0 || params.threads == 0 || params.time > defaultKDF.time*maxKDFFactor ||
+		params.memory > defaultKDF.memory*maxKDFFactor || params.threads > defaultKDF.threads*maxKDFFactor {
+		return nil, nil, ErrBadWalletFile
+	}
+	salt := rest[9 : 9+saltSize]
+	nonce := rest[9+saltSize : 9+saltSize+nonceSize]
+	header := file[:len(file)-len(rest)+9+saltSize+nonceSize]
+	if Passphrase == nil {
+		return nil, nil, ErrWalletLocked
+	}
+	passphrase, err := Passphrase()
+	if err != nil {
+		return nil, nil, err
+	}
+	aead, err := newAEAD(params.key(passphrase, salt))
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := aead.Open(nil, nonce, file[len(header):], header)
+	if err != nil {
+		return nil, nil, ErrWrongPassphrase
+	}
+	return data, passphrase, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("wallet file key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,156 @@
+package wallet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+)
+
+const cryptNodeID = "crypt"
+
+// usePassphrase answers the passphrase prompt with passphrase for the test.
+func usePassphrase(t *testing.T, passphrase string) {
+	t.Helper()
+	old := Passphrase
+	t.Cleanup(func() { Passphrase = old })
+	Passphrase = func() ([]byte, error) { return []byte(passphrase), nil }
+}
+
+func chdirTemp(t *testing.T) {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	if err := os.Mkdir("tmp", 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEncryptedWalletFile(t *testing.T) {
+	chdirTemp(t)
+	ws, err := NewWallets(cryptNodeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	address, err := ws.AddWallet(cryptNodeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.Encrypt([]byte("correct horse"), cryptNodeID); err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.Encrypt([]byte("battery staple"), cryptNodeID); !errors.Is(err, ErrAlreadyEncrypted) {
+		t.Errorf("encrypting twice: err = %v, want %v", err, ErrAlreadyEncrypted)
+	}
+	file, err := os.ReadFile(fmt.Sprintf(walletFile, cryptNodeID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(file, ws.Entropy) {
+		t.Fatalf("encrypted wallet file holds the seed entropy in the clear")
+	}
+
+	usePassphrase(t, "correct horse")
+	loaded, err := NewWallets(cryptNodeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loaded.GetWallet(address); err != nil || !bytes.Equal(loaded.Entropy, ws.Entropy) || !loaded.Encrypted() {
+		t.Errorf("loaded wallets do not have the saved key and seed, or are not marked encrypted: %v", err)
+	}
+
+	usePassphrase(t, "battery staple")
+	if _, err := NewWallets(cryptNodeID); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("loading with a wrong passphrase: err = %v, want %v", err, ErrWrongPassphrase)
+	}
+	Passphrase = nil
+	if _, err := NewWallets(cryptNodeID); !errors.Is(err, ErrWalletLocked) {
+		t.Errorf("loading without a passphrase: err = %v, want %v", err, ErrWalletLocked)
+	}
+}
+
+func TestTamperedWalletFile(t *testing.T) {
+	data := []byte("protobuf wallets")
+	file, err := encodeWalletFile(data, []byte("correct horse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	usePassphrase(t, "correct horse")
+	if decoded, _, err := decodeWalletFile(file); err != nil || !bytes.Equal(decoded, data) {
+		t.Fatalf("decodeWalletFile = %q, %v, want %q", decoded, err, data)
+	}
+
+	tamper := func(offset int, fn func(b []byte)) []byte {
+		tampered := append([]byte{}, file...)
+		fn(tampered[offset:])
+		return tampered
+	}
+	setUint32 := func(offset int, v uint32) []byte {
+		return tamper(offset, func(b []byte) { binary.BigEndian.PutUint32(b, v) })
+	}
+	flip := func(offset int) []byte {
+		return tamper(offset, func(b []byte) { b[0] ^= 1 })
+	}
+	// ? The header is WLT, version, flags, time at 5, memory at 9, threads at 13, salt at 14 and nonce at 30
+	for _, c := range []struct {
+		name string
+		file []byte
+		want error
+	}{
+		{"newer version", tamper(3, func(b []byte) { b[0] = WalletFileVersion + 1 }), ErrBadWalletFile},
+		{"truncated header", file[:20], ErrBadWalletFile},
+		{"no passes", setUint32(5, 0), ErrBadWalletFile},
+		{"too many passes", setUint32(5, defaultKDF.time*maxKDFFactor+1), ErrBadWalletFile},
+		{"too much memory", setUint32(9, 1<<30), ErrBadWalletFile},
+		{"too many threads", tamper(13, func(b []byte) { b[0] = 255 }), ErrBadWalletFile},
+		// ? Cheaper parameters than the file was written with derive another key
+		{"fewer passes", setUint32(5, 1), ErrWrongPassphrase},
+		{"salt", flip(14), ErrWrongPassphrase},
+		{"nonce", flip(30), ErrWrongPassphrase},
+		{"ciphertext", flip(len(file) - 1), ErrWrongPassphrase},
+	} {
+		if _, _, err := decodeWalletFile(c.file); !errors.Is(err, c.want) {
+			t.Errorf("%s: err = %v, want %v", c.name, err, c.want)
+		}
+	}
+}
+
+func TestLegacyWalletFile(t *testing.T) {
+	chdirTemp(t)
+	w, err := CreateWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := string(w.Address())
+	// ? Wallet files were plain protobuf before they had a header
+	data, err := proto.Marshal(&SerializableWallets{Wallets: map[string]*SerializableWallet{address: w.ToProtobuf()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(fmt.Sprintf(walletFile, cryptNodeID), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	ws, err := NewWallets(cryptNodeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := ws.GetWallet(address)
+	if err != nil || !bytes.Equal(loaded.PublicKey, w.PublicKey) || ws.Encrypted() {
+		t.Fatalf("legacy wallet %s loaded as %x, %v, encrypted %t", address, loaded.PublicKey, err, ws.Encrypted())
+	}
+
+	if err := ws.SaveFile(cryptNodeID); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.ReadFile(fmt.Sprintf(walletFile, cryptNodeID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(file, walletMagic) {
+		t.Errorf("saving a legacy wallet file does not add the header")
+	}
+}
@@ -0,0 +1,80 @@
+package wallet
+
+import (
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"math/big"
+)
+
+// GapLimit is how many unused addresses in a row a restore looks at before
+// deciding there are no more.
+const GapLimit = 20
+
+const hardened = uint32(0x80000000)
+
+// hdPath is where the keys of a seed are derived, m/44'/1'/0'/0'/i'. Every
+// step is hardened, as SLIP-10 only derives P-256 keys from private keys.
+var hdPath = []uint32{44 | hardened, 1 | hardened, 0 | hardened, 0 | hardened}
+
+// extendedKey is a private key with the chain code its children are derived
+// from.
+type extendedKey struct {
+	key       *big.Int
+	chainCode []byte
+}
+
+// masterKey derives the root of the key tree from a seed as SLIP-10 does for
+// NIST P-256.
+func masterKey(seed []byte) extendedKey {
+	n := elliptic.P256().Params().N
+	mac := hmac.New(sha512.New, []byte("Nist256p1 seed"))
+	mac.Write(seed)
+	I := mac.Sum(nil)
+	for {
+		key := new(big.Int).SetBytes(I[:32])
+		if key.Sign() > 0 && key.Cmp(n) < 0 {
+			return extendedKey{key, I[32:]}
+		}
+		// ? Redo the HMAC over its own output when the key is out of range
+		mac = hmac.New(sha512.New, []byte("Nist256p1 seed"))
+		mac.Write(I)
+		I = mac.Sum(nil)
+	}
+}
+
+// child derives the hardened child of k at index.
+func (k extendedKey) child(index uint32) extendedKey {
+	n := elliptic.P256().Params().N
+	data := make([]byte, 37)
+	k.key.FillBytes(data[1:33])
+	binary.BigEndian.PutUint32(data[33:], index|hardened)
+	for {
+		mac := hmac.New(sha512.New, k.chainCode)
+		mac.Write(data)
+		I := mac.Sum(nil)
+
+		tweak := new(big.Int).SetBytes(I[:32])
+		key := new(big.Int).Add(tweak, k.key)
+		key.Mod(key, n)
+		if tweak.Cmp(n) < 0 && key.Sign() > 0 {
+			return extendedKey{key, I[32:]}
+		}
+		data[0] = 1
+		copy(data[1:33], I[32:])
+	}
+}
+
+// DeriveWallet returns the key at index in the tree of seed.
+func DeriveWallet(seed []byte, index uint32) *Wallet {
+	k := masterKey(seed)
+	for _, step := range hdPath {
+		k = k.child(step)
+	}
+	k = k.child(index)
+
+	// ? Derived keys are always in range
+	w, _ := walletFromD(k.key)
+	return w
+}
@@ -0,0 +1,64 @@
+package wallet
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// The SLIP-10 test vectors for NIST P-256, with the hardened steps of their
+// paths. The second seed's first master key is out of range, so it is
+// derived again.
+func TestSLIP10Vectors(t *testing.T) {
+	tests := []struct {
+		seed      string
+		path      []uint32
+		chainCode string
+		key       string
+	}{
+		{"000102030405060708090a0b0c0d0e0f", nil,
+			"beeb672fe4621673f722f38529c07392fecaa61015c80c34f29ce8b41b3cb6ea",
+			"612091aaa12e22dd2abef664f8a01a82cae99ad7441b7ef8110424915c268bc2"},
+		{"000102030405060708090a0b0c0d0e0f", []uint32{0},
+			"3460cea53e6a6bb5fb391eeef3237ffd8724bf0a40e94943c98b83825342ee11",
+			"6939694369114c67917a182c59ddb8cafc3004e63ca5d3b84403ba8613debc0c"},
+		{"000102030405060708090a0b0c0d0e0f", []uint32{28578},
+			"e94c8ebe30c2250a14713212f6449b20f3329105ea15b652ca5bdfc68f6c65c2",
+			"06f0db126f023755d0b8d86d4591718a5210dd8d024e3e14b6159d63f53aa669"},
+		{"a7305bc8df8d0951f0cb224c0e95d7707cbdf2c6ce7e8d481fec69c7ff5e9446", nil,
+			"7762f9729fed06121fd13f326884c82f59aa95c57ac492ce8c9654e60efd130c",
+			"3b8c18469a4634517d6d0b65448f8e6c62091b45540a1743c5846be55d47d88f"},
+	}
+	for _, tt := range tests {
+		seed, _ := hex.DecodeString(tt.seed)
+		k := masterKey(seed)
+		for _, index := range tt.path {
+			k = k.child(index)
+		}
+		if chainCode := hex.EncodeToString(k.chainCode); chainCode != tt.chainCode {
+			t.Errorf("seed %s path %v: chain code %s, want %s", tt.seed, tt.path, chainCode, tt.chainCode)
+		}
+		if key := hex.EncodeToString(k.key.FillBytes(make([]byte, 32))); key != tt.key {
+			t.Errorf("seed %s path %v: key %s, want %s", tt.seed, tt.path, key, tt.key)
+		}
+	}
+}
+
+func TestDeriveWallet(t *testing.T) {
+	seed := MnemonicSeed(bip39Vectors[0].mnemonic)
+	first, again, second := DeriveWallet(seed, 0), DeriveWallet(seed, 0), DeriveWallet(seed, 1)
+	if !bytes.Equal(first.PublicKey, again.PublicKey) || first.PrivateKey.D.Cmp(again.PrivateKey.D) != 0 {
+		t.Errorf("deriving index 0 twice gives different keys")
+	}
+	if bytes.Equal(first.PublicKey, second.PublicKey) {
+		t.Errorf("indexes 0 and 1 derive the same key")
+	}
+
+	k := masterKey(seed)
+	for _, step := range append(append([]uint32{}, hdPath...), 0) {
+		k = k.child(step)
+	}
+	if first.PrivateKey.D.Cmp(k.key) != 0 {
+		t.Errorf("DeriveWallet(seed, 0) is not the key at m/44'/1'/0'/0'/0'")
+	}
+}
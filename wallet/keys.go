@@ -0,0 +1,103 @@
+package wallet
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// wifVersion prefixes private keys in wallet import format, Base58 with a
+// checksum like addresses.
+const wifVersion = byte(0x80)
+
+var ErrBadKey = errors.New("invalid key")
+
+// EncodeWIF returns the private key of w in wallet import format.
+func EncodeWIF(w *Wallet) string {
+	d := make([]byte, 32)
+	w.PrivateKey.D.FillBytes(d)
+	return string(encodeAddress(wifVersion, d))
+}
+
+// DecodeWIF reads a private key in wallet import format.
+func DecodeWIF(wif string) (*Wallet, error) {
+	version, d, err := decodeCheck(strings.TrimSpace(wif))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadKey, err)
+	}
+	if version != wifVersion || len(d) != 32 {
+		return nil, fmt.Errorf("%w: not a P-256 private key in wallet import format", ErrBadKey)
+	}
+	return walletFromD(new(big.Int).SetBytes(d))
+}
+
+// EncodePEM returns the private key of w as a PKCS#8 PEM block.
+func EncodePEM(w *Wallet) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(&w.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// DecodePEM reads a P-256 private key from a PKCS#8 PEM block, or the SEC 1
+// "EC PRIVATE KEY" blocks OpenSSL writes.
+func DecodePEM(data []byte) (*Wallet, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%w: no PEM block", ErrBadKey)
+	}
+	var key any
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%w: PEM block is a %s", ErrBadKey, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadKey, err)
+	}
+	priv, ok := key.(*ecdsa.PrivateKey)
+	if !ok || priv.Curve != elliptic.P256() {
+		return nil, fmt.Errorf("%w: only P-256 ECDSA keys are supported", ErrBadKey)
+	}
+	return walletFromD(priv.D)
+}
+
+// walletFromD makes the wallet of the private key d.
+func walletFromD(d *big.Int) (*Wallet, error) {
+	curve := elliptic.P256()
+	if d.Sign() <= 0 || d.Cmp(curve.Params().N) >= 0 {
+		return nil, fmt.Errorf("%w: private key out of range", ErrBadKey)
+	}
+	priv := ecdsa.PrivateKey{D: d}
+	priv.PublicKey.Curve = curve
+	priv.PublicKey.X, priv.PublicKey.Y = curve.ScalarBaseMult(d.Bytes())
+	return &Wallet{priv, elliptic.Marshal(curve, priv.PublicKey.X, priv.PublicKey.Y)}, nil
+}
+
+// ParseWatchOnly reads a hex public key or an address to watch, returning
+// the address and the public key when there is one.
+func ParseWatchOnly(key string) (string, []byte, error) {
+	key = strings.TrimSpace(key)
+	if _, _, err := DecodeAddress(key); err == nil {
+		return key, nil, nil
+	}
+	pubKey, err := hex.DecodeString(key)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: %q is neither a hex public key nor an address", ErrBadKey, key)
+	}
+	if x, _ := elliptic.Unmarshal(elliptic.P256(), pubKey); x == nil {
+		return "", nil, fmt.Errorf("%w: not an uncompressed P-256 public key", ErrBadKey)
+	}
+	return PubKeyHashAddress(PublicKeyHash(pubKey)), pubKey, nil
+}
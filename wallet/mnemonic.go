@@ -0,0 +1,107 @@
+package wallet
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	_ "embed"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// EntropySize is the length of the random entropy behind a new seed, which
+// makes for a 24 word mnemonic.
+const EntropySize = 32
+
+var ErrBadMnemonic = errors.New("invalid mnemonic phrase")
+
+// ? The BIP39 English wordlist, so mnemonics move between wallets
+//
+//go:embed english.txt
+var english string
+
+var (
+	wordList  = strings.Fields(english)
+	wordIndex = make(map[string]int, len(wordList))
+)
+
+func init() {
+	for i, word := range wordList {
+		wordIndex[word] = i
+	}
+}
+
+// NewEntropy returns random entropy for a new seed.
+func NewEntropy() ([]byte, error) {
+	entropy := make([]byte, EntropySize)
+	if _, err := rand.Read(entropy); err != nil {
+		return nil, err
+	}
+	return entropy, nil
+}
+
+// EntropyToMnemonic encodes entropy as BIP39 words, 11 bits to a word with a
+// checksum of one bit per 32 bits of entropy at the end.
+func EntropyToMnemonic(entropy []byte) (string, error) {
+	if len(entropy) < 16 || len(entropy) > 32 || len(entropy)%4 != 0 {
+		return "", fmt.Errorf("%w: entropy of %d bytes", ErrBadMnemonic, len(entropy))
+	}
+	hash := sha256.Sum256(entropy)
+	bits := append(append([]byte{}, entropy...), hash[0])
+	count := (len(entropy)*8 + len(entropy)/4) / 11
+
+	words := make([]string, count)
+	for i := range words {
+		index := 0
+		for b := i * 11; b < (i+1)*11; b++ {
+			index = index<<1 | int(bits[b/8]>>(7-b%8)&1)
+		}
+		words[i] = wordList[index]
+	}
+	return strings.Join(words, " "), nil
+}
+
+// MnemonicToEntropy checks the words and checksum of a mnemonic and returns
+// the entropy it encodes.
+func MnemonicToEntropy(mnemonic string) ([]byte, error) {
+	words := strings.Fields(strings.ToLower(mnemonic))
+	if len(words) < 12 || len(words) > 24 || len(words)%3 != 0 {
+		return nil, fmt.Errorf("%w: %d words", ErrBadMnemonic, len(words))
+	}
+	bits := make([]byte, (len(words)*11+7)/8)
+	for i, word := range words {
+		index, ok := wordIndex[word]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown word %q", ErrBadMnemonic, word)
+		}
+		for b := 0; b < 11; b++ {
+			if index>>(10-b)&1 == 1 {
+				bit := i*11 + b
+				bits[bit/8] |= 1 << (7 - bit%8)
+			}
+		}
+	}
+
+	checksumBits := len(words) / 3
+	entropy := bits[:(len(words)*11-checksumBits)/8]
+	hash := sha256.Sum256(entropy)
+	if bits[len(entropy)]>>(8-checksumBits) != hash[0]>>(8-checksumBits) {
+		return nil, fmt.Errorf("%w: bad checksum", ErrBadMnemonic)
+	}
+	return entropy, nil
+}
+
+// MnemonicSeed stretches a mnemonic into the seed keys are derived from.
+func MnemonicSeed(mnemonic string) []byte {
+	return mnemonicSeed(mnemonic, "")
+}
+
+// mnemonicSeed is BIP39's seed derivation, which salts with a passphrase
+// that seeds made here leave empty.
+func mnemonicSeed(mnemonic, passphrase string) []byte {
+	normalized := strings.Join(strings.Fields(strings.ToLower(mnemonic)), " ")
+	return pbkdf2.Key([]byte(normalized), []byte("mnemonic"+passphrase), 2048, 64, sha512.New)
+}
@@ -6,8 +6,9 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
+	"errors"
+	"fmt"
 	"math/big"
-	"log"
 	"github.com/nthskyradiated/blockchain-in-golang/utils"
 	"golang.org/x/crypto/ripemd160"
 )
@@ -15,30 +16,83 @@ import (
 const (
 	checksumLength = 4
 	version = byte(0x00)
+	// MultisigVersion prefixes the addresses of M-of-N multisig scripts, which
+	// encode the hash of the script instead of a pubkey hash.
+	MultisigVersion = byte(0x05)
 )
 
+var ErrInvalidAddress = errors.New("invalid address")
+
 type Wallet struct {
 	PrivateKey ecdsa.PrivateKey
 	PublicKey []byte
 }
 
 func ValidateAddress(address string) bool {
+	_, err := PubKeyHashFromAddress(address)
+	return err == nil
+}
 
-	pubKeyHash := utils.Base58Decode([]byte(address))
-	actualChecksum := pubKeyHash[len(pubKeyHash)-checksumLength:]
-	version := pubKeyHash[0]
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-checksumLength]
-	targetChecksum := Checksum(append([]byte{version}, pubKeyHash...))
+// PubKeyHashFromAddress checks an address and returns the hash it encodes,
+// which is a script hash for multisig addresses.
+func PubKeyHashFromAddress(address string) ([]byte, error) {
+	_, hash, err := DecodeAddress(address)
+	return hash, err
+}
 
-	return bytes.Equal(actualChecksum, targetChecksum)
+// DecodeAddress checks an address and returns its version byte and the hash
+// it encodes.
+func DecodeAddress(address string) (byte, []byte, error) {
+	addressVersion, pubKeyHash, err := decodeCheck(address)
+	if err != nil {
+		return 0, nil, fmt.Errorf("%w: %q %v", ErrInvalidAddress, address, err)
+	}
+	if addressVersion != version && addressVersion != MultisigVersion {
+		return 0, nil, fmt.Errorf("%w: %q has unknown version %#x", ErrInvalidAddress, address, addressVersion)
+	}
+	return addressVersion, pubKeyHash, nil
 }
 
-func (w Wallet) Address() []byte {
-	publicKeyHash := PublicKeyHash(w.PublicKey)
-	versionedPayload := append([]byte{version}, publicKeyHash...)
+// MultisigAddress returns the address paying to a multisig script.
+func MultisigAddress(script []byte) string {
+	return ScriptHashAddress(PublicKeyHash(script))
+}
+
+// PubKeyHashAddress returns the wallet address of a pubkey hash.
+func PubKeyHashAddress(pubKeyHash []byte) string {
+	return string(encodeAddress(version, pubKeyHash))
+}
+
+// ScriptHashAddress returns the multisig address of a script hash.
+func ScriptHashAddress(scriptHash []byte) string {
+	return string(encodeAddress(MultisigVersion, scriptHash))
+}
+
+// decodeCheck splits a Base58 string with a version and checksum, as
+// addresses are encoded, into the version and payload.
+func decodeCheck(encoded string) (byte, []byte, error) {
+	payload, err := utils.Base58Decode([]byte(encoded))
+	if err != nil || len(payload) <= 1+checksumLength {
+		return 0, nil, errors.New("is not Base58 with a checksum")
+	}
+	actualChecksum := payload[len(payload)-checksumLength:]
+	targetChecksum := Checksum(payload[:len(payload)-checksumLength])
+	if !bytes.Equal(actualChecksum, targetChecksum) {
+		return 0, nil, errors.New("has a bad checksum")
+	}
+	return payload[0], payload[1 : len(payload)-checksumLength], nil
+}
+
+func encodeAddress(version byte, hash []byte) []byte {
+	versionedPayload := append([]byte{version}, hash...)
 	checksum := Checksum(versionedPayload)
 	fullPayload := append(versionedPayload, checksum...)
-	address := utils.Base58Encode(fullPayload)
+	return utils.Base58Encode(fullPayload)
+}
+
+func (w Wallet) Address() []byte {
+	publicKeyHash := PublicKeyHash(w.PublicKey)
+	address := encodeAddress(version, publicKeyHash)
 	
 	// fmt.Printf("Pub Key: %x\n", w.PublicKey)
 	// fmt.Printf("Pub hash: %x\n", publicKeyHash)
@@ -47,22 +101,24 @@ func (w Wallet) Address() []byte {
 	return address
 }
 
-func NewKeyPair() (ecdsa.PrivateKey, []byte)  {
+func NewKeyPair() (ecdsa.PrivateKey, []byte, error)  {
 	curve := elliptic.P256()
 	private, err := ecdsa.GenerateKey(curve, rand.Reader)
-
 	if err != nil {
-		log.Panic(err)
+		return ecdsa.PrivateKey{}, nil, err
 	}
 
 	public := elliptic.Marshal(curve, private.PublicKey.X, private.PublicKey.Y)
-	return *private, public
+	return *private, public, nil
 }
 
-func CreateWallet() *Wallet {
-	private, public := NewKeyPair()
+func CreateWallet() (*Wallet, error) {
+	private, public, err := NewKeyPair()
+	if err != nil {
+		return nil, err
+	}
 	wallet := Wallet{private, public}
-	return &wallet
+	return &wallet, nil
 }
 
 func PublicKeyHash(publicKey []byte) []byte {
@@ -71,11 +127,8 @@ func PublicKeyHash(publicKey []byte) []byte {
 	hash := sha256.Sum256(publicKey)
 	// Perform RIPEMD-160 hashing
 	ripemd160Hasher := ripemd160.New()
-	_, err := ripemd160Hasher.Write(hash[:])
-
-	if err != nil {
-		log.Panic(err)
-	}
+	// ? hash.Hash writes never fail
+	ripemd160Hasher.Write(hash[:])
 
 	publicRipMD := ripemd160Hasher.Sum(nil)
 	return publicRipMD
@@ -77,7 +77,11 @@ func (x *SerializableWallet) GetPublicKey() []byte {
 // SerializableWallets definition
 type SerializableWallets struct {
 	state         protoimpl.MessageState         `protogen:"open.v1"`
-	Wallets       map[string]*SerializableWallet `protobuf:"bytes,1,rep,name=wallets,proto3" json:"wallets,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"` // Map of address to wallet
+	Wallets       map[string]*SerializableWallet `protobuf:"bytes,1,rep,name=wallets,proto3" json:"wallets,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`                      // Map of address to wallet
+	Multisigs     map[string][]byte              `protobuf:"bytes,2,rep,name=multisigs,proto3" json:"multisigs,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`                  // Map of multisig address to redeem script
+	Entropy       []byte                         `protobuf:"bytes,3,opt,name=entropy,proto3" json:"entropy,omitempty"`                                                                                                // Entropy of the mnemonic keys are derived from
+	NextIndex     uint32                         `protobuf:"varint,4,opt,name=next_index,json=nextIndex,proto3" json:"next_index,omitempty"`                                                                          // Index of the next key to derive
+	WatchOnly     map[string][]byte              `protobuf:"bytes,5,rep,name=watch_only,json=watchOnly,proto3" json:"watch_only,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"` // Map of watched address to public key, empty for bare addresses
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -119,6 +123,34 @@ func (x *SerializableWallets) GetWallets() map[string]*SerializableWallet {
 	return nil
 }
 
+func (x *SerializableWallets) GetMultisigs() map[string][]byte {
+	if x != nil {
+		return x.Multisigs
+	}
+	return nil
+}
+
+func (x *SerializableWallets) GetEntropy() []byte {
+	if x != nil {
+		return x.Entropy
+	}
+	return nil
+}
+
+func (x *SerializableWallets) GetNextIndex() uint32 {
+	if x != nil {
+		return x.NextIndex
+	}
+	return 0
+}
+
+func (x *SerializableWallets) GetWatchOnly() map[string][]byte {
+	if x != nil {
+		return x.WatchOnly
+	}
+	return nil
+}
+
 var File_wallet_proto protoreflect.FileDescriptor
 
 const file_wallet_proto_rawDesc = "" +
@@ -127,12 +159,24 @@ const file_wallet_proto_rawDesc = "" +
 	"\x12SerializableWallet\x12\"\n" +
 	"\rprivate_key_d\x18\x01 \x01(\fR\vprivateKeyD\x12\x1d\n" +
 	"\n" +
-	"public_key\x18\x02 \x01(\fR\tpublicKey\"\xb1\x01\n" +
+	"public_key\x18\x02 \x01(\fR\tpublicKey\"\xfb\x03\n" +
 	"\x13SerializableWallets\x12B\n" +
-	"\awallets\x18\x01 \x03(\v2(.wallet.SerializableWallets.WalletsEntryR\awallets\x1aV\n" +
+	"\awallets\x18\x01 \x03(\v2(.wallet.SerializableWallets.WalletsEntryR\awallets\x12H\n" +
+	"\tmultisigs\x18\x02 \x03(\v2*.wallet.SerializableWallets.MultisigsEntryR\tmultisigs\x12\x18\n" +
+	"\aentropy\x18\x03 \x01(\fR\aentropy\x12\x1d\n" +
+	"\n" +
+	"next_index\x18\x04 \x01(\rR\tnextIndex\x12I\n" +
+	"\n" +
+	"watch_only\x18\x05 \x03(\v2*.wallet.SerializableWallets.WatchOnlyEntryR\twatchOnly\x1aV\n" +
 	"\fWalletsEntry\x12\x10\n" +
 	"\x03key\x18\x01 \x01(\tR\x03key\x120\n" +
-	"\x05value\x18\x02 \x01(\v2\x1a.wallet.SerializableWalletR\x05value:\x028\x01B=Z;github.com/nthskyradiated/blockchain-in-golang/wallet/protob\x06proto3"
+	"\x05value\x18\x02 \x01(\v2\x1a.wallet.SerializableWalletR\x05value:\x028\x01\x1a<\n" +
+	"\x0eMultisigsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\fR\x05value:\x028\x01\x1a<\n" +
+	"\x0eWatchOnlyEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\fR\x05value:\x028\x01B=Z;github.com/nthskyradiated/blockchain-in-golang/wallet/protob\x06proto3"
 
 var (
 	file_wallet_proto_rawDescOnce sync.Once
@@ -146,20 +190,24 @@ func file_wallet_proto_rawDescGZIP() []byte {
 	return file_wallet_proto_rawDescData
 }
 
-var file_wallet_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
+var file_wallet_proto_msgTypes = make([]protoimpl.MessageInfo, 5)
 var file_wallet_proto_goTypes = []any{
 	(*SerializableWallet)(nil),  // 0: wallet.SerializableWallet
 	(*SerializableWallets)(nil), // 1: wallet.SerializableWallets
 	nil,                         // 2: wallet.SerializableWallets.WalletsEntry
+	nil,                         // 3: wallet.SerializableWallets.MultisigsEntry
+	nil,                         // 4: wallet.SerializableWallets.WatchOnlyEntry
 }
 var file_wallet_proto_depIdxs = []int32{
 	2, // 0: wallet.SerializableWallets.wallets:type_name -> wallet.SerializableWallets.WalletsEntry
-	0, // 1: wallet.SerializableWallets.WalletsEntry.value:type_name -> wallet.SerializableWallet
-	2, // [2:2] is the sub-list for method output_type
-	2, // [2:2] is the sub-list for method input_type
-	2, // [2:2] is the sub-list for extension type_name
-	2, // [2:2] is the sub-list for extension extendee
-	0, // [0:2] is the sub-list for field type_name
+	3, // 1: wallet.SerializableWallets.multisigs:type_name -> wallet.SerializableWallets.MultisigsEntry
+	4, // 2: wallet.SerializableWallets.watch_only:type_name -> wallet.SerializableWallets.WatchOnlyEntry
+	0, // 3: wallet.SerializableWallets.WalletsEntry.value:type_name -> wallet.SerializableWallet
+	4, // [4:4] is the sub-list for method output_type
+	4, // [4:4] is the sub-list for method input_type
+	4, // [4:4] is the sub-list for extension type_name
+	4, // [4:4] is the sub-list for extension extendee
+	0, // [0:4] is the sub-list for field type_name
 }
 
 func init() { file_wallet_proto_init() }
@@ -173,7 +221,7 @@ func file_wallet_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_wallet_proto_rawDesc), len(file_wallet_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   3,
+			NumMessages:   5,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
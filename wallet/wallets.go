@@ -1,6 +1,8 @@
 package wallet
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -10,24 +12,175 @@ import (
 
 const walletFile = "./tmp/wallets_%s.data"
 
+var (
+	ErrWalletNotFound = errors.New("address is not in the wallet file")
+	ErrNoSeed         = errors.New("wallet file has no seed yet")
+	ErrSeedExists     = errors.New("wallet file already has a different seed")
+)
+
+// Wallets holds a node's keys, along with the redeem scripts of the multisig
+// addresses it takes part in. New keys are derived from the seed of the
+// mnemonic whose entropy is Entropy, so the mnemonic backs all of them up.
+// WatchOnly holds addresses we follow without their keys, along with their
+// public keys when imported from one. The file is saved encrypted with
+// passphrase once it is set.
 type Wallets struct {
-	Wallets map[string]*Wallet
+	Wallets    map[string]*Wallet
+	Multisigs  map[string][]byte
+	WatchOnly  map[string][]byte
+	Entropy    []byte
+	NextIndex  uint32
+	passphrase []byte
 }
 
 func NewWallets(nodeId string) (*Wallets, error) {
 	ws := Wallets{}
 	ws.Wallets = make(map[string]*Wallet)
+	ws.Multisigs = make(map[string][]byte)
+	ws.WatchOnly = make(map[string][]byte)
 	err := ws.LoadFile(nodeId)
+	if errors.Is(err, os.ErrNotExist) {
+		// ? No wallet file yet is the same as an empty one
+		err = nil
+	}
 	return &ws, err
 }
 
-func (ws *Wallets) AddWallet(nodeId string) string {
-	wallet := CreateWallet()
+// AddWallet derives the next key from the seed, making up a new seed on first
+// use.
+func (ws *Wallets) AddWallet(nodeId string) (string, error) {
+	if ws.Entropy == nil {
+		entropy, err := NewEntropy()
+		if err != nil {
+			return "", err
+		}
+		ws.Entropy = entropy
+	}
+	mnemonic, err := ws.Mnemonic()
+	if err != nil {
+		return "", err
+	}
+	wallet := DeriveWallet(MnemonicSeed(mnemonic), ws.NextIndex)
+	ws.NextIndex++
 	address := string(wallet.Address())
 	ws.Wallets[address] = wallet
-	ws.SaveFile(nodeId)
+	if err := ws.SaveFile(nodeId); err != nil {
+		return "", err
+	}
 	log.Printf("New wallet created with address: %s", address)
-	return address
+	return address, nil
+}
+
+// ImportWallet adds a key made elsewhere and returns its address. The
+// mnemonic does not cover it, so the wallet file has to be backed up again.
+func (ws *Wallets) ImportWallet(wallet *Wallet, nodeId string) (string, error) {
+	address := string(wallet.Address())
+	ws.Wallets[address] = wallet
+	delete(ws.WatchOnly, address)
+	if err := ws.SaveFile(nodeId); err != nil {
+		return "", err
+	}
+	return address, nil
+}
+
+// ImportWatchOnly follows address without its key. pubKey may be nil.
+func (ws *Wallets) ImportWatchOnly(address string, pubKey []byte, nodeId string) error {
+	if _, ok := ws.Wallets[address]; ok {
+		// ? We already hold the key, which watches it and more
+		return nil
+	}
+	ws.WatchOnly[address] = pubKey
+	return ws.SaveFile(nodeId)
+}
+
+// AddMultisig remembers the redeem script of a multisig address and returns
+// the address.
+func (ws *Wallets) AddMultisig(script []byte, nodeId string) (string, error) {
+	address := MultisigAddress(script)
+	ws.Multisigs[address] = script
+	if err := ws.SaveFile(nodeId); err != nil {
+		return "", err
+	}
+	return address, nil
+}
+
+// Mnemonic returns the words that back up the seed.
+func (ws Wallets) Mnemonic() (string, error) {
+	if ws.Entropy == nil {
+		return "", ErrNoSeed
+	}
+	return EntropyToMnemonic(ws.Entropy)
+}
+
+// Imported counts the keys the mnemonic does not back up, those imported or
+// made before the wallet file had a seed.
+func (ws Wallets) Imported() (int, error) {
+	if ws.Entropy == nil {
+		return len(ws.Wallets), nil
+	}
+	mnemonic, err := ws.Mnemonic()
+	if err != nil {
+		return 0, err
+	}
+	seed := MnemonicSeed(mnemonic)
+	imported := len(ws.Wallets)
+	for index := uint32(0); index < ws.NextIndex; index++ {
+		if _, ok := ws.Wallets[string(DeriveWallet(seed, index).Address())]; ok {
+			imported--
+		}
+	}
+	return imported, nil
+}
+
+// Restore takes the seed of mnemonic and derives its keys until GapLimit in
+// a row are unused, returning the addresses of the keys up to the last one
+// used. A wallet file keeps its own seed, though restoring the same mnemonic
+// again picks up keys used since.
+func (ws *Wallets) Restore(mnemonic string, used func(pubKeyHash []byte) (bool, error), nodeId string) ([]string, error) {
+	entropy, err := MnemonicToEntropy(mnemonic)
+	if err != nil {
+		return nil, err
+	}
+	if ws.Entropy != nil && !bytes.Equal(ws.Entropy, entropy) {
+		return nil, ErrSeedExists
+	}
+
+	seed := MnemonicSeed(mnemonic)
+	var derived []*Wallet
+	last := -1
+	for index := 0; index-last <= GapLimit; index++ {
+		wallet := DeriveWallet(seed, uint32(index))
+		ok, err := used(PublicKeyHash(wallet.PublicKey))
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			last = index
+		}
+		derived = append(derived, wallet)
+	}
+
+	var addresses []string
+	for _, wallet := range derived[:last+1] {
+		address := string(wallet.Address())
+		ws.Wallets[address] = wallet
+		addresses = append(addresses, address)
+	}
+	ws.Entropy = entropy
+	ws.NextIndex = max(ws.NextIndex, uint32(last+1))
+	if err := ws.SaveFile(nodeId); err != nil {
+		return nil, err
+	}
+	return addresses, nil
+}
+
+// GetMultisig returns the redeem script of a multisig address.
+func (ws Wallets) GetMultisig(address string) ([]byte, error) {
+	script, ok := ws.Multisigs[address]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrWalletNotFound, address)
+	}
+	return script, nil
 }
 
 func (ws *Wallets) GetAllAddresses() []string {
@@ -39,14 +192,22 @@ func (ws *Wallets) GetAllAddresses() []string {
 	return addresses
 }
 
-func (ws Wallets) GetWallet(address string) Wallet {
-	return *ws.Wallets[address]
+func (ws Wallets) GetWallet(address string) (Wallet, error) {
+	wallet, ok := ws.Wallets[address]
+	if !ok {
+		return Wallet{}, fmt.Errorf("%w: %s", ErrWalletNotFound, address)
+	}
+	return *wallet, nil
 }
 
-func (ws *Wallets) SaveFile(nodeId string) {
+func (ws *Wallets) SaveFile(nodeId string) error {
 	walletFile := fmt.Sprintf(walletFile, nodeId)
     serialized := &SerializableWallets{
         Wallets: make(map[string]*SerializableWallet),
+        Multisigs: ws.Multisigs,
+        WatchOnly: ws.WatchOnly,
+        Entropy: ws.Entropy,
+        NextIndex: ws.NextIndex,
     }
 
     for addr, wallet := range ws.Wallets {
@@ -55,13 +216,42 @@ func (ws *Wallets) SaveFile(nodeId string) {
 
     data, err := proto.Marshal(serialized)
     if err != nil {
-        log.Panic(err)
+        return err
     }
-
-    err = os.WriteFile(walletFile, data, 0644)
+    data, err = encodeWalletFile(data, ws.passphrase)
     if err != nil {
-        log.Panic(err)
+        return err
     }
+
+    // ? Write a new file and move it over the old one, so a crash cannot leave half a wallet
+    tmpFile := walletFile + ".tmp"
+    if err := os.WriteFile(tmpFile, data, 0600); err != nil {
+        return err
+    }
+    return os.Rename(tmpFile, walletFile)
+}
+
+// Encrypted reports whether the wallet file is saved encrypted.
+func (ws *Wallets) Encrypted() bool {
+	return ws.passphrase != nil
+}
+
+// Encrypt saves the wallet file encrypted with passphrase from now on.
+func (ws *Wallets) Encrypt(passphrase []byte, nodeId string) error {
+	if ws.Encrypted() {
+		return ErrAlreadyEncrypted
+	}
+	ws.passphrase = passphrase
+	return ws.SaveFile(nodeId)
+}
+
+// ChangePassphrase saves the encrypted wallet file under a new passphrase.
+func (ws *Wallets) ChangePassphrase(passphrase []byte, nodeId string) error {
+	if !ws.Encrypted() {
+		return ErrNotEncrypted
+	}
+	ws.passphrase = passphrase
+	return ws.SaveFile(nodeId)
 }
 
 func (ws *Wallets) LoadFile(nodeId string) error {
@@ -74,6 +264,10 @@ func (ws *Wallets) LoadFile(nodeId string) error {
     if err != nil {
         return err
     }
+    data, passphrase, err := decodeWalletFile(data)
+    if err != nil {
+        return err
+    }
 
     serialized := &SerializableWallets{}
     err = proto.Unmarshal(data, serialized)
@@ -87,5 +281,16 @@ func (ws *Wallets) LoadFile(nodeId string) error {
     }
 
     ws.Wallets = wallets
+    ws.Multisigs = serialized.Multisigs
+    ws.Entropy = serialized.Entropy
+    ws.NextIndex = serialized.NextIndex
+    ws.passphrase = passphrase
+    if ws.Multisigs == nil {
+        ws.Multisigs = make(map[string][]byte)
+    }
+    ws.WatchOnly = serialized.WatchOnly
+    if ws.WatchOnly == nil {
+        ws.WatchOnly = make(map[string][]byte)
+    }
     return nil
 }
@@ -0,0 +1,30 @@
+package wallet
+
+import "testing"
+
+func TestImported(t *testing.T) {
+	chdirTemp(t)
+	ws, err := NewWallets(cryptNodeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ws.ImportWallet(newWallet(t), cryptNodeID); err != nil {
+		t.Fatal(err)
+	}
+	if imported, err := ws.Imported(); err != nil || imported != 1 {
+		t.Errorf("Imported() before the seed = %d, %v, want 1", imported, err)
+	}
+
+	for range 3 {
+		if _, err := ws.AddWallet(cryptNodeID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := ws.ImportWallet(newWallet(t), cryptNodeID); err != nil {
+		t.Fatal(err)
+	}
+	// ? Keys imported after the seed count as well, not just those from before it
+	if imported, err := ws.Imported(); err != nil || imported != 2 {
+		t.Errorf("Imported() = %d, %v, want 2", imported, err)
+	}
+}